@@ -0,0 +1,541 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sms-api-service/hub"
+	"sms-api-service/importer"
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// Handler обслуживает административный REST API /admin. Каждый запрос
+// должен нести заголовок Authorization: Bearer <admin_token>.
+type Handler struct {
+	store storage.Backend
+	hub   *hub.Hub
+	token []byte
+	mux   *http.ServeMux
+}
+
+func New(store storage.Backend, smsHub *hub.Hub, token string) *Handler {
+	h := &Handler{
+		store: store,
+		hub:   smsHub,
+		token: []byte(token),
+		mux:   http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /admin/countries", h.listCountries)
+	h.mux.HandleFunc("POST /admin/countries", h.createCountry)
+	h.mux.HandleFunc("GET /admin/countries/{code}", h.getCountry)
+	h.mux.HandleFunc("PUT /admin/countries/{code}", h.updateCountry)
+	h.mux.HandleFunc("DELETE /admin/countries/{code}", h.deleteCountry)
+
+	h.mux.HandleFunc("GET /admin/services", h.listServices)
+	h.mux.HandleFunc("POST /admin/services", h.createService)
+	h.mux.HandleFunc("GET /admin/services/{code}", h.getService)
+	h.mux.HandleFunc("PUT /admin/services/{code}", h.updateService)
+	h.mux.HandleFunc("DELETE /admin/services/{code}", h.deleteService)
+
+	h.mux.HandleFunc("GET /admin/numbers", h.listNumbers)
+	h.mux.HandleFunc("POST /admin/numbers", h.createNumber)
+	h.mux.HandleFunc("POST /admin/numbers/import", h.importNumbers)
+	h.mux.HandleFunc("GET /admin/numbers/{id}", h.getNumber)
+	h.mux.HandleFunc("PUT /admin/numbers/{id}", h.updateNumber)
+	h.mux.HandleFunc("DELETE /admin/numbers/{id}", h.deleteNumber)
+	h.mux.HandleFunc("POST /admin/numbers/{id}/block", h.blockNumber)
+	h.mux.HandleFunc("POST /admin/numbers/{id}/unblock", h.unblockNumber)
+	h.mux.HandleFunc("POST /admin/numbers/{id}/release", h.releaseNumber)
+
+	h.mux.HandleFunc("GET /admin/activations", h.listActivations)
+
+	h.mux.HandleFunc("GET /admin/prices", h.listPrices)
+	h.mux.HandleFunc("PUT /admin/prices/{country}/{operator}/{service}", h.setPrice)
+	h.mux.HandleFunc("DELETE /admin/prices/{country}/{operator}/{service}", h.deletePrice)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), h.token) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) listCountries(w http.ResponseWriter, r *http.Request) {
+	countries, err := h.store.ListCountries()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, countries)
+}
+
+func (h *Handler) getCountry(w http.ResponseWriter, r *http.Request) {
+	country, err := h.store.GetCountry(r.PathValue("code"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, country)
+}
+
+func (h *Handler) createCountry(w http.ResponseWriter, r *http.Request) {
+	var country models.Country
+	if !decodeBody(w, r, &country) {
+		return
+	}
+	if !validCode(country.Code) || country.Name == "" {
+		writeError(w, http.StatusBadRequest, "code and name are required")
+		return
+	}
+
+	created, err := h.store.CreateCountry(country)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) updateCountry(w http.ResponseWriter, r *http.Request) {
+	var country models.Country
+	if !decodeBody(w, r, &country) {
+		return
+	}
+	if country.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	country.Code = r.PathValue("code")
+
+	updated, err := h.store.UpdateCountry(country)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) deleteCountry(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.DeleteCountry(r.PathValue("code")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listServices(w http.ResponseWriter, r *http.Request) {
+	services, err := h.store.ListServices()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, services)
+}
+
+func (h *Handler) getService(w http.ResponseWriter, r *http.Request) {
+	service, err := h.store.GetServiceByCode(r.PathValue("code"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, service)
+}
+
+func (h *Handler) createService(w http.ResponseWriter, r *http.Request) {
+	var service models.Service
+	if !decodeBody(w, r, &service) {
+		return
+	}
+	if !validCode(service.Code) || service.Name == "" {
+		writeError(w, http.StatusBadRequest, "code and name are required")
+		return
+	}
+
+	created, err := h.store.CreateService(service)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) updateService(w http.ResponseWriter, r *http.Request) {
+	var service models.Service
+	if !decodeBody(w, r, &service) {
+		return
+	}
+	if service.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	service.Code = r.PathValue("code")
+
+	updated, err := h.store.UpdateService(service)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) deleteService(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.DeleteService(r.PathValue("code")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listNumbers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := storage.NumberFilter{
+		Country:  query.Get("country"),
+		Operator: query.Get("operator"),
+	}
+
+	var err error
+	if filter.Available, err = parseOptionalBool(query.Get("available")); err != nil {
+		writeError(w, http.StatusBadRequest, "available must be true or false")
+		return
+	}
+	if filter.Limit, filter.Offset, err = parsePage(query.Get("limit"), query.Get("offset")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	numbers, err := h.store.ListNumbers(filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, numbers)
+}
+
+func (h *Handler) getNumber(w http.ResponseWriter, r *http.Request) {
+	numberID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	number, err := h.store.GetNumber(numberID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, number)
+}
+
+type numberRequest struct {
+	Number    uint64  `json:"number"`
+	Country   string  `json:"country"`
+	Operator  *string `json:"operator"`
+	Available *bool   `json:"available"`
+	Tags      *string `json:"tags"`
+}
+
+func (h *Handler) createNumber(w http.ResponseWriter, r *http.Request) {
+	var req numberRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Number == 0 || req.Country == "" || req.Operator == nil {
+		writeError(w, http.StatusBadRequest, "number, country and operator are required")
+		return
+	}
+	operator, err := storage.NormalizeOperator(*req.Operator)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	country, err := h.store.GetCountry(req.Country)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusBadRequest, "unknown country "+req.Country)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !country.MatchesPrefix(req.Number) {
+		writeError(w, http.StatusBadRequest, "number does not match the country prefix")
+		return
+	}
+
+	number := models.PhoneNumber{
+		Number:    req.Number,
+		CountryID: country.ID,
+		Operator:  operator,
+		Available: true,
+	}
+	if req.Available != nil {
+		number.Available = *req.Available
+	}
+	if req.Tags != nil {
+		number.Tags = *req.Tags
+	}
+
+	created, err := h.store.CreateNumber(number)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// updateNumber меняет оператора, доступность и метки номера; поля, отсутствующие в теле, не меняются
+func (h *Handler) updateNumber(w http.ResponseWriter, r *http.Request) {
+	numberID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req numberRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	number, err := h.store.GetNumber(numberID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if req.Operator != nil {
+		operator, err := storage.NormalizeOperator(*req.Operator)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		number.Operator = operator
+	}
+	if req.Available != nil {
+		number.Available = *req.Available
+	}
+	if req.Tags != nil {
+		number.Tags = *req.Tags
+	}
+
+	updated, err := h.store.UpdateNumber(number)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// importNumbers принимает CSV в теле запроса, формат тот же, что у подкоманды import-numbers
+func (h *Handler) importNumbers(w http.ResponseWriter, r *http.Request) {
+	report, err := importer.Import(h.store, http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err == nil {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	// Уже вставленные батчи не откатываются, поэтому вместе с ошибкой
+	// возвращается отчет о них
+	status, message := http.StatusRequestEntityTooLarge, "CSV body exceeds "+strconv.Itoa(maxImportSize)+" bytes"
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		slog.Error("number import interrupted",
+			slog.Int("inserted", report.Inserted), slog.Int("duplicates", report.Duplicates))
+		status, message = storeErrorStatus(err)
+	}
+	writeJSON(w, status, importFailure{Error: message, Report: report})
+}
+
+// importFailure - ответ на прерванный импорт: ошибка и отчет по уже вставленным батчам
+type importFailure struct {
+	Error  string          `json:"error"`
+	Report importer.Report `json:"report"`
+}
+
+func (h *Handler) deleteNumber(w http.ResponseWriter, r *http.Request) {
+	numberID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteNumber(numberID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) blockNumber(w http.ResponseWriter, r *http.Request) {
+	h.setNumberAvailable(w, r, false)
+}
+
+func (h *Handler) unblockNumber(w http.ResponseWriter, r *http.Request) {
+	h.setNumberAvailable(w, r, true)
+}
+
+func (h *Handler) setNumberAvailable(w http.ResponseWriter, r *http.Request, available bool) {
+	numberID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.SetNumberAvailable(numberID, available); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	number, err := h.store.GetNumber(numberID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, number)
+}
+
+// releaseNumber отменяет незавершенные активации номера и сообщает о них подписчикам /events
+func (h *Handler) releaseNumber(w http.ResponseWriter, r *http.Request) {
+	numberID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	released, err := h.store.ReleaseNumber(numberID, now)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	ids := make([]uint64, 0, len(released))
+	for _, activation := range released {
+		h.hub.Publish(hub.Event{
+			Type:         hub.EventActivationFinished,
+			ActivationID: activation.ID,
+			AccountID:    activation.AccountID,
+			Status:       models.ActivationCancelled,
+			Time:         now,
+		})
+		ids = append(ids, activation.ID)
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]uint64{"cancelledActivations": ids})
+}
+
+func (h *Handler) listPrices(w http.ResponseWriter, r *http.Request) {
+	prices, err := h.store.ListPrices()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, prices)
+}
+
+// setPrice задает цену страны, оператора и сервиса из пути; оператор any
+// задает цену для операторов страны без собственной
+func (h *Handler) setPrice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Price *float64 `json:"price"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Price == nil || *req.Price < 0 {
+		writeError(w, http.StatusBadRequest, "price must be a non-negative number")
+		return
+	}
+
+	price := storage.Price{
+		Country:  r.PathValue("country"),
+		Operator: r.PathValue("operator"),
+		Service:  r.PathValue("service"),
+		Price:    *req.Price,
+	}
+	if !validCode(price.Operator) {
+		writeError(w, http.StatusBadRequest, "invalid operator "+price.Operator)
+		return
+	}
+
+	if err := h.store.SetPrice(price); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, price)
+}
+
+func (h *Handler) deletePrice(w http.ResponseWriter, r *http.Request) {
+	err := h.store.DeletePrice(r.PathValue("country"), r.PathValue("operator"), r.PathValue("service"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listActivations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := storage.ActivationFilter{
+		Service: query.Get("service"),
+		Country: query.Get("country"),
+	}
+
+	if value := query.Get("account_id"); value != "" {
+		accountID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "account_id must be an integer")
+			return
+		}
+		filter.AccountID = &accountID
+	}
+	if value := query.Get("status"); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil || !models.IsKnownActivationStatus(status) {
+			writeError(w, http.StatusBadRequest, "status must be an activation status code")
+			return
+		}
+		filter.Status = &status
+	}
+	if value := query.Get("number_id"); value != "" {
+		numberID, err := strconv.Atoi(value)
+		if err != nil || numberID <= 0 {
+			writeError(w, http.StatusBadRequest, "number_id must be a positive integer")
+			return
+		}
+		filter.NumberID = numberID
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseOptionalTime(query.Get("created_after")); err != nil {
+		writeError(w, http.StatusBadRequest, "created_after must be an RFC 3339 time")
+		return
+	}
+	if filter.CreatedBefore, err = parseOptionalTime(query.Get("created_before")); err != nil {
+		writeError(w, http.StatusBadRequest, "created_before must be an RFC 3339 time")
+		return
+	}
+	if filter.Limit, filter.Offset, err = parsePage(query.Get("limit"), query.Get("offset")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	activations, err := h.store.ListActivations(filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, activations)
+}
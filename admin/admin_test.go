@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sms-api-service/hub"
+	"sms-api-service/importer"
+	"sms-api-service/memory"
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+const testToken = "abcdefghijklmnop1234"
+
+// failingImportStore принимает первый батч импорта и отказывает на следующих
+type failingImportStore struct {
+	*memory.Store
+	batches int
+}
+
+func (s *failingImportStore) ImportNumbers(numbers []models.PhoneNumber) (int, error) {
+	s.batches++
+	if s.batches > 1 {
+		return 0, errors.New("disk full")
+	}
+	return s.Store.ImportNumbers(numbers)
+}
+
+func newImportHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	store := memory.New()
+	err := store.Seed(context.Background(), &storage.SeedData{
+		Countries: []models.Country{{Code: "rus", Name: "Russia", Prefix: 7}},
+	})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	return New(&failingImportStore{Store: store}, hub.New(), testToken)
+}
+
+func TestImportNumbersReturnsPartialReportOnStoreError(t *testing.T) {
+	h := newImportHandler(t)
+
+	var csv strings.Builder
+	for i := 0; i < importer.BatchSize+10; i++ {
+		fmt.Fprintf(&csv, "%d,rus,mts\n", 79000000000+i)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/numbers/import", strings.NewReader(csv.String()))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500; body %s", w.Code, w.Body.String())
+	}
+
+	var resp importFailure
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	if resp.Error == "" || resp.Report.Inserted != importer.BatchSize {
+		t.Errorf("response = %+v, want an error and %d inserted numbers", resp, importer.BatchSize)
+	}
+}
+
+func TestPricesCRUD(t *testing.T) {
+	store := memory.New()
+	err := store.Seed(context.Background(), &storage.SeedData{
+		Countries: []models.Country{{Code: "rus", Name: "Russia", Prefix: 7}},
+		Services:  []models.Service{{Code: "tg", Name: "Telegram"}},
+		Prices:    []storage.Price{{Country: "rus", Operator: "any", Service: "tg", Price: 10}},
+	})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	h := New(store, hub.New(), testToken)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+testToken)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPut, "/admin/prices/rus/mts/tg", `{"price": 25}`, http.StatusOK},
+		{http.MethodPut, "/admin/prices/rus/any/tg", `{"price": 12}`, http.StatusOK},
+		{http.MethodPut, "/admin/prices/rus/mts/tg", `{"price": -1}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/prices/rus/mts/tg", `{}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/prices/xxx/mts/tg", `{"price": 5}`, http.StatusNotFound},
+		{http.MethodPut, "/admin/prices/rus/mts/xx", `{"price": 5}`, http.StatusNotFound},
+		{http.MethodDelete, "/admin/prices/rus/beeline/tg", ``, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := do(tt.method, tt.path, tt.body); w.Code != tt.want {
+			t.Errorf("%s %s %s: status = %d, want %d; body %s", tt.method, tt.path, tt.body, w.Code, tt.want, w.Body.String())
+		}
+	}
+
+	var prices []storage.Price
+	if err := json.Unmarshal(do(http.MethodGet, "/admin/prices", "").Body.Bytes(), &prices); err != nil {
+		t.Fatalf("decode prices: %v", err)
+	}
+	want := []storage.Price{
+		{Country: "rus", Operator: "any", Service: "tg", Price: 12},
+		{Country: "rus", Operator: "mts", Service: "tg", Price: 25},
+	}
+	if fmt.Sprint(prices) != fmt.Sprint(want) {
+		t.Errorf("prices = %v, want %v", prices, want)
+	}
+
+	if w := do(http.MethodDelete, "/admin/prices/rus/mts/tg", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", w.Code)
+	}
+	if got, _ := store.ListPrices(); len(got) != 1 {
+		t.Errorf("prices after delete = %v", got)
+	}
+}
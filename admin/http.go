@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sms-api-service/storage"
+)
+
+const (
+	maxBodySize   = 1 << 20
+	maxImportSize = 32 << 20
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeStoreError переводит ошибку хранилища в HTTP-статус
+func writeStoreError(w http.ResponseWriter, err error) {
+	status, message := storeErrorStatus(err)
+	writeError(w, status, message)
+}
+
+// storeErrorStatus возвращает HTTP-статус и текст ошибки хранилища; внутренние
+// ошибки журналируются и наружу не раскрываются
+func storeErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound, err.Error()
+	case errors.Is(err, storage.ErrConflict):
+		return http.StatusConflict, err.Error()
+	default:
+		slog.Error("admin storage operation failed", slog.Any("error", err))
+		return http.StatusInternalServerError, "storage error"
+	}
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "id must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// validCode проверяет код страны или сервиса: латинские буквы в нижнем регистре, цифры и '_'
+func validCode(code string) bool {
+	if code == "" || len(code) > 32 {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseOptionalBool(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func parseOptionalTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parsePage разбирает limit и offset; limit по умолчанию defaultPageLimit, не больше maxPageLimit
+func parsePage(limitValue, offsetValue string) (int, int, error) {
+	limit, offset := defaultPageLimit, 0
+
+	if limitValue != "" {
+		parsed, err := strconv.Atoi(limitValue)
+		if err != nil || parsed <= 0 || parsed > maxPageLimit {
+			return 0, 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		limit = parsed
+	}
+	if offsetValue != "" {
+		parsed, err := strconv.Atoi(offsetValue)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = parsed
+	}
+
+	return limit, offset, nil
+}
@@ -1,15 +1,355 @@
 package config
 
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaxWaitSMSTimeout - наибольшее время, на которое WAIT_SMS удерживает запрос.
+// WriteTimeout должен быть больше, иначе сервер оборвет ответ на долгий WAIT_SMS.
+const MaxWaitSMSTimeout = 10 * time.Second
+
+// Config собирается из значений по умолчанию, YAML-файла, переменных окружения
+// и флагов командной строки; каждый следующий источник перекрывает предыдущий.
 type Config struct {
-	Port   string
-	DBPath string
-	APIKey string
+	Port            string        `yaml:"port"`
+	DBPath          string        `yaml:"db_path"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Storage выбирает хранилище: sqlite (файл DBPath), postgres (PostgresDSN)
+	// или memory (данные в памяти процесса, теряются при остановке)
+	Storage     string `yaml:"storage"`
+	PostgresDSN string `yaml:"postgres_dsn"`
+
+	Database DatabaseConfig `yaml:"database"`
+	Seed     SeedConfig     `yaml:"seed"`
+	// AutoMigrate применяет миграции схемы при старте; иначе их запускает подкоманда migrate
+	AutoMigrate bool `yaml:"auto_migrate"`
+
+	ActivationTTL  time.Duration `yaml:"activation_ttl"`
+	ReaperInterval time.Duration `yaml:"reaper_interval"`
+	// NumberReuseWindow - срок, в течение которого номер не выдается повторно для того же
+	// сервиса. Ноль запрещает повторную выдачу навсегда.
+	NumberReuseWindow time.Duration `yaml:"number_reuse_window"`
+	// Лимиты для ключей без собственных значений. Неположительный RateLimit
+	// и MaxActiveActivations отключают соответствующее ограничение.
+	RateLimit            float64 `yaml:"rate_limit"`
+	RateBurst            int     `yaml:"rate_burst"`
+	MaxActiveActivations int     `yaml:"max_active_activations"`
+
+	// AdminToken открывает административный API /admin (заголовок Authorization: Bearer).
+	// Пустое значение отключает административный API.
+	AdminToken string `yaml:"admin_token"`
 }
 
-func Load() Config {
+// DatabaseConfig содержит прагмы SQLite и параметры пула соединений;
+// для PostgreSQL используются только параметры пула
+type DatabaseConfig struct {
+	JournalMode     string        `yaml:"journal_mode"`
+	Timeout         int           `yaml:"timeout"`
+	Synchronous     string        `yaml:"synchronous"`
+	CacheSize       int           `yaml:"cache_size"`
+	BusyTimeout     int           `yaml:"busy_timeout"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// SeedConfig управляет заполнением базы при старте
+type SeedConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DemoKey добавляет к заполнению счет default и общеизвестный ключ qwerty123
+	DemoKey    bool `yaml:"demo_key"`
+	NumbersMin int  `yaml:"numbers_min"`
+	NumbersMax int  `yaml:"numbers_max"`
+}
+
+func Default() Config {
 	return Config{
-		Port:   "8080",
-		DBPath: "./sms_service.db",
-		APIKey: "qwerty123",
+		Port:            "8080",
+		DBPath:          "./sms_service.db",
+		Storage:         "sqlite",
+		ReadTimeout:     15 * time.Second,
+		WriteTimeout:    15 * time.Second,
+		IdleTimeout:     60 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+		Database: DatabaseConfig{
+			JournalMode:     "WAL",
+			Timeout:         30000,
+			Synchronous:     "NORMAL",
+			CacheSize:       1000,
+			BusyTimeout:     30000,
+			MaxOpenConns:    1,
+			MaxIdleConns:    1,
+			ConnMaxLifetime: time.Hour,
+		},
+		Seed: SeedConfig{
+			Enabled:    true,
+			NumbersMin: 20,
+			NumbersMax: 30,
+		},
+		AutoMigrate:          true,
+		ActivationTTL:        20 * time.Minute,
+		ReaperInterval:       time.Minute,
+		NumberReuseWindow:    0,
+		RateLimit:            20,
+		RateBurst:            40,
+		MaxActiveActivations: 50,
+	}
+}
+
+// option связывает поле конфигурации с флагом и переменной окружения
+type option struct {
+	flag    string
+	env     string
+	usage   string
+	set     func(string) error
+	boolean bool
+}
+
+func (c *Config) options() []option {
+	return []option{
+		stringOption("port", "SMS_API_PORT", "HTTP port", &c.Port),
+		stringOption("db-path", "SMS_API_DB_PATH", "SQLite database file", &c.DBPath),
+		stringOption("storage", "SMS_API_STORAGE", "storage backend: sqlite, postgres or memory", &c.Storage),
+		stringOption("postgres-dsn", "SMS_API_POSTGRES_DSN", "PostgreSQL connection string", &c.PostgresDSN),
+		durationOption("read-timeout", "SMS_API_READ_TIMEOUT", "HTTP read timeout", &c.ReadTimeout),
+		durationOption("write-timeout", "SMS_API_WRITE_TIMEOUT", "HTTP write timeout", &c.WriteTimeout),
+		durationOption("idle-timeout", "SMS_API_IDLE_TIMEOUT", "HTTP idle timeout", &c.IdleTimeout),
+		durationOption("shutdown-timeout", "SMS_API_SHUTDOWN_TIMEOUT", "graceful shutdown timeout", &c.ShutdownTimeout),
+
+		stringOption("db-journal-mode", "SMS_API_DB_JOURNAL_MODE", "SQLite journal_mode", &c.Database.JournalMode),
+		intOption("db-timeout", "SMS_API_DB_TIMEOUT", "SQLite timeout, ms", &c.Database.Timeout),
+		stringOption("db-synchronous", "SMS_API_DB_SYNCHRONOUS", "SQLite synchronous", &c.Database.Synchronous),
+		intOption("db-cache-size", "SMS_API_DB_CACHE_SIZE", "SQLite cache_size", &c.Database.CacheSize),
+		intOption("db-busy-timeout", "SMS_API_DB_BUSY_TIMEOUT", "SQLite busy_timeout, ms", &c.Database.BusyTimeout),
+		intOption("db-max-open-conns", "SMS_API_DB_MAX_OPEN_CONNS", "max open connections", &c.Database.MaxOpenConns),
+		intOption("db-max-idle-conns", "SMS_API_DB_MAX_IDLE_CONNS", "max idle connections", &c.Database.MaxIdleConns),
+		durationOption("db-conn-max-lifetime", "SMS_API_DB_CONN_MAX_LIFETIME", "connection max lifetime", &c.Database.ConnMaxLifetime),
+
+		boolOption("auto-migrate", "SMS_API_AUTO_MIGRATE", "apply schema migrations at startup", &c.AutoMigrate),
+		boolOption("seed", "SMS_API_SEED", "seed reference data and test numbers at startup", &c.Seed.Enabled),
+		boolOption("seed-demo-key", "SMS_API_SEED_DEMO_KEY", "also seed the demo account with the well-known key qwerty123", &c.Seed.DemoKey),
+		intOption("seed-numbers-min", "SMS_API_SEED_NUMBERS_MIN", "min test numbers per country", &c.Seed.NumbersMin),
+		intOption("seed-numbers-max", "SMS_API_SEED_NUMBERS_MAX", "max test numbers per country", &c.Seed.NumbersMax),
+
+		durationOption("activation-ttl", "SMS_API_ACTIVATION_TTL", "activation lifetime before expiry", &c.ActivationTTL),
+		durationOption("reaper-interval", "SMS_API_REAPER_INTERVAL", "expiry check interval", &c.ReaperInterval),
+		durationOption("number-reuse-window", "SMS_API_NUMBER_REUSE_WINDOW", "number reuse window per service, 0 - never reuse", &c.NumberReuseWindow),
+		floatOption("rate-limit", "SMS_API_RATE_LIMIT", "default requests per second per key", &c.RateLimit),
+		intOption("rate-burst", "SMS_API_RATE_BURST", "default burst per key", &c.RateBurst),
+		intOption("max-active-activations", "SMS_API_MAX_ACTIVE_ACTIVATIONS", "default active activations per account", &c.MaxActiveActivations),
+
+		stringOption("admin-token", "SMS_API_ADMIN_TOKEN", "bearer token for the /admin API, empty disables it", &c.AdminToken),
+	}
+}
+
+// Load читает конфигурацию с приоритетом флаги > окружение > файл > значения по умолчанию.
+// Путь к файлу задается флагом -config или переменной SMS_API_CONFIG.
+// Возвращает аргументы, оставшиеся после флагов (подкоманду и ее параметры).
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+	options := cfg.options()
+
+	fs := flag.NewFlagSet("sms-api", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("SMS_API_CONFIG"), "path to YAML config file")
+
+	flagValues := make(map[string]string, len(options))
+	for _, opt := range options {
+		name := opt.flag
+		record := func(value string) error {
+			flagValues[name] = value
+			return nil
+		}
+		if opt.boolean {
+			fs.BoolFunc(name, opt.usage+" (env "+opt.env+")", record)
+		} else {
+			fs.Func(name, opt.usage+" (env "+opt.env+")", record)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	for _, opt := range options {
+		if value, ok := os.LookupEnv(opt.env); ok {
+			if err := opt.set(value); err != nil {
+				return Config{}, nil, fmt.Errorf("invalid %s: %w", opt.env, err)
+			}
+		}
+	}
+
+	for _, opt := range options {
+		if value, ok := flagValues[opt.flag]; ok {
+			if err := opt.set(value); err != nil {
+				return Config{}, nil, fmt.Errorf("invalid -%s: %w", opt.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate проверяет итоговую конфигурацию и перечисляет все найденные ошибки
+func (c *Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be a number between 1 and 65535, got %q", c.Port))
+	}
+	switch c.Storage {
+	case "sqlite":
+		if c.DBPath == "" {
+			errs = append(errs, errors.New("db_path must not be empty"))
+		}
+	case "postgres":
+		if c.PostgresDSN == "" {
+			errs = append(errs, errors.New("postgres_dsn must be set for postgres storage"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("storage must be sqlite, postgres or memory, got %q", c.Storage))
+	}
+
+	for name, value := range map[string]time.Duration{
+		"read_timeout":     c.ReadTimeout,
+		"write_timeout":    c.WriteTimeout,
+		"idle_timeout":     c.IdleTimeout,
+		"shutdown_timeout": c.ShutdownTimeout,
+		"activation_ttl":   c.ActivationTTL,
+		"reaper_interval":  c.ReaperInterval,
+	} {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", name, value))
+		}
+	}
+	if c.WriteTimeout > 0 && c.WriteTimeout <= MaxWaitSMSTimeout {
+		errs = append(errs, fmt.Errorf("write_timeout must be longer than the WAIT_SMS limit %s, got %s", MaxWaitSMSTimeout, c.WriteTimeout))
+	}
+	if c.NumberReuseWindow < 0 {
+		errs = append(errs, fmt.Errorf("number_reuse_window must not be negative, got %s", c.NumberReuseWindow))
+	}
+
+	switch c.Database.JournalMode {
+	case "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+	default:
+		errs = append(errs, fmt.Errorf("database.journal_mode %q is not a SQLite journal mode", c.Database.JournalMode))
+	}
+	switch c.Database.Synchronous {
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		errs = append(errs, fmt.Errorf("database.synchronous %q is not a SQLite synchronous mode", c.Database.Synchronous))
+	}
+	if c.Database.Timeout < 0 || c.Database.BusyTimeout < 0 {
+		errs = append(errs, errors.New("database timeouts must not be negative"))
+	}
+	if c.Database.MaxOpenConns < 1 {
+		errs = append(errs, fmt.Errorf("database.max_open_conns must be at least 1, got %d", c.Database.MaxOpenConns))
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("database.max_idle_conns must be between 0 and max_open_conns, got %d", c.Database.MaxIdleConns))
 	}
+
+	if c.Seed.NumbersMin < 0 || c.Seed.NumbersMax < c.Seed.NumbersMin {
+		errs = append(errs, fmt.Errorf("seed numbers range [%d, %d] is invalid", c.Seed.NumbersMin, c.Seed.NumbersMax))
+	}
+
+	if c.RateBurst < 0 {
+		errs = append(errs, fmt.Errorf("rate_burst must not be negative, got %d", c.RateBurst))
+	}
+
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		errs = append(errs, errors.New("admin_token must be at least 16 characters long"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func stringOption(name, env, usage string, target *string) option {
+	return option{name, env, usage, func(value string) error {
+		*target = value
+		return nil
+	}, false}
+}
+
+func intOption(name, env, usage string, target *int) option {
+	return option{name, env, usage, func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+		return nil
+	}, false}
+}
+
+func floatOption(name, env, usage string, target *float64) option {
+	return option{name, env, usage, func(value string) error {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+		return nil
+	}, false}
+}
+
+func boolOption(name, env, usage string, target *bool) option {
+	return option{name, env, usage, func(value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+		return nil
+	}, true}
+}
+
+func durationOption(name, env, usage string, target *time.Duration) option {
+	return option{name, env, usage, func(value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+		return nil
+	}, false}
 }
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig сохраняет YAML во временный файл и возвращает путь к нему
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+	if cfg.WriteTimeout <= MaxWaitSMSTimeout {
+		t.Errorf("default write timeout %s does not cover WAIT_SMS %s", cfg.WriteTimeout, MaxWaitSMSTimeout)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfig(t, `
+port: "7070"
+storage: memory
+rate_limit: 5
+write_timeout: 20s
+database:
+  max_open_conns: 4
+`)
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		check func(t *testing.T, cfg Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Port != "8080" || cfg.Storage != "sqlite" || cfg.RateLimit != 20 {
+					t.Errorf("got port %s, storage %s, rate %v; want the defaults", cfg.Port, cfg.Storage, cfg.RateLimit)
+				}
+			},
+		},
+		{
+			name: "file over defaults",
+			args: []string{"-config", file},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Port != "7070" || cfg.Storage != "memory" || cfg.RateLimit != 5 ||
+					cfg.WriteTimeout != 20*time.Second || cfg.Database.MaxOpenConns != 4 {
+					t.Errorf("file values were not applied: %+v", cfg)
+				}
+				// Значения, которых нет в файле, остаются по умолчанию
+				if cfg.ReadTimeout != 15*time.Second || cfg.Database.JournalMode != "WAL" {
+					t.Errorf("defaults were overwritten: read timeout %s, journal mode %s",
+						cfg.ReadTimeout, cfg.Database.JournalMode)
+				}
+			},
+		},
+		{
+			name: "file from environment",
+			env:  map[string]string{"SMS_API_CONFIG": file},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Port != "7070" {
+					t.Errorf("port %s, want 7070 from SMS_API_CONFIG", cfg.Port)
+				}
+			},
+		},
+		{
+			name: "environment over file",
+			env:  map[string]string{"SMS_API_PORT": "9090", "SMS_API_RATE_LIMIT": "2.5", "SMS_API_SEED": "false"},
+			args: []string{"-config", file},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Port != "9090" || cfg.RateLimit != 2.5 || cfg.Seed.Enabled {
+					t.Errorf("got port %s, rate %v, seed %v; want the environment values", cfg.Port, cfg.RateLimit, cfg.Seed.Enabled)
+				}
+				if cfg.Storage != "memory" {
+					t.Errorf("storage %s, want memory from the file", cfg.Storage)
+				}
+			},
+		},
+		{
+			name: "flags over environment",
+			env:  map[string]string{"SMS_API_PORT": "9090", "SMS_API_SEED_DEMO_KEY": "false"},
+			args: []string{"-config", file, "-port", "6060", "-seed-demo-key", "-db-max-open-conns", "8"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Port != "6060" || !cfg.Seed.DemoKey || cfg.Database.MaxOpenConns != 8 {
+					t.Errorf("got port %s, demo key %v, max open conns %d; want the flag values",
+						cfg.Port, cfg.Seed.DemoKey, cfg.Database.MaxOpenConns)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, rest, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if len(rest) != 0 {
+				t.Errorf("rest = %v, want none", rest)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadReturnsSubcommand(t *testing.T) {
+	_, rest, err := Load([]string{"-port", "9090", "migrate", "status"})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if strings.Join(rest, " ") != "migrate status" {
+		t.Errorf("rest = %v, want [migrate status]", rest)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		yaml string
+		// want - фрагменты, которые должны быть в тексте ошибки
+		want []string
+	}{
+		{name: "malformed flag", args: []string{"-rate-burst", "many"}, want: []string{"rate-burst"}},
+		{name: "malformed environment", env: map[string]string{"SMS_API_READ_TIMEOUT": "15"}, want: []string{"invalid SMS_API_READ_TIMEOUT"}},
+		{name: "port out of range", args: []string{"-port", "70000"}, want: []string{"port must be"}},
+		{name: "unknown storage", args: []string{"-storage", "redis"}, want: []string{"storage must be"}},
+		{name: "postgres without dsn", args: []string{"-storage", "postgres"}, want: []string{"postgres_dsn must be set"}},
+		{name: "negative timeout", args: []string{"-idle-timeout", "-1s"}, want: []string{"idle_timeout must be positive"}},
+		{name: "write timeout within WAIT_SMS", args: []string{"-write-timeout", "10s"}, want: []string{"write_timeout must be longer"}},
+		{name: "journal mode", args: []string{"-db-journal-mode", "wal2"}, want: []string{"database.journal_mode"}},
+		{name: "idle over open conns", args: []string{"-db-max-idle-conns", "2"}, want: []string{"database.max_idle_conns"}},
+		{name: "seed range", args: []string{"-seed-numbers-min", "10", "-seed-numbers-max", "5"}, want: []string{"seed numbers range"}},
+		{name: "short admin token", args: []string{"-admin-token", "secret"}, want: []string{"admin_token"}},
+		{name: "yaml type", yaml: "port: [8080]\n", want: []string{"failed to parse config file"}},
+		{name: "yaml duration", yaml: "activation_ttl: soon\n", want: []string{"failed to parse config file"}},
+		{
+			name: "all errors listed",
+			args: []string{"-port", "0", "-reaper-interval", "0s", "-rate-burst", "-1"},
+			want: []string{"port must be", "reaper_interval must be positive", "rate_burst must not be negative"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args := tt.args
+			if tt.yaml != "" {
+				args = append([]string{"-config", writeConfig(t, tt.yaml)}, args...)
+			}
+
+			_, _, err := Load(args)
+			if err == nil {
+				t.Fatal("load succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownYAMLKeys(t *testing.T) {
+	for name, content := range map[string]string{
+		"top level": "port: \"8080\"\nrate_limt: 5\n",
+		"nested":    "database:\n  max_open_con: 4\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := Load([]string{"-config", writeConfig(t, content)})
+			if err == nil || !strings.Contains(err.Error(), "not found") {
+				t.Errorf("err = %v, want an unknown field error", err)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")})
+	if err == nil || !strings.Contains(err.Error(), "failed to open config file") {
+		t.Errorf("err = %v, want an open error", err)
+	}
+}
@@ -0,0 +1,544 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+var adminQueries = struct {
+	listCountries     string
+	getCountry        string
+	createCountry     string
+	updateCountry     string
+	listServices      string
+	createService     string
+	updateService     string
+	listNumbers       string
+	createNumber      string
+	updateNumber      string
+	activeByNumber    string
+	listActivations   string
+	countryHasNumbers string
+	serviceHasUsage   string
+	numberHasUsage    string
+}{
+	listCountries: `SELECT id, code, name, prefix FROM countries ORDER BY code`,
+
+	getCountry: `SELECT id, code, name, prefix FROM countries WHERE code = ?`,
+
+	createCountry: `
+		INSERT INTO countries (code, name, prefix) VALUES (?, ?, ?)
+		ON CONFLICT (code) DO NOTHING`,
+
+	updateCountry: `UPDATE countries SET name = ?, prefix = ? WHERE code = ?`,
+
+	listServices: `SELECT id, code, name FROM services ORDER BY code`,
+
+	createService: `
+		INSERT INTO services (code, name) VALUES (?, ?)
+		ON CONFLICT (code) DO NOTHING`,
+
+	updateService: `UPDATE services SET name = ? WHERE code = ?`,
+
+	listNumbers: `
+		SELECT pn.id, pn.number, pn.country_id, c.code, pn.operator, pn.available, pn.tags
+		FROM phone_numbers pn
+		JOIN countries c ON pn.country_id = c.id
+		WHERE 1 = 1`,
+
+	createNumber: `
+		INSERT INTO phone_numbers (number, country_id, operator, available, tags) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (number) DO NOTHING`,
+
+	updateNumber: `UPDATE phone_numbers SET operator = ?, available = ?, tags = ? WHERE id = ?`,
+
+	activeByNumber: `
+		SELECT id, COALESCE(account_id, 0), status FROM activations
+		WHERE number_id = ? AND status IN (?, ?, ?)`,
+
+	listActivations: `
+		SELECT a.id, a.number_id, a.service_id, a.account_id, a.status, a.sum,
+			a.created_at, a.finished_at, a.finish_reason
+		FROM activations a
+		JOIN services srv ON a.service_id = srv.id
+		JOIN phone_numbers pn ON a.number_id = pn.id
+		JOIN countries c ON pn.country_id = c.id
+		WHERE 1 = 1`,
+
+	countryHasNumbers: `SELECT EXISTS (SELECT 1 FROM phone_numbers WHERE country_id = ?)`,
+
+	serviceHasUsage: `SELECT EXISTS (SELECT 1 FROM activations WHERE service_id = ?)`,
+
+	numberHasUsage: `SELECT EXISTS (SELECT 1 FROM activations WHERE number_id = ?)`,
+}
+
+func ListCountries(db *sql.DB) ([]models.Country, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, adminQueries.listCountries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	countries := []models.Country{}
+	for rows.Next() {
+		var country models.Country
+		if err := rows.Scan(&country.ID, &country.Code, &country.Name, &country.Prefix); err != nil {
+			return nil, err
+		}
+		countries = append(countries, country)
+	}
+
+	return countries, rows.Err()
+}
+
+func GetCountry(db *sql.DB, code string) (models.Country, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var country models.Country
+	err := db.QueryRowContext(ctx, adminQueries.getCountry, code).
+		Scan(&country.ID, &country.Code, &country.Name, &country.Prefix)
+	return country, err
+}
+
+func CreateCountry(db *sql.DB, country models.Country) (models.Country, error) {
+	if err := execInsert(db, adminQueries.createCountry, country.Code, country.Name, country.Prefix); err != nil {
+		return models.Country{}, err
+	}
+	return GetCountry(db, country.Code)
+}
+
+func UpdateCountry(db *sql.DB, country models.Country) (models.Country, error) {
+	if err := execUpdate(db, adminQueries.updateCountry, country.Name, country.Prefix, country.Code); err != nil {
+		return models.Country{}, err
+	}
+	return GetCountry(db, country.Code)
+}
+
+// DeleteCountry удаляет страну и ее цены, если за страной не числится номеров
+func DeleteCountry(db *sql.DB, code string) error {
+	return withAdminTx(db, func(ctx context.Context, tx *sql.Tx) error {
+		var countryID int
+		if err := tx.QueryRowContext(ctx, "SELECT id FROM countries WHERE code = ?", code).Scan(&countryID); err != nil {
+			return err
+		}
+
+		if err := ensureUnused(ctx, tx, adminQueries.countryHasNumbers, countryID); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			"DELETE FROM prices WHERE country_id = ?",
+			"DELETE FROM countries WHERE id = ?",
+		} {
+			if _, err := tx.ExecContext(ctx, query, countryID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func ListServices(db *sql.DB) ([]models.Service, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, adminQueries.listServices)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []models.Service{}
+	for rows.Next() {
+		var service models.Service
+		if err := rows.Scan(&service.ID, &service.Code, &service.Name); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+
+	return services, rows.Err()
+}
+
+func CreateService(db *sql.DB, service models.Service) (models.Service, error) {
+	if err := execInsert(db, adminQueries.createService, service.Code, service.Name); err != nil {
+		return models.Service{}, err
+	}
+	return getServiceUncached(db, service.Code)
+}
+
+func UpdateService(db *sql.DB, service models.Service) (models.Service, error) {
+	if err := execUpdate(db, adminQueries.updateService, service.Name, service.Code); err != nil {
+		return models.Service{}, err
+	}
+	return getServiceUncached(db, service.Code)
+}
+
+// DeleteService удаляет сервис, его цены и историю использования номеров,
+// если по сервису не было активаций
+func DeleteService(db *sql.DB, code string) error {
+	return withAdminTx(db, func(ctx context.Context, tx *sql.Tx) error {
+		var serviceID int
+		if err := tx.QueryRowContext(ctx, "SELECT id FROM services WHERE code = ?", code).Scan(&serviceID); err != nil {
+			return err
+		}
+
+		if err := ensureUnused(ctx, tx, adminQueries.serviceHasUsage, serviceID); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			"DELETE FROM prices WHERE service_id = ?",
+			"DELETE FROM number_usage WHERE service_id = ?",
+			"DELETE FROM services WHERE id = ?",
+		} {
+			if _, err := tx.ExecContext(ctx, query, serviceID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func getServiceUncached(db *sql.DB, code string) (models.Service, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var service models.Service
+	err := db.QueryRowContext(ctx, preparedQueries.getServiceByCode, code).
+		Scan(&service.ID, &service.Code, &service.Name)
+	return service, err
+}
+
+func ListNumbers(db *sql.DB, filter storage.NumberFilter) ([]models.PhoneNumber, error) {
+	var sb strings.Builder
+	sb.WriteString(adminQueries.listNumbers)
+	var args []interface{}
+
+	if filter.Country != "" {
+		sb.WriteString(" AND c.code = ?")
+		args = append(args, filter.Country)
+	}
+	if filter.Operator != "" {
+		sb.WriteString(" AND pn.operator = ?")
+		args = append(args, filter.Operator)
+	}
+	if filter.Available != nil {
+		sb.WriteString(" AND pn.available = ?")
+		args = append(args, *filter.Available)
+	}
+	sb.WriteString(" ORDER BY pn.id")
+	args = appendPage(&sb, args, filter.Limit, filter.Offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	numbers := []models.PhoneNumber{}
+	for rows.Next() {
+		var number models.PhoneNumber
+		if err := rows.Scan(&number.ID, &number.Number, &number.CountryID, &number.Country,
+			&number.Operator, &number.Available, &number.Tags); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+
+	return numbers, rows.Err()
+}
+
+func GetNumber(db *sql.DB, numberID int) (models.PhoneNumber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var number models.PhoneNumber
+	err := db.QueryRowContext(ctx, adminQueries.listNumbers+" AND pn.id = ?", numberID).
+		Scan(&number.ID, &number.Number, &number.CountryID, &number.Country,
+			&number.Operator, &number.Available, &number.Tags)
+	return number, err
+}
+
+func CreateNumber(db *sql.DB, number models.PhoneNumber) (models.PhoneNumber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, adminQueries.createNumber,
+		number.Number, number.CountryID, number.Operator, number.Available, number.Tags)
+	if err != nil {
+		return models.PhoneNumber{}, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return models.PhoneNumber{}, err
+	}
+	if rowsAffected == 0 {
+		return models.PhoneNumber{}, storage.ErrConflict
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return models.PhoneNumber{}, err
+	}
+	return GetNumber(db, int(id))
+}
+
+// ImportNumbers вставляет батч номеров в одной транзакции, как insertNumbersBatchTx при заполнении базы
+func ImportNumbers(db *sql.DB, numbers []models.PhoneNumber) (int, error) {
+	inserted := 0
+	err := withAdminTx(db, func(ctx context.Context, tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, adminQueries.createNumber)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, number := range numbers {
+			result, err := stmt.ExecContext(ctx,
+				number.Number, number.CountryID, number.Operator, number.Available, number.Tags)
+			if err != nil {
+				return fmt.Errorf("failed to insert number %d: %w", number.Number, err)
+			}
+			rowsAffected, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			inserted += int(rowsAffected)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+func UpdateNumber(db *sql.DB, number models.PhoneNumber) (models.PhoneNumber, error) {
+	if err := execUpdate(db, adminQueries.updateNumber,
+		number.Operator, number.Available, number.Tags, number.ID); err != nil {
+		return models.PhoneNumber{}, err
+	}
+	return GetNumber(db, number.ID)
+}
+
+// DeleteNumber удаляет номер, по которому не было активаций
+func DeleteNumber(db *sql.DB, numberID int) error {
+	return withAdminTx(db, func(ctx context.Context, tx *sql.Tx) error {
+		var exists int
+		if err := tx.QueryRowContext(ctx, "SELECT 1 FROM phone_numbers WHERE id = ?", numberID).Scan(&exists); err != nil {
+			return err
+		}
+
+		if err := ensureUnused(ctx, tx, adminQueries.numberHasUsage, numberID); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			"DELETE FROM number_usage WHERE number_id = ?",
+			"DELETE FROM phone_numbers WHERE id = ?",
+		} {
+			if _, err := tx.ExecContext(ctx, query, numberID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReleaseNumber отменяет незавершенные активации номера; сумма возвращается
+// по правилам storage.SettlementKind
+func ReleaseNumber(db *sql.DB, numberID int, now time.Time) ([]storage.ExpiredActivation, error) {
+	var released []storage.ExpiredActivation
+
+	err := withAdminTx(db, func(ctx context.Context, tx *sql.Tx) error {
+		var exists int
+		if err := tx.QueryRowContext(ctx, "SELECT 1 FROM phone_numbers WHERE id = ?", numberID).Scan(&exists); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, adminQueries.activeByNumber, numberID,
+			models.ActivationWaiting, models.ActivationCodeReceived, models.ActivationRetryRequested)
+		if err != nil {
+			return err
+		}
+
+		type activeActivation struct {
+			id        uint64
+			accountID int64
+			status    int
+		}
+		var active []activeActivation
+
+		for rows.Next() {
+			var activation activeActivation
+			if err := rows.Scan(&activation.id, &activation.accountID, &activation.status); err != nil {
+				rows.Close()
+				return err
+			}
+			active = append(active, activation)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		rows.Close()
+
+		for _, activation := range active {
+			closed, err := closeActivation(ctx, tx, activation.id, activation.status,
+				models.ActivationCancelled, now, storage.ReleaseReason)
+			if err != nil {
+				return err
+			}
+			if closed {
+				released = append(released, storage.ExpiredActivation{ID: activation.id, AccountID: activation.accountID})
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM number_reservations WHERE number_id = ?", numberID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return released, nil
+}
+
+func ListActivations(db *sql.DB, filter storage.ActivationFilter) ([]models.Activation, error) {
+	var sb strings.Builder
+	sb.WriteString(adminQueries.listActivations)
+	var args []interface{}
+
+	if filter.AccountID != nil {
+		sb.WriteString(" AND a.account_id = ?")
+		args = append(args, *filter.AccountID)
+	}
+	if filter.Status != nil {
+		sb.WriteString(" AND a.status = ?")
+		args = append(args, *filter.Status)
+	}
+	if filter.Service != "" {
+		sb.WriteString(" AND srv.code = ?")
+		args = append(args, filter.Service)
+	}
+	if filter.Country != "" {
+		sb.WriteString(" AND c.code = ?")
+		args = append(args, filter.Country)
+	}
+	if filter.NumberID != 0 {
+		sb.WriteString(" AND a.number_id = ?")
+		args = append(args, filter.NumberID)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		sb.WriteString(" AND a.created_at >= ?")
+		args = append(args, filter.CreatedAfter.UTC())
+	}
+	if !filter.CreatedBefore.IsZero() {
+		sb.WriteString(" AND a.created_at < ?")
+		args = append(args, filter.CreatedBefore.UTC())
+	}
+	sb.WriteString(" ORDER BY a.id DESC")
+	args = appendPage(&sb, args, filter.Limit, filter.Offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activations := []models.Activation{}
+	for rows.Next() {
+		var activation models.Activation
+		if err := rows.Scan(
+			&activation.ID,
+			&activation.NumberID,
+			&activation.ServiceID,
+			&activation.AccountID,
+			&activation.Status,
+			&activation.Sum,
+			&activation.CreatedAt,
+			&activation.FinishedAt,
+			&activation.FinishReason,
+		); err != nil {
+			return nil, err
+		}
+		activations = append(activations, activation)
+	}
+
+	return activations, rows.Err()
+}
+
+// appendPage дописывает к запросу LIMIT и OFFSET; limit <= 0 не ограничивает выборку
+func appendPage(sb *strings.Builder, args []interface{}, limit, offset int) []interface{} {
+	if limit <= 0 {
+		return args
+	}
+	sb.WriteString(" LIMIT ? OFFSET ?")
+	return append(args, limit, offset)
+}
+
+// execInsert выполняет INSERT ... ON CONFLICT DO NOTHING; пропущенная вставка означает конфликт
+func execInsert(db *sql.DB, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+// ensureUnused возвращает ErrConflict, если запрос существования нашел зависимые записи
+func ensureUnused(ctx context.Context, tx *sql.Tx, query string, id int) error {
+	var used bool
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&used); err != nil {
+		return err
+	}
+	if used {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+func withAdminTx(db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
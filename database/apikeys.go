@@ -0,0 +1,230 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+var apiKeyQueries = struct {
+	getAPIKeyByHash string
+	createAPIKey    string
+	seedAPIKey      string
+	setEnabled      string
+	setExpiresAt    string
+	setLimits       string
+	listAPIKeys     string
+}{
+	getAPIKeyByHash: `
+		SELECT id, account_id, name, enabled, expires_at, rate_limit, rate_burst, max_active_activations, created_at
+		FROM api_keys WHERE key_hash = ?`,
+
+	createAPIKey: `
+		INSERT INTO api_keys (account_id, key_hash, name, enabled, expires_at, created_at)
+		VALUES (?, ?, ?, 1, ?, ?)`,
+
+	seedAPIKey: `
+		INSERT OR IGNORE INTO api_keys (account_id, key_hash, name, enabled, created_at)
+		SELECT id, ?, ?, 1, ? FROM accounts WHERE name = ?`,
+
+	setEnabled: `UPDATE api_keys SET enabled = ? WHERE id = ?`,
+
+	setExpiresAt: `UPDATE api_keys SET expires_at = ? WHERE id = ?`,
+
+	setLimits: `
+		UPDATE api_keys
+		SET rate_limit = ?, rate_burst = ?, max_active_activations = ?
+		WHERE id = ?`,
+
+	listAPIKeys: `
+		SELECT id, account_id, name, enabled, expires_at, rate_limit, rate_burst, max_active_activations, created_at
+		FROM api_keys ORDER BY id`,
+}
+
+// AuthenticateAPIKey находит действующий ключ по его открытому значению
+func AuthenticateAPIKey(db *sql.DB, key string, now time.Time) (*models.APIKey, error) {
+	if key == "" {
+		return nil, storage.ErrInvalidKey
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	apiKey, err := scanAPIKey(db.QueryRowContext(ctx, apiKeyQueries.getAPIKeyByHash, storage.HashAPIKey(key)))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !apiKey.Active(now) {
+		return nil, storage.ErrInvalidKey
+	}
+
+	return apiKey, nil
+}
+
+// CreateAPIKey выпускает новый ключ для счета. Открытое значение возвращается
+// только здесь: в базе хранится лишь его хеш.
+func CreateAPIKey(db *sql.DB, accountID int64, name string, expiresAt *time.Time) (string, *models.APIKey, error) {
+	key, err := storage.NewAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	now := utcNow()
+	result, err := db.ExecContext(ctx, apiKeyQueries.createAPIKey,
+		accountID, storage.HashAPIKey(key), name, unixOrNil(expiresAt), now)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, &models.APIKey{
+		ID:        id,
+		AccountID: accountID,
+		Name:      name,
+		Enabled:   true,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}, nil
+}
+
+func SetAPIKeyEnabled(db *sql.DB, keyID int64, enabled bool) error {
+	return execUpdate(db, apiKeyQueries.setEnabled, enabled, keyID)
+}
+
+// SetAPIKeyExpiry задает срок действия ключа; nil делает ключ бессрочным
+func SetAPIKeyExpiry(db *sql.DB, keyID int64, expiresAt *time.Time) error {
+	return execUpdate(db, apiKeyQueries.setExpiresAt, unixOrNil(expiresAt), keyID)
+}
+
+// SetAPIKeyLimits задает лимиты ключа; nil означает значение по умолчанию из конфигурации
+func SetAPIKeyLimits(db *sql.DB, keyID int64, rateLimit *float64, rateBurst, maxActiveActivations *int) error {
+	return execUpdate(db, apiKeyQueries.setLimits,
+		nullableFloat(rateLimit), nullableInt(rateBurst), nullableInt(maxActiveActivations), keyID)
+}
+
+func nullableFloat(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func nullableInt(v *int) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func execUpdate(db *sql.DB, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func ListAPIKeys(db *sql.DB) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, apiKeyQueries.listAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *apiKey)
+	}
+
+	return keys, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var (
+		apiKey    models.APIKey
+		expiresAt sql.NullInt64
+		rateLimit sql.NullFloat64
+		rateBurst sql.NullInt64
+		maxActive sql.NullInt64
+	)
+	err := row.Scan(&apiKey.ID, &apiKey.AccountID, &apiKey.Name, &apiKey.Enabled, &expiresAt,
+		&rateLimit, &rateBurst, &maxActive, &apiKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if rateLimit.Valid {
+		apiKey.RateLimit = &rateLimit.Float64
+	}
+	if rateBurst.Valid {
+		burst := int(rateBurst.Int64)
+		apiKey.RateBurst = &burst
+	}
+	if maxActive.Valid {
+		limit := int(maxActive.Int64)
+		apiKey.MaxActiveActivations = &limit
+	}
+
+	if expiresAt.Valid {
+		t := time.Unix(expiresAt.Int64, 0)
+		apiKey.ExpiresAt = &t
+	}
+
+	return &apiKey, nil
+}
+
+func unixOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+// seedAPIKeys создает ключи счетов, если они еще не заведены
+func (d *Database) seedAPIKeys(ctx context.Context, keys []storage.SeedAPIKey) error {
+	for _, key := range keys {
+		err := d.ExecuteWithRetry(ctx, apiKeyQueries.seedAPIKey,
+			storage.HashAPIKey(key.Key), key.Name, utcNow(), key.Account)
+		if err != nil {
+			return fmt.Errorf("failed to insert api key %s: %w", key.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+var billingQueries = struct {
+	getAccountByName    string
+	getAccountByID      string
+	createAccount       string
+	holdFunds           string
+	captureFunds        string
+	refundFunds         string
+	depositFunds        string
+	insertLedgerEntry   string
+	getActivationCharge string
+}{
+	getAccountByName: `SELECT id, name, balance, held, created_at FROM accounts WHERE name = ?`,
+
+	getAccountByID: `SELECT id, name, balance, held, created_at FROM accounts WHERE id = ?`,
+
+	createAccount: `INSERT OR IGNORE INTO accounts (name, balance, held, created_at) VALUES (?, 0, 0, ?)`,
+
+	holdFunds: `
+		UPDATE accounts
+		SET balance = balance - ?, held = held + ?
+		WHERE id = ? AND balance >= ?`,
+
+	captureFunds: `UPDATE accounts SET held = held - ? WHERE id = ?`,
+
+	refundFunds: `UPDATE accounts SET held = held - ?, balance = balance + ? WHERE id = ?`,
+
+	depositFunds: `UPDATE accounts SET balance = balance + ? WHERE id = ?`,
+
+	insertLedgerEntry: `
+		INSERT INTO ledger_entries (account_id, activation_id, kind, amount, balance_after, held_after, created_at)
+		SELECT id, ?, ?, ?, balance, held, ? FROM accounts WHERE id = ?`,
+
+	getActivationCharge: `
+		SELECT account_id, sum FROM activations
+		WHERE id = ? AND account_id IS NOT NULL`,
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func GetAccountByName(db *sql.DB, name string) (*models.Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return scanAccount(db.QueryRowContext(ctx, billingQueries.getAccountByName, name))
+}
+
+func GetAccountByID(db *sql.DB, accountID int64) (*models.Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return scanAccount(db.QueryRowContext(ctx, billingQueries.getAccountByID, accountID))
+}
+
+// EnsureAccount возвращает идентификатор счета, создавая счет при необходимости
+func EnsureAccount(db *sql.DB, name string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, billingQueries.createAccount, name, utcNow()); err != nil {
+		return 0, err
+	}
+
+	account, err := scanAccount(db.QueryRowContext(ctx, billingQueries.getAccountByName, name))
+	if err != nil {
+		return 0, err
+	}
+	return account.ID, nil
+}
+
+func scanAccount(row *sql.Row) (*models.Account, error) {
+	var account models.Account
+	if err := row.Scan(&account.ID, &account.Name, &account.Balance, &account.Held, &account.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// Deposit зачисляет средства на счет и записывает операцию в журнал
+func Deposit(db *sql.DB, accountID int64, amount float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, billingQueries.depositFunds, amount, accountID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := appendLedger(ctx, tx, accountID, nil, storage.LedgerDeposit, amount); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// holdFunds блокирует стоимость активации на счете клиента
+func holdFunds(ctx context.Context, tx execer, accountID int64, activationID uint64, amount float64) error {
+	result, err := tx.ExecContext(ctx, billingQueries.holdFunds, amount, amount, accountID, amount)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return storage.ErrNoBalance
+	}
+
+	return appendLedger(ctx, tx, accountID, &activationID, storage.LedgerHold, amount)
+}
+
+// settleActivation списывает (capture) или возвращает (refund) заблокированную сумму
+// активации. Активации без счета, созданные до появления биллинга, пропускаются.
+func settleActivation(ctx context.Context, tx *sql.Tx, activationID uint64, kind string) error {
+	var (
+		accountID int64
+		amount    float64
+	)
+	err := tx.QueryRowContext(ctx, billingQueries.getActivationCharge, activationID).Scan(&accountID, &amount)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case storage.LedgerCapture:
+		_, err = tx.ExecContext(ctx, billingQueries.captureFunds, amount, accountID)
+	case storage.LedgerRefund:
+		_, err = tx.ExecContext(ctx, billingQueries.refundFunds, amount, amount, accountID)
+	default:
+		return errors.New("unknown settlement kind: " + kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	return appendLedger(ctx, tx, accountID, &activationID, kind, amount)
+}
+
+func appendLedger(ctx context.Context, tx execer, accountID int64, activationID *uint64, kind string, amount float64) error {
+	var activation interface{}
+	if activationID != nil {
+		activation = *activationID
+	}
+
+	_, err := tx.ExecContext(ctx, billingQueries.insertLedgerEntry,
+		activation, kind, amount, utcNow(), accountID)
+	return err
+}
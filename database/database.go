@@ -7,10 +7,13 @@ import (
 	"log"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
+	"sms-api-service/metrics"
 	"sms-api-service/models"
+	"sms-api-service/storage"
 )
 
 // DatabaseConfig содержит конфигурацию для подключения к БД
@@ -41,15 +44,32 @@ func DefaultConfig(dbPath string) *DatabaseConfig {
 	}
 }
 
+// timeFormat - формат, в котором драйвер с _time_format=sqlite пишет время
+const timeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// utcNow возвращает текущее время в UTC. Время хранится строкой, и строки
+// упорядочены так же, как моменты времени, только в одном часовом поясе.
+func utcNow() time.Time {
+	return time.Now().UTC()
+}
+
 // Database представляет обертку над sql.DB с дополнительной функциональностью
 type Database struct {
 	*sql.DB
 	config *DatabaseConfig
+
+	servicesMu sync.RWMutex
+	services   map[string]models.Service
 }
 
 // Init инициализирует подключение к базе данных
 func Init(config *DatabaseConfig) (*Database, error) {
-	dsn := fmt.Sprintf("%s?_journal_mode=%s&_timeout=%d&_synchronous=%s&_cache_size=%d&_busy_timeout=%d",
+	// _txlock=immediate берет блокировку записи в начале транзакции: отложенная
+	// транзакция при нескольких соединениях не может повысить блокировку после
+	// чтения и сразу получает "database is locked", не дожидаясь busy_timeout.
+	// _time_format=sqlite пишет время в формате timeFormat вместо time.Time.String(),
+	// поэтому значения в UTC сравниваются в SQL как строки
+	dsn := fmt.Sprintf("%s?_journal_mode=%s&_timeout=%d&_synchronous=%s&_cache_size=%d&_busy_timeout=%d&_txlock=immediate&_time_format=sqlite",
 		config.Path, config.JournalMode, config.Timeout, config.Synchronous, config.CacheSize, config.BusyTimeout)
 
 	db, err := sql.Open("sqlite", dsn)
@@ -67,13 +87,9 @@ func Init(config *DatabaseConfig) (*Database, error) {
 	}
 
 	database := &Database{
-		DB:     db,
-		config: config,
-	}
-
-	if err := database.createTables(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+		DB:       db,
+		config:   config,
+		services: make(map[string]models.Service),
 	}
 
 	return database, nil
@@ -111,11 +127,15 @@ func (d *Database) ExecuteWithRetry(ctx context.Context, query string, args ...i
 		if attempt < maxRetries-1 {
 			delay := baseDelay * time.Duration(1<<uint(attempt))
 			log.Printf("Database locked, retrying in %v (attempt %d/%d)", delay, attempt+1, maxRetries)
+			metrics.DBRetries.Inc()
 
+			waitStart := time.Now()
 			select {
 			case <-time.After(delay):
+				metrics.DBLockWait.Add(time.Since(waitStart).Seconds())
 				continue
 			case <-ctx.Done():
+				metrics.DBLockWait.Add(time.Since(waitStart).Seconds())
 				return ctx.Err()
 			}
 		}
@@ -132,94 +152,8 @@ func isRetryableError(err error) bool {
 		strings.Contains(errStr, "database table is locked")
 }
 
-// createTables создает необходимые таблицы
-func (d *Database) createTables() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS countries (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		code TEXT UNIQUE NOT NULL,
-		name TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS services (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		code TEXT UNIQUE NOT NULL,
-		name TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS phone_numbers (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		number INTEGER UNIQUE NOT NULL,
-		country_id INTEGER NOT NULL,
-		operator TEXT NOT NULL,
-		available BOOLEAN DEFAULT TRUE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (country_id) REFERENCES countries (id)
-	);
-
-	CREATE TABLE IF NOT EXISTS activations (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		number_id INTEGER NOT NULL,
-		service_id INTEGER NOT NULL,
-		status INTEGER DEFAULT 0,
-		sum REAL NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		finished_at DATETIME,
-		FOREIGN KEY (number_id) REFERENCES phone_numbers (id),
-		FOREIGN KEY (service_id) REFERENCES services (id)
-	);
-
-	CREATE TABLE IF NOT EXISTS sms_messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		activation_id INTEGER NOT NULL,
-		text TEXT NOT NULL,
-		received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (activation_id) REFERENCES activations (id)
-	);
-
-	-- Индексы для оптимизации запросов
-	CREATE INDEX IF NOT EXISTS idx_phone_numbers_country_available ON phone_numbers(country_id, available);
-	CREATE INDEX IF NOT EXISTS idx_activations_status ON activations(status);
-	CREATE INDEX IF NOT EXISTS idx_activations_created_at ON activations(created_at);
-	CREATE INDEX IF NOT EXISTS idx_sms_messages_activation_id ON sms_messages(activation_id);
-	`
-
-	ctx := context.Background()
-	return d.ExecuteWithRetry(ctx, schema)
-}
-
-// SeedData структура для конфигурации тестовых данных
-type SeedData struct {
-	Countries    []models.Country
-	Services     []models.Service
-	NumbersRange struct {
-		Min, Max int
-	}
-}
-
-// DefaultSeedData возвращает данные для заполнения по умолчанию
-func DefaultSeedData() *SeedData {
-	return &SeedData{
-		Countries: []models.Country{
-			{Code: "rus", Name: "Russia"},
-			{Code: "uzb", Name: "Uzbekistan"},
-			{Code: "bel", Name: "Belarus"},
-		},
-		Services: []models.Service{
-			{Code: "vk", Name: "VKontakte"},
-			{Code: "ok", Name: "Odnoklassniki"},
-			{Code: "wa", Name: "WhatsApp"},
-			{Code: "tg", Name: "Telegram"},
-			{Code: "fb", Name: "Facebook"},
-		},
-		NumbersRange: struct{ Min, Max int }{Min: 20, Max: 30},
-	}
-}
-
 // Seed заполняет базу данных тестовыми данными
-func (d *Database) Seed(ctx context.Context, seedData *SeedData) error {
+func (d *Database) Seed(ctx context.Context, seedData *storage.SeedData) error {
 	if err := d.seedCountries(ctx, seedData.Countries); err != nil {
 		return fmt.Errorf("failed to seed countries: %w", err)
 	}
@@ -228,7 +162,19 @@ func (d *Database) Seed(ctx context.Context, seedData *SeedData) error {
 		return fmt.Errorf("failed to seed services: %w", err)
 	}
 
-	if err := d.generateTestNumbers(ctx, seedData.NumbersRange.Min, seedData.NumbersRange.Max); err != nil {
+	if err := d.seedPrices(ctx, seedData.Prices); err != nil {
+		return fmt.Errorf("failed to seed prices: %w", err)
+	}
+
+	if err := d.seedAccounts(ctx, seedData.Accounts); err != nil {
+		return fmt.Errorf("failed to seed accounts: %w", err)
+	}
+
+	if err := d.seedAPIKeys(ctx, seedData.APIKeys); err != nil {
+		return fmt.Errorf("failed to seed api keys: %w", err)
+	}
+
+	if err := d.generateTestNumbers(ctx, seedData); err != nil {
 		return fmt.Errorf("failed to generate test numbers: %w", err)
 	}
 
@@ -239,8 +185,8 @@ func (d *Database) Seed(ctx context.Context, seedData *SeedData) error {
 // seedCountries заполняет таблицу стран
 func (d *Database) seedCountries(ctx context.Context, countries []models.Country) error {
 	for _, country := range countries {
-		err := d.ExecuteWithRetry(ctx, "INSERT OR IGNORE INTO countries (code, name) VALUES (?, ?)",
-			country.Code, country.Name)
+		err := d.ExecuteWithRetry(ctx, "INSERT OR IGNORE INTO countries (code, name, prefix) VALUES (?, ?, ?)",
+			country.Code, country.Name, country.Prefix)
 		if err != nil {
 			return fmt.Errorf("failed to insert country %s: %w", country.Code, err)
 		}
@@ -260,6 +206,34 @@ func (d *Database) seedServices(ctx context.Context, services []models.Service)
 	return nil
 }
 
+// seedAccounts создает счета и зачисляет начальный баланс только новым счетам
+func (d *Database) seedAccounts(ctx context.Context, accounts []models.Account) error {
+	for _, account := range accounts {
+		result, err := d.ExecContext(ctx, billingQueries.createAccount, account.Name, utcNow())
+		if err != nil {
+			return fmt.Errorf("failed to insert account %s: %w", account.Name, err)
+		}
+
+		created, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if created == 0 || account.Balance <= 0 {
+			continue
+		}
+
+		accountID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		if err := Deposit(d.DB, accountID, account.Balance); err != nil {
+			return fmt.Errorf("failed to deposit initial balance for %s: %w", account.Name, err)
+		}
+	}
+	return nil
+}
+
 // CountryPrefix представляет префикс страны для генерации номеров
 type CountryPrefix struct {
 	ID     int
@@ -267,26 +241,22 @@ type CountryPrefix struct {
 	Prefix uint64
 }
 
-// getCountryPrefixes возвращает префиксы стран
-func getCountryPrefixes() map[string]uint64 {
-	return map[string]uint64{
-		"rus": 7,
-		"uzb": 998,
-		"bel": 375,
-	}
-}
-
-// generateTestNumbers генерирует тестовые номера телефонов
-func (d *Database) generateTestNumbers(ctx context.Context, minCount, maxCount int) error {
+// generateTestNumbers генерирует тестовые номера телефонов операторов страны
+func (d *Database) generateTestNumbers(ctx context.Context, seedData *storage.SeedData) error {
 	countries, err := d.getCountriesWithPrefixes(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get countries: %w", err)
 	}
 
 	for _, country := range countries {
-		numCount := rand.Intn(maxCount-minCount+1) + minCount
+		if len(seedData.Operators[country.Code]) == 0 {
+			log.Printf("No operators for country %s, skipping test numbers", country.Code)
+			continue
+		}
 
-		if err := d.insertNumbersBatch(ctx, country, numCount); err != nil {
+		numCount := rand.Intn(seedData.NumbersRange.Max-seedData.NumbersRange.Min+1) + seedData.NumbersRange.Min
+
+		if err := d.insertNumbersBatch(ctx, country, seedData.Operators[country.Code], numCount); err != nil {
 			return fmt.Errorf("failed to insert numbers for country %s: %w", country.Code, err)
 		}
 	}
@@ -294,34 +264,28 @@ func (d *Database) generateTestNumbers(ctx context.Context, minCount, maxCount i
 	return nil
 }
 
-// getCountriesWithPrefixes получает страны с их префиксами
+// getCountriesWithPrefixes получает страны, для которых задан префикс
 func (d *Database) getCountriesWithPrefixes(ctx context.Context) ([]CountryPrefix, error) {
-	rows, err := d.QueryContext(ctx, "SELECT id, code FROM countries")
+	rows, err := d.QueryContext(ctx, "SELECT id, code, prefix FROM countries WHERE prefix > 0")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	prefixes := getCountryPrefixes()
 	var countries []CountryPrefix
-
 	for rows.Next() {
 		var cp CountryPrefix
-		if err := rows.Scan(&cp.ID, &cp.Code); err != nil {
-			continue
-		}
-
-		if prefix, exists := prefixes[cp.Code]; exists {
-			cp.Prefix = prefix
-			countries = append(countries, cp)
+		if err := rows.Scan(&cp.ID, &cp.Code, &cp.Prefix); err != nil {
+			return nil, err
 		}
+		countries = append(countries, cp)
 	}
 
 	return countries, rows.Err()
 }
 
 // insertNumbersBatch вставляет номера телефонов батчами
-func (d *Database) insertNumbersBatch(ctx context.Context, country CountryPrefix, count int) error {
+func (d *Database) insertNumbersBatch(ctx context.Context, country CountryPrefix, operators []string, count int) error {
 	const batchSize = 100
 
 	for i := 0; i < count; i += batchSize {
@@ -330,7 +294,7 @@ func (d *Database) insertNumbersBatch(ctx context.Context, country CountryPrefix
 			end = count
 		}
 
-		if err := d.insertNumbersBatchTx(ctx, country, i, end); err != nil {
+		if err := d.insertNumbersBatchTx(ctx, country, operators, i, end); err != nil {
 			return err
 		}
 	}
@@ -339,7 +303,7 @@ func (d *Database) insertNumbersBatch(ctx context.Context, country CountryPrefix
 }
 
 // insertNumbersBatchTx вставляет батч номеров в рамках транзакции
-func (d *Database) insertNumbersBatchTx(ctx context.Context, country CountryPrefix, start, end int) error {
+func (d *Database) insertNumbersBatchTx(ctx context.Context, country CountryPrefix, operators []string, start, end int) error {
 	tx, err := d.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -354,26 +318,14 @@ func (d *Database) insertNumbersBatchTx(ctx context.Context, country CountryPref
 	defer stmt.Close()
 
 	for i := start; i < end; i++ {
-		number := d.generatePhoneNumber(country.Prefix)
+		number := storage.GeneratePhoneNumber(country.Prefix)
+
+		operator := storage.RandomOperator(operators)
 
-		if _, err := stmt.ExecContext(ctx, number, country.ID, "any", true); err != nil {
+		if _, err := stmt.ExecContext(ctx, number, country.ID, operator, true); err != nil {
 			log.Printf("Failed to insert number %d: %v", number, err)
 		}
 	}
 
 	return tx.Commit()
 }
-
-// generatePhoneNumber генерирует номер телефона для заданного префикса
-func (d *Database) generatePhoneNumber(prefix uint64) uint64 {
-	switch prefix {
-	case 7: // Russia
-		return 79000000000 + uint64(rand.Intn(999999999))
-	case 998: // Uzbekistan
-		return 998000000000 + uint64(rand.Intn(999999999))
-	case 375: // Belarus
-		return 375000000000 + uint64(rand.Intn(999999999))
-	default:
-		return prefix*1000000000 + uint64(rand.Intn(999999999))
-	}
-}
@@ -0,0 +1,462 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+// Migration - пронумерованное изменение схемы. Каждая миграция выполняется
+// в собственной транзакции и записывается в schema_migrations.
+// Новые миграции добавляются в конец списка migrations со следующим номером.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, tx *sql.Tx) error
+}
+
+var migrations = []Migration{
+	{Version: 1, Name: "initial schema", Up: migrateInitialSchema},
+	{Version: 2, Name: "country prefixes", Up: migrateCountryPrefixes},
+	{Version: 3, Name: "number tags", Up: migrateNumberTags},
+	{Version: 4, Name: "comparable timestamps", Up: migrateComparableTimestamps},
+}
+
+const createMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`
+
+// Migrate применяет все непримененные миграции по порядку
+func (d *Database) Migrate(ctx context.Context) error {
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		if err := d.applyMigration(ctx, migration); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Applied migration %d: %s", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+// PendingMigrations возвращает число непримененных миграций
+func (d *Database) PendingMigrations(ctx context.Context) (int, error) {
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// MigrationStatus возвращает все известные миграции с отметкой о применении
+func (d *Database) MigrationStatus(ctx context.Context) ([]storage.MigrationState, error) {
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]storage.MigrationState, len(migrations))
+	for i, migration := range migrations {
+		states[i].Version = migration.Version
+		states[i].Name = migration.Name
+		if appliedAt, ok := applied[migration.Version]; ok {
+			states[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return states, nil
+}
+
+// appliedMigrations возвращает версии примененных миграций и время их применения
+func (d *Database) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	if err := d.ExecuteWithRetry(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	rows, err := d.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func (d *Database) applyMigration(ctx context.Context, migration Migration) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := migration.Up(ctx, tx); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, utcNow())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const initialSchema = `
+	CREATE TABLE IF NOT EXISTS countries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS services (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS phone_numbers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		number INTEGER UNIQUE NOT NULL,
+		country_id INTEGER NOT NULL,
+		operator TEXT NOT NULL,
+		available BOOLEAN DEFAULT TRUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (country_id) REFERENCES countries (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS prices (
+		country_id INTEGER NOT NULL,
+		operator TEXT NOT NULL,
+		service_id INTEGER NOT NULL,
+		price REAL NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (country_id, operator, service_id),
+		FOREIGN KEY (country_id) REFERENCES countries (id),
+		FOREIGN KEY (service_id) REFERENCES services (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS accounts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		balance REAL NOT NULL DEFAULT 0,
+		held REAL NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id INTEGER NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		expires_at INTEGER,
+		rate_limit REAL,
+		rate_burst INTEGER,
+		max_active_activations INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (account_id) REFERENCES accounts (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS ledger_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id INTEGER NOT NULL,
+		activation_id INTEGER,
+		kind TEXT NOT NULL,
+		amount REAL NOT NULL,
+		balance_after REAL NOT NULL,
+		held_after REAL NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (account_id) REFERENCES accounts (id),
+		FOREIGN KEY (activation_id) REFERENCES activations (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS activations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		number_id INTEGER NOT NULL,
+		service_id INTEGER NOT NULL,
+		account_id INTEGER,
+		status INTEGER DEFAULT 0,
+		sum REAL NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME,
+		finish_reason TEXT,
+		FOREIGN KEY (number_id) REFERENCES phone_numbers (id),
+		FOREIGN KEY (service_id) REFERENCES services (id),
+		FOREIGN KEY (account_id) REFERENCES accounts (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS number_reservations (
+		number_id INTEGER NOT NULL,
+		service_id INTEGER NOT NULL,
+		activation_id INTEGER NOT NULL,
+		PRIMARY KEY (number_id, service_id),
+		FOREIGN KEY (number_id) REFERENCES phone_numbers (id),
+		FOREIGN KEY (service_id) REFERENCES services (id),
+		FOREIGN KEY (activation_id) REFERENCES activations (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS number_usage (
+		number_id INTEGER NOT NULL,
+		service_id INTEGER NOT NULL,
+		used_at INTEGER NOT NULL,
+		PRIMARY KEY (number_id, service_id),
+		FOREIGN KEY (number_id) REFERENCES phone_numbers (id),
+		FOREIGN KEY (service_id) REFERENCES services (id)
+	);
+
+	CREATE TABLE IF NOT EXISTS sms_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		activation_id INTEGER NOT NULL,
+		text TEXT NOT NULL,
+		code TEXT,
+		received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (activation_id) REFERENCES activations (id)
+	);
+
+	-- Индексы для оптимизации запросов
+	CREATE INDEX IF NOT EXISTS idx_phone_numbers_country_available ON phone_numbers(country_id, available);
+	CREATE INDEX IF NOT EXISTS idx_activations_status ON activations(status);
+	CREATE INDEX IF NOT EXISTS idx_activations_created_at ON activations(created_at);
+	CREATE INDEX IF NOT EXISTS idx_number_reservations_activation_id ON number_reservations(activation_id);
+	CREATE INDEX IF NOT EXISTS idx_ledger_entries_account_id ON ledger_entries(account_id);
+	CREATE INDEX IF NOT EXISTS idx_sms_messages_activation_id ON sms_messages(activation_id);
+	`
+
+// migrateInitialSchema создает схему и доводит до нее базы, созданные
+// до появления миграций через CREATE TABLE IF NOT EXISTS
+func migrateInitialSchema(ctx context.Context, tx *sql.Tx) error {
+	hadReservations, err := tableExists(ctx, tx, "number_reservations")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, initialSchema); err != nil {
+		return err
+	}
+
+	for _, column := range []struct{ table, name, definition string }{
+		{"activations", "finish_reason", "TEXT"},
+		{"activations", "account_id", "INTEGER REFERENCES accounts (id)"},
+		{"sms_messages", "code", "TEXT"},
+		{"api_keys", "rate_limit", "REAL"},
+		{"api_keys", "rate_burst", "INTEGER"},
+		{"api_keys", "max_active_activations", "INTEGER"},
+	} {
+		if err := addColumnIfMissing(ctx, tx, column.table, column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS idx_activations_account_id ON activations(account_id)")
+	if err != nil {
+		return err
+	}
+
+	if hadReservations {
+		return nil
+	}
+
+	// Занятость номеров раньше хранилась во флаге available; переносим ее в резервирования по сервисам
+	_, err = tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO number_reservations (number_id, service_id, activation_id)
+		SELECT number_id, service_id, id FROM activations WHERE status IN (?, ?, ?)`,
+		models.ActivationWaiting, models.ActivationCodeReceived, models.ActivationRetryRequested)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE phone_numbers SET available = 1 WHERE available = 0")
+	return err
+}
+
+// migrateCountryPrefixes переносит телефонные префиксы стран из кода в таблицу countries
+func migrateCountryPrefixes(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumnIfMissing(ctx, tx, "countries", "prefix", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		UPDATE countries SET prefix = CASE code
+			WHEN 'rus' THEN 7
+			WHEN 'uzb' THEN 998
+			WHEN 'bel' THEN 375
+			ELSE prefix
+		END
+		WHERE prefix = 0`)
+	return err
+}
+
+// migrateNumberTags добавляет номерам метки из выгрузок SIM-банков
+func migrateNumberTags(ctx context.Context, tx *sql.Tx) error {
+	return addColumnIfMissing(ctx, tx, "phone_numbers", "tags", "TEXT NOT NULL DEFAULT ''")
+}
+
+// timestampColumns - колонки DATETIME, которые переписывает migrateComparableTimestamps
+var timestampColumns = []struct{ table, column string }{
+	{"schema_migrations", "applied_at"},
+	{"countries", "created_at"},
+	{"services", "created_at"},
+	{"phone_numbers", "created_at"},
+	{"prices", "updated_at"},
+	{"accounts", "created_at"},
+	{"api_keys", "created_at"},
+	{"ledger_entries", "created_at"},
+	{"activations", "created_at"},
+	{"activations", "finished_at"},
+	{"sms_messages", "received_at"},
+}
+
+// legacyTimeFormats - форматы, в которых время попадало в базу до миграции 4:
+// time.Time.String() от драйвера и CURRENT_TIMESTAMP из значений по умолчанию
+var legacyTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05",
+	timeFormat,
+}
+
+// migrateComparableTimestamps переписывает время в формат timeFormat в UTC, чтобы
+// границы по created_at проверялись в SQL и по индексу
+func migrateComparableTimestamps(ctx context.Context, tx *sql.Tx) error {
+	type timestamp struct {
+		rowID int64
+		value string
+	}
+
+	for _, column := range timestampColumns {
+		// CAST убирает объявленный тип, и драйвер возвращает текст как есть
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+			"SELECT rowid, CAST(%s AS TEXT) FROM %s WHERE %s IS NOT NULL",
+			column.column, column.table, column.column))
+		if err != nil {
+			return err
+		}
+
+		var values []timestamp
+		for rows.Next() {
+			var value timestamp
+			if err := rows.Scan(&value.rowID, &value.value); err != nil {
+				rows.Close()
+				return err
+			}
+			values = append(values, value)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		rows.Close()
+
+		update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", column.table, column.column)
+		for _, value := range values {
+			t, err := parseLegacyTime(value.value)
+			if err != nil {
+				return fmt.Errorf("%s.%s of row %d: %w", column.table, column.column, value.rowID, err)
+			}
+			if _, err := tx.ExecContext(ctx, update, t.UTC().Format(timeFormat), value.rowID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseLegacyTime разбирает время в одном из legacyTimeFormats
+func parseLegacyTime(value string) (time.Time, error) {
+	// time.Time.String() дописывает после смещения название зоны и показания
+	// монотонных часов; для разбора достаточно даты, времени и смещения
+	if fields := strings.Fields(value); len(fields) > 3 {
+		value = strings.Join(fields[:3], " ")
+	}
+
+	for _, layout := range legacyTimeFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", value)
+}
+
+// tableExists проверяет наличие таблицы в схеме
+func tableExists(ctx context.Context, tx *sql.Tx, table string) (bool, error) {
+	var name string
+	err := tx.QueryRowContext(ctx,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// addColumnIfMissing добавляет колонку в таблицу, созданную более старой версией схемы
+func addColumnIfMissing(ctx context.Context, tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
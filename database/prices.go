@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"sms-api-service/storage"
+)
+
+var priceQueries = struct {
+	getPrices   string
+	listPrices  string
+	setPrice    string
+	seedPrice   string
+	deletePrice string
+}{
+	getPrices: `
+		SELECT c.code, p.operator, srv.code, p.price
+		FROM prices p
+		JOIN countries c ON p.country_id = c.id
+		JOIN services srv ON p.service_id = srv.id`,
+
+	listPrices: `
+		SELECT c.code, p.operator, srv.code, p.price
+		FROM prices p
+		JOIN countries c ON p.country_id = c.id
+		JOIN services srv ON p.service_id = srv.id
+		ORDER BY c.code, p.operator, srv.code`,
+
+	setPrice: `
+		INSERT INTO prices (country_id, operator, service_id, price, updated_at)
+		SELECT c.id, ?, srv.id, ?, ?
+		FROM countries c, services srv
+		WHERE c.code = ? AND srv.code = ?
+		ON CONFLICT (country_id, operator, service_id)
+		DO UPDATE SET price = excluded.price, updated_at = excluded.updated_at`,
+
+	seedPrice: `
+		INSERT OR IGNORE INTO prices (country_id, operator, service_id, price, updated_at)
+		SELECT c.id, ?, srv.id, ?, ?
+		FROM countries c, services srv
+		WHERE c.code = ? AND srv.code = ?`,
+
+	deletePrice: `
+		DELETE FROM prices
+		WHERE operator = ?
+		AND country_id = (SELECT id FROM countries WHERE code = ?)
+		AND service_id = (SELECT id FROM services WHERE code = ?)`,
+}
+
+func GetPrices(db *sql.DB) (map[string]map[string]map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, priceQueries.getPrices)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	countryMap := make(map[string]map[string]map[string]float64, 50)
+
+	for rows.Next() {
+		var country, operator, service string
+		var price float64
+
+		if err := rows.Scan(&country, &operator, &service, &price); err != nil {
+			continue
+		}
+
+		if countryMap[country] == nil {
+			countryMap[country] = make(map[string]map[string]float64, 10)
+		}
+		if countryMap[country][operator] == nil {
+			countryMap[country][operator] = make(map[string]float64, 20)
+		}
+
+		countryMap[country][operator][service] = price
+	}
+
+	return countryMap, rows.Err()
+}
+
+func ListPrices(db *sql.DB) ([]storage.Price, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, priceQueries.listPrices)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []storage.Price{}
+	for rows.Next() {
+		var price storage.Price
+		if err := rows.Scan(&price.Country, &price.Operator, &price.Service, &price.Price); err != nil {
+			return nil, err
+		}
+		prices = append(prices, price)
+	}
+
+	return prices, rows.Err()
+}
+
+// SetPrice создает или заменяет цену; sql.ErrNoRows, если нет страны или сервиса
+func SetPrice(db *sql.DB, price storage.Price) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, priceQueries.setPrice,
+		price.Operator, price.Price, utcNow(), price.Country, price.Service)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeletePrice удаляет цену; sql.ErrNoRows, если ее нет
+func DeletePrice(db *sql.DB, country, operator, service string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, priceQueries.deletePrice, operator, country, service)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// seedPrices добавляет цены, не перезаписывая измененные администратором
+func (d *Database) seedPrices(ctx context.Context, prices []storage.Price) error {
+	for _, price := range prices {
+		err := d.ExecuteWithRetry(ctx, priceQueries.seedPrice,
+			price.Operator, price.Price, utcNow(), price.Country, price.Service)
+		if err != nil {
+			return fmt.Errorf("failed to insert price %s/%s/%s: %w",
+				price.Country, price.Operator, price.Service, err)
+		}
+	}
+	return nil
+}
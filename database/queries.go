@@ -3,10 +3,14 @@ package database
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"sms-api-service/models"
+	"sms-api-service/smscode"
+	"sms-api-service/storage"
 )
 
 var (
@@ -37,14 +41,22 @@ var (
 	preparedQueries = struct {
 		getAvailableServices   string
 		getAvailableNumber     string
+		reserveNumber          string
+		recordNumberUsage      string
 		getServiceByCode       string
 		createActivation       string
 		setNumberAvailable     string
 		updateActivationStatus string
 		makeNumberAvailable    string
 		checkActivationExists  string
+		checkActivationOwned   string
+		getActivationStatus    string
+		countActiveActivations string
+		countByStatus          string
 		storeSMS               string
 		getActivationByID      string
+		getActivationTarget    string
+		expireActivation       string
 		getSMSByActivation     string
 	}{
 		getAvailableServices: `
@@ -53,22 +65,45 @@ var (
 			JOIN countries c ON pn.country_id = c.id
 			CROSS JOIN services srv
 			WHERE pn.available = 1
+			AND NOT EXISTS (
+				SELECT 1 FROM number_reservations nr
+				WHERE nr.number_id = pn.id AND nr.service_id = srv.id
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM number_usage nu
+				WHERE nu.number_id = pn.id AND nu.service_id = srv.id AND nu.used_at >= ?
+			)
 			GROUP BY c.code, pn.operator, srv.code
 			HAVING COUNT(*) > 0`,
 
 		getAvailableNumber: `
-			SELECT pn.id, pn.number 
+			SELECT pn.id, pn.number, pn.operator
 			FROM phone_numbers pn
 			JOIN countries c ON pn.country_id = c.id
-			WHERE c.code = ? AND pn.operator = ? AND pn.available = 1
-			ORDER BY RANDOM()
-			LIMIT 1`,
+			WHERE c.code = ? AND (? = 'any' OR pn.operator = ?) AND pn.available = 1
+			AND NOT EXISTS (
+				SELECT 1 FROM number_reservations nr
+				WHERE nr.number_id = pn.id AND nr.service_id = ?
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM number_usage nu
+				WHERE nu.number_id = pn.id AND nu.service_id = ? AND nu.used_at >= ?
+			)`,
+
+		reserveNumber: `
+			INSERT INTO number_reservations (number_id, service_id, activation_id)
+			VALUES (?, ?, ?)`,
+
+		recordNumberUsage: `
+			INSERT INTO number_usage (number_id, service_id, used_at)
+			SELECT number_id, service_id, ? FROM activations WHERE id = ?
+			ON CONFLICT (number_id, service_id) DO UPDATE SET used_at = excluded.used_at`,
 
 		getServiceByCode: `SELECT id, code, name FROM services WHERE code = ?`,
 
 		createActivation: `
-			INSERT INTO activations (number_id, service_id, sum, created_at)
-			VALUES (?, ?, ?, ?)`,
+			INSERT INTO activations (number_id, service_id, account_id, sum, created_at)
+			VALUES (?, ?, ?, ?, ?)`,
 
 		setNumberAvailable: `UPDATE phone_numbers SET available = ? WHERE id = ?`,
 
@@ -77,41 +112,53 @@ var (
 			SET status = ?, finished_at = ?
 			WHERE id = ?`,
 
-		makeNumberAvailable: `
-			UPDATE phone_numbers 
-			SET available = 1 
-			WHERE id = (SELECT number_id FROM activations WHERE id = ?)`,
+		makeNumberAvailable: `DELETE FROM number_reservations WHERE activation_id = ?`,
 
 		checkActivationExists: `SELECT 1 FROM activations WHERE id = ? LIMIT 1`,
 
+		checkActivationOwned: `SELECT 1 FROM activations WHERE id = ? AND account_id = ? LIMIT 1`,
+
+		getActivationStatus: `SELECT status FROM activations WHERE id = ? AND account_id = ?`,
+
+		countActiveActivations: `
+			SELECT COUNT(*) FROM activations
+			WHERE account_id = ? AND status IN (?, ?, ?)`,
+
+		countByStatus: `SELECT status, COUNT(*) FROM activations GROUP BY status`,
+
 		storeSMS: `
-			INSERT INTO sms_messages (activation_id, text, received_at)
-			VALUES (?, ?, ?)`,
+			INSERT INTO sms_messages (activation_id, text, code, received_at)
+			VALUES (?, ?, ?, ?)`,
 
 		getActivationByID: `
-			SELECT id, number_id, service_id, status, sum, created_at, finished_at
+			SELECT id, number_id, service_id, account_id, status, sum, created_at, finished_at, finish_reason
 			FROM activations WHERE id = ?`,
 
+		getActivationTarget: `
+			SELECT pn.number, srv.code
+			FROM activations a
+			JOIN phone_numbers pn ON a.number_id = pn.id
+			JOIN services srv ON a.service_id = srv.id
+			WHERE a.id = ?`,
+
+		expireActivation: `
+			UPDATE activations
+			SET status = ?, finished_at = ?, finish_reason = ?
+			WHERE id = ? AND status = ?`,
+
 		getSMSByActivation: `
-			SELECT id, activation_id, text, received_at
+			SELECT id, activation_id, text, COALESCE(code, ''), received_at
 			FROM sms_messages 
 			WHERE activation_id = ?
 			ORDER BY received_at ASC`,
 	}
 )
 
-var serviceCache = struct {
-	sync.RWMutex
-	services map[string]*models.Service
-}{
-	services: make(map[string]*models.Service),
-}
-
-func GetAvailableServices(db *sql.DB) (map[string]map[string]map[string]int, error) {
+func GetAvailableServices(db *sql.DB, usedSince time.Time) (map[string]map[string]map[string]int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := db.QueryContext(ctx, preparedQueries.getAvailableServices)
+	rows, err := db.QueryContext(ctx, preparedQueries.getAvailableServices, storage.NumberQuery{UsedSince: usedSince}.UsedSinceUnix())
 	if err != nil {
 		return nil, err
 	}
@@ -140,15 +187,122 @@ func GetAvailableServices(db *sql.DB) (map[string]map[string]map[string]int, err
 	return countryMap, rows.Err()
 }
 
-func GetAvailableNumber(db *sql.DB, country, operator string) (*models.PhoneNumber, error) {
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// numberFilters - необязательные условия выборки свободного номера. Выборка
+// повторяется без них, чтобы отличить отказ по цене или префиксам от пустого пула.
+type numberFilters struct {
+	prefixes bool // исключать номера с префиксами query.ExceptionPrefixes
+	price    bool // только номера, цена оператора которых не выше суммы клиента
+}
+
+// availableNumberQuery дополняет выборку свободного номера условием на цену и
+// исключениями по префиксам, чтобы номер, не попадающий под exceptionPhoneSet,
+// находился, если он есть в пуле
+func availableNumberQuery(query storage.NumberQuery, sum float64, filters numberFilters) (string, []interface{}) {
+	conditions, args := availableNumberConditions(query, sum, filters)
+	return preparedQueries.getAvailableNumber + conditions + numberSelectionSuffix, args
+}
+
+// availableNumberExistsQuery проверяет наличие подходящего номера без сортировки
+// всех кандидатов
+func availableNumberExistsQuery(query storage.NumberQuery, sum float64, filters numberFilters) (string, []interface{}) {
+	conditions, args := availableNumberConditions(query, sum, filters)
+	return "SELECT EXISTS (" + preparedQueries.getAvailableNumber + conditions + "\n\t\t)", args
+}
+
+func availableNumberConditions(query storage.NumberQuery, sum float64, filters numberFilters) (string, []interface{}) {
+	args := []interface{}{
+		query.Country, query.Operator, query.Operator, query.ServiceID, query.ServiceID, query.UsedSinceUnix(),
+	}
+
+	var sb strings.Builder
+	if filters.price {
+		sb.WriteString(numberPriceCondition)
+		args = append(args, query.ServiceID, sum)
+	}
+
+	if filters.prefixes {
+		// Одно сравнение начала номера со списком на каждую длину префикса:
+		// цепочка условий на каждый префикс упирается в лимит SQLite на глубину
+		// выражения и проверяет номер против всех префиксов по очереди
+		for _, group := range query.PrefixesByLength() {
+			fmt.Fprintf(&sb, "\n\t\t\tAND substr(CAST(pn.number AS TEXT), 1, %d) NOT IN (?%s)",
+				group.Length, strings.Repeat(", ?", len(group.Prefixes)-1))
+			for _, prefix := range group.Prefixes {
+				args = append(args, prefix)
+			}
+		}
+	}
+
+	return sb.String(), args
+}
+
+// numberPriceCondition сравнивает сумму с ценой оператора номера, а при ее
+// отсутствии - с ценой для any. Номера без цены доступны при любой сумме.
+const numberPriceCondition = `
+			AND COALESCE((
+				SELECT p.price FROM prices p
+				WHERE p.country_id = pn.country_id AND p.service_id = ?
+				AND p.operator IN (pn.operator, 'any')
+				ORDER BY p.operator = 'any'
+				LIMIT 1
+			), 0) <= ?`
+
+const numberSelectionSuffix = `
+			ORDER BY RANDOM()
+			LIMIT 1`
+
+// selectAvailableNumber выбирает свободный номер, подходящий по цене и префиксам.
+// Если такого нет, возвращает ErrPriceTooLow, когда мешает только цена,
+// ErrNumberExcluded, когда все номера под исключенными префиксами, и sql.ErrNoRows
+// для пустого пула.
+func selectAvailableNumber(ctx context.Context, q rowQueryer, query storage.NumberQuery, sum float64, phoneNumber *models.PhoneNumber) error {
+	sqlQuery, args := availableNumberQuery(query, sum, numberFilters{prefixes: true, price: true})
+	err := q.QueryRowContext(ctx, sqlQuery, args...).Scan(&phoneNumber.ID, &phoneNumber.Number, &phoneNumber.Operator)
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	exists, err := availableNumberExists(ctx, q, query, sum, numberFilters{prefixes: true})
+	if err != nil {
+		return err
+	}
+	if exists {
+		return storage.ErrPriceTooLow
+	}
+
+	if len(query.UniquePrefixes()) == 0 {
+		return sql.ErrNoRows
+	}
+
+	exists, err = availableNumberExists(ctx, q, query, sum, numberFilters{})
+	if err != nil {
+		return err
+	}
+	if exists {
+		return storage.ErrNumberExcluded
+	}
+	return sql.ErrNoRows
+}
+
+func availableNumberExists(ctx context.Context, q rowQueryer, query storage.NumberQuery, sum float64, filters numberFilters) (bool, error) {
+	sqlQuery, args := availableNumberExistsQuery(query, sum, filters)
+
+	var exists bool
+	err := q.QueryRowContext(ctx, sqlQuery, args...).Scan(&exists)
+	return exists, err
+}
+
+func GetAvailableNumber(db *sql.DB, query storage.NumberQuery, sum float64) (*models.PhoneNumber, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	phoneNumber := phoneNumberPool.Get().(*models.PhoneNumber)
 
-	err := db.QueryRowContext(ctx, preparedQueries.getAvailableNumber, country, operator).
-		Scan(&phoneNumber.ID, &phoneNumber.Number)
-	if err != nil {
+	if err := selectAvailableNumber(ctx, db, query, sum, phoneNumber); err != nil {
 		*phoneNumber = models.PhoneNumber{}
 		phoneNumberPool.Put(phoneNumber)
 		return nil, err
@@ -157,6 +311,79 @@ func GetAvailableNumber(db *sql.DB, country, operator string) (*models.PhoneNumb
 	return phoneNumber, nil
 }
 
+func ReserveNumber(db *sql.DB, query storage.NumberQuery, accountID int64, sum float64) (*models.PhoneNumber, uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkActiveLimit(ctx, tx, accountID, query.MaxActive); err != nil {
+		return nil, 0, err
+	}
+
+	phoneNumber := phoneNumberPool.Get().(*models.PhoneNumber)
+
+	if err := selectAvailableNumber(ctx, tx, query, sum, phoneNumber); err != nil {
+		ReturnPhoneNumber(phoneNumber)
+		return nil, 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, preparedQueries.createActivation,
+		phoneNumber.ID, query.ServiceID, accountID, sum, utcNow())
+	if err != nil {
+		ReturnPhoneNumber(phoneNumber)
+		return nil, 0, err
+	}
+
+	activationID, err := result.LastInsertId()
+	if err != nil {
+		ReturnPhoneNumber(phoneNumber)
+		return nil, 0, err
+	}
+
+	if err := holdFunds(ctx, tx, accountID, uint64(activationID), sum); err != nil {
+		ReturnPhoneNumber(phoneNumber)
+		return nil, 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, preparedQueries.reserveNumber,
+		phoneNumber.ID, query.ServiceID, activationID); err != nil {
+		ReturnPhoneNumber(phoneNumber)
+		return nil, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		ReturnPhoneNumber(phoneNumber)
+		return nil, 0, err
+	}
+
+	return phoneNumber, uint64(activationID), nil
+}
+
+// checkActiveLimit проверяет лимит незавершенных активаций счета внутри транзакции
+// резервирования; SQLite сериализует пишущие транзакции, так что два запроса не
+// могут одновременно пройти проверку
+func checkActiveLimit(ctx context.Context, tx *sql.Tx, accountID int64, maxActive int) error {
+	if maxActive <= 0 {
+		return nil
+	}
+
+	var count int
+	err := tx.QueryRowContext(ctx, preparedQueries.countActiveActivations, accountID,
+		models.ActivationWaiting, models.ActivationCodeReceived, models.ActivationRetryRequested).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count >= maxActive {
+		return storage.ErrTooManyActivations
+	}
+	return nil
+}
+
 func ReturnPhoneNumber(phoneNumber *models.PhoneNumber) {
 	if phoneNumber != nil {
 		*phoneNumber = models.PhoneNumber{}
@@ -165,15 +392,6 @@ func ReturnPhoneNumber(phoneNumber *models.PhoneNumber) {
 }
 
 func GetServiceByCode(db *sql.DB, serviceCode string) (*models.Service, error) {
-	serviceCache.RLock()
-	if cachedService, exists := serviceCache.services[serviceCode]; exists {
-		serviceCache.RUnlock()
-		service := servicePool.Get().(*models.Service)
-		*service = *cachedService
-		return service, nil
-	}
-	serviceCache.RUnlock()
-
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
@@ -186,14 +404,6 @@ func GetServiceByCode(db *sql.DB, serviceCode string) (*models.Service, error) {
 		return nil, err
 	}
 
-	serviceCache.Lock()
-	serviceCache.services[serviceCode] = &models.Service{
-		ID:   service.ID,
-		Code: service.Code,
-		Name: service.Name,
-	}
-	serviceCache.Unlock()
-
 	return service, nil
 }
 
@@ -204,12 +414,12 @@ func ReturnService(service *models.Service) {
 	}
 }
 
-func CreateActivation(db *sql.DB, numberID, serviceID int, sum float64) (uint64, error) {
+func CreateActivation(db *sql.DB, numberID, serviceID int, accountID int64, sum float64) (uint64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	result, err := db.ExecContext(ctx, preparedQueries.createActivation,
-		numberID, serviceID, sum, time.Now())
+		numberID, serviceID, accountID, sum, utcNow())
 	if err != nil {
 		return 0, err
 	}
@@ -235,7 +445,7 @@ func UpdateActivationStatus(db *sql.DB, activationID uint64, status int) error {
 	defer cancel()
 
 	result, err := db.ExecContext(ctx, preparedQueries.updateActivationStatus,
-		status, time.Now(), activationID)
+		status, utcNow(), activationID)
 	if err != nil {
 		return err
 	}
@@ -252,6 +462,209 @@ func UpdateActivationStatus(db *sql.DB, activationID uint64, status int) error {
 	return nil
 }
 
+func TransitionActivation(db *sql.DB, accountID int64, activationID uint64, status int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := transitionActivation(ctx, tx, accountID, activationID, status); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReceiveSMS переводит активацию в CODE_RECEIVED и сохраняет SMS в одной транзакции
+func ReceiveSMS(db *sql.DB, accountID int64, activationID uint64, smsText string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err := transitionActivation(ctx, tx, accountID, activationID, models.ActivationCodeReceived); err != nil {
+		return "", err
+	}
+
+	var number uint64
+	var serviceCode string
+	if err := tx.QueryRowContext(ctx, preparedQueries.getActivationTarget, activationID).
+		Scan(&number, &serviceCode); err != nil {
+		return "", err
+	}
+
+	code := smscode.Extract(serviceCode, smsText)
+
+	var storedCode interface{}
+	if code != "" {
+		storedCode = code
+	}
+
+	if _, err := tx.ExecContext(ctx, preparedQueries.storeSMS,
+		activationID, smsText, storedCode, utcNow()); err != nil {
+		return "", err
+	}
+
+	return code, tx.Commit()
+}
+
+// transitionActivation выполняет переход статуса внутри транзакции tx
+func transitionActivation(ctx context.Context, tx *sql.Tx, accountID int64, activationID uint64, status int) error {
+	if !models.IsKnownActivationStatus(status) {
+		return storage.ErrBadTransition
+	}
+
+	// Прежний статус нужен, чтобы решить судьбу заблокированной суммы. Транзакция
+	// уже держит блокировку записи (_txlock=immediate), а условие на status в
+	// UPDATE защищает от изменения статуса и без нее.
+	var from int
+	err := tx.QueryRowContext(ctx, preparedQueries.getActivationStatus, activationID, accountID).Scan(&from)
+	if err != nil {
+		return err
+	}
+	if !models.CanTransitionActivation(from, status) {
+		return storage.ErrBadTransition
+	}
+
+	final := models.IsFinalActivationStatus(status)
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE activations
+		SET status = ?, finished_at = CASE WHEN ? THEN ? ELSE finished_at END
+		WHERE id = ? AND status = ?`,
+		status, final, utcNow(), activationID, from)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return storage.ErrBadTransition
+	}
+
+	if status == models.ActivationCodeReceived || status == models.ActivationCompleted {
+		if _, err := tx.ExecContext(ctx, preparedQueries.recordNumberUsage,
+			time.Now().Unix(), activationID); err != nil {
+			return err
+		}
+	}
+
+	if final {
+		if _, err := tx.ExecContext(ctx, preparedQueries.makeNumberAvailable, activationID); err != nil {
+			return err
+		}
+
+		if err := settleActivation(ctx, tx, activationID, storage.SettlementKind(from, status)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ExpireActivations(db *sql.DB, createdBefore, now time.Time, reason string) ([]storage.ExpiredActivation, error) {
+	sources := models.ActivationSourceStatuses(models.ActivationExpired)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	args := make([]interface{}, len(sources))
+	placeholders := make([]string, len(sources))
+	for i, source := range sources {
+		placeholders[i] = "?"
+		args[i] = source
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, COALESCE(account_id, 0), status
+		FROM activations
+		WHERE status IN (`+strings.Join(placeholders, ", ")+`) AND created_at < ?`,
+		append(args, createdBefore.UTC())...)
+	if err != nil {
+		return nil, err
+	}
+
+	type staleActivation struct {
+		id        uint64
+		accountID int64
+		status    int
+	}
+	var stale []staleActivation
+
+	for rows.Next() {
+		var activation staleActivation
+		if err := rows.Scan(&activation.id, &activation.accountID, &activation.status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stale = append(stale, activation)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	expired := make([]storage.ExpiredActivation, 0, len(stale))
+	for _, activation := range stale {
+		closed, err := closeActivation(ctx, tx, activation.id, activation.status,
+			models.ActivationExpired, now, reason)
+		if err != nil {
+			return nil, err
+		}
+		if closed {
+			expired = append(expired, storage.ExpiredActivation{ID: activation.id, AccountID: activation.accountID})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+// closeActivation переводит активацию из статуса from в финальный статус с указанием причины,
+// снимает резервирование номера и возвращает заблокированную сумму. false означает,
+// что статус активации уже изменился.
+func closeActivation(ctx context.Context, tx *sql.Tx, activationID uint64, from, status int, now time.Time, reason string) (bool, error) {
+	result, err := tx.ExecContext(ctx, preparedQueries.expireActivation, status, now.UTC(), reason, activationID, from)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, preparedQueries.makeNumberAvailable, activationID); err != nil {
+		return false, err
+	}
+
+	if err := settleActivation(ctx, tx, activationID, storage.SettlementKind(from, status)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func MakeNumberAvailableByActivation(db *sql.DB, activationID uint64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
@@ -275,13 +688,51 @@ func CheckActivationExists(db *sql.DB, activationID uint64) (bool, error) {
 	return true, nil
 }
 
-func StoreSMS(db *sql.DB, activationID uint64, smsText string) error {
+func CheckActivationOwned(db *sql.DB, activationID uint64, accountID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var exists int
+	err := db.QueryRowContext(ctx, preparedQueries.checkActivationOwned, activationID, accountID).Scan(&exists)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func CountActiveActivations(db *sql.DB, accountID int64) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	_, err := db.ExecContext(ctx, preparedQueries.storeSMS,
-		activationID, smsText, time.Now())
-	return err
+	var count int
+	err := db.QueryRowContext(ctx, preparedQueries.countActiveActivations, accountID,
+		models.ActivationWaiting, models.ActivationCodeReceived, models.ActivationRetryRequested).Scan(&count)
+	return count, err
+}
+
+func CountActivationsByStatus(db *sql.DB) (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, preparedQueries.countByStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int, 6)
+	for rows.Next() {
+		var status, count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
 }
 
 func GetActivationByID(db *sql.DB, activationID uint64) (*models.Activation, error) {
@@ -294,10 +745,12 @@ func GetActivationByID(db *sql.DB, activationID uint64) (*models.Activation, err
 		&activation.ID,
 		&activation.NumberID,
 		&activation.ServiceID,
+		&activation.AccountID,
 		&activation.Status,
 		&activation.Sum,
 		&activation.CreatedAt,
 		&activation.FinishedAt,
+		&activation.FinishReason,
 	)
 	if err != nil {
 		*activation = models.Activation{}
@@ -308,6 +761,21 @@ func GetActivationByID(db *sql.DB, activationID uint64) (*models.Activation, err
 	return activation, nil
 }
 
+func GetActivationTarget(db *sql.DB, activationID uint64) (uint64, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var number uint64
+	var serviceCode string
+	err := db.QueryRowContext(ctx, preparedQueries.getActivationTarget, activationID).
+		Scan(&number, &serviceCode)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return number, serviceCode, nil
+}
+
 func ReturnActivation(activation *models.Activation) {
 	if activation != nil {
 		*activation = models.Activation{}
@@ -330,7 +798,7 @@ func GetSMSByActivation(db *sql.DB, activationID uint64) ([]models.SMS, error) {
 
 	for rows.Next() {
 		var sms models.SMS
-		if err := rows.Scan(&sms.ID, &sms.ActivationID, &sms.Text, &sms.ReceivedAt); err != nil {
+		if err := rows.Scan(&sms.ID, &sms.ActivationID, &sms.Text, &sms.Code, &sms.ReceivedAt); err != nil {
 			continue
 		}
 		messages = append(messages, sms)
@@ -350,12 +818,3 @@ func GetSMSByActivation(db *sql.DB, activationID uint64) ([]models.SMS, error) {
 
 	return result, nil
 }
-
-func ClearServiceCache() {
-	serviceCache.Lock()
-	defer serviceCache.Unlock()
-
-	for k := range serviceCache.services {
-		delete(serviceCache.services, k)
-	}
-}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"sms-api-service/storage"
+	"sms-api-service/storage/storagetest"
+)
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Backend { return newTestDatabase(t) })
+}
+
+// TestStorageConformanceConnectionPool повторяет проверки с пулом из нескольких
+// соединений, чтобы параллельные транзакции действительно конкурировали
+func TestStorageConformanceConnectionPool(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Backend {
+		config := DefaultConfig(filepath.Join(t.TempDir(), "sms.db"))
+		config.MaxOpenConns = 8
+		config.MaxIdleConns = 8
+
+		db, err := Init(config)
+		if err != nil {
+			t.Fatalf("init: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if err := db.Migrate(context.Background()); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		return db
+	})
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+// Database реализует storage.Backend поверх SQLite
+var _ storage.Backend = (*Database)(nil)
+
+// notFound переводит sql.ErrNoRows в ошибку хранилища
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+func (d *Database) GetAvailableServices(usedSince time.Time) (map[string]map[string]map[string]int, error) {
+	return GetAvailableServices(d.DB, usedSince)
+}
+
+// GetServiceByCode кеширует сервисы, чтобы GET_NUMBER не обращался за ними к базе
+func (d *Database) GetServiceByCode(code string) (models.Service, error) {
+	d.servicesMu.RLock()
+	cached, ok := d.services[code]
+	d.servicesMu.RUnlock()
+	if ok {
+		return cached, nil
+	}
+
+	service, err := GetServiceByCode(d.DB, code)
+	if err != nil {
+		return models.Service{}, notFound(err)
+	}
+	defer ReturnService(service)
+
+	d.servicesMu.Lock()
+	d.services[code] = *service
+	d.servicesMu.Unlock()
+
+	return *service, nil
+}
+
+// forgetService убирает сервис из кеша после изменения
+func (d *Database) forgetService(code string) {
+	d.servicesMu.Lock()
+	delete(d.services, code)
+	d.servicesMu.Unlock()
+}
+
+func (d *Database) ReserveNumber(query storage.NumberQuery, accountID int64, sum float64) (models.PhoneNumber, uint64, error) {
+	phoneNumber, activationID, err := ReserveNumber(d.DB, query, accountID, sum)
+	if err != nil {
+		return models.PhoneNumber{}, 0, notFound(err)
+	}
+	defer ReturnPhoneNumber(phoneNumber)
+
+	return *phoneNumber, activationID, nil
+}
+
+func (d *Database) TransitionActivation(accountID int64, activationID uint64, status int) error {
+	return notFound(TransitionActivation(d.DB, accountID, activationID, status))
+}
+
+func (d *Database) ExpireActivations(createdBefore, now time.Time, reason string) ([]storage.ExpiredActivation, error) {
+	return ExpireActivations(d.DB, createdBefore, now, reason)
+}
+
+func (d *Database) GetActivation(activationID uint64) (models.Activation, error) {
+	activation, err := GetActivationByID(d.DB, activationID)
+	if err != nil {
+		return models.Activation{}, notFound(err)
+	}
+	defer ReturnActivation(activation)
+
+	return *activation, nil
+}
+
+func (d *Database) GetActivationTarget(activationID uint64) (uint64, string, error) {
+	number, serviceCode, err := GetActivationTarget(d.DB, activationID)
+	return number, serviceCode, notFound(err)
+}
+
+func (d *Database) CheckActivationOwned(activationID uint64, accountID int64) (bool, error) {
+	return CheckActivationOwned(d.DB, activationID, accountID)
+}
+
+func (d *Database) CountActiveActivations(accountID int64) (int, error) {
+	return CountActiveActivations(d.DB, accountID)
+}
+
+func (d *Database) CountActivationsByStatus() (map[int]int, error) {
+	return CountActivationsByStatus(d.DB)
+}
+
+func (d *Database) ReceiveSMS(accountID int64, activationID uint64, text string) (string, error) {
+	code, err := ReceiveSMS(d.DB, accountID, activationID, text)
+	return code, notFound(err)
+}
+
+func (d *Database) GetSMSByActivation(activationID uint64) ([]models.SMS, error) {
+	return GetSMSByActivation(d.DB, activationID)
+}
+
+func (d *Database) GetAccount(accountID int64) (models.Account, error) {
+	account, err := GetAccountByID(d.DB, accountID)
+	if err != nil {
+		return models.Account{}, notFound(err)
+	}
+	return *account, nil
+}
+
+func (d *Database) GetPrices() (map[string]map[string]map[string]float64, error) {
+	return GetPrices(d.DB)
+}
+
+func (d *Database) AuthenticateAPIKey(key string, now time.Time) (models.APIKey, error) {
+	apiKey, err := AuthenticateAPIKey(d.DB, key, now)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	return *apiKey, nil
+}
+
+func (d *Database) EnsureAccount(name string) (int64, error) {
+	return EnsureAccount(d.DB, name)
+}
+
+func (d *Database) CreateAPIKey(accountID int64, name string, expiresAt *time.Time) (string, models.APIKey, error) {
+	key, apiKey, err := CreateAPIKey(d.DB, accountID, name, expiresAt)
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+	return key, *apiKey, nil
+}
+
+func (d *Database) ListAPIKeys() ([]models.APIKey, error) {
+	return ListAPIKeys(d.DB)
+}
+
+func (d *Database) SetAPIKeyEnabled(keyID int64, enabled bool) error {
+	return notFound(SetAPIKeyEnabled(d.DB, keyID, enabled))
+}
+
+func (d *Database) SetAPIKeyExpiry(keyID int64, expiresAt *time.Time) error {
+	return notFound(SetAPIKeyExpiry(d.DB, keyID, expiresAt))
+}
+
+func (d *Database) SetAPIKeyLimits(keyID int64, rateLimit *float64, rateBurst, maxActiveActivations *int) error {
+	return notFound(SetAPIKeyLimits(d.DB, keyID, rateLimit, rateBurst, maxActiveActivations))
+}
+
+func (d *Database) ListCountries() ([]models.Country, error) {
+	return ListCountries(d.DB)
+}
+
+func (d *Database) GetCountry(code string) (models.Country, error) {
+	country, err := GetCountry(d.DB, code)
+	return country, notFound(err)
+}
+
+func (d *Database) CreateCountry(country models.Country) (models.Country, error) {
+	created, err := CreateCountry(d.DB, country)
+	return created, notFound(err)
+}
+
+func (d *Database) UpdateCountry(country models.Country) (models.Country, error) {
+	updated, err := UpdateCountry(d.DB, country)
+	return updated, notFound(err)
+}
+
+func (d *Database) DeleteCountry(code string) error {
+	return notFound(DeleteCountry(d.DB, code))
+}
+
+func (d *Database) ListServices() ([]models.Service, error) {
+	return ListServices(d.DB)
+}
+
+func (d *Database) CreateService(service models.Service) (models.Service, error) {
+	created, err := CreateService(d.DB, service)
+	return created, notFound(err)
+}
+
+func (d *Database) UpdateService(service models.Service) (models.Service, error) {
+	updated, err := UpdateService(d.DB, service)
+	d.forgetService(service.Code)
+	return updated, notFound(err)
+}
+
+func (d *Database) DeleteService(code string) error {
+	err := DeleteService(d.DB, code)
+	if err == nil {
+		d.forgetService(code)
+	}
+	return notFound(err)
+}
+
+func (d *Database) ListNumbers(filter storage.NumberFilter) ([]models.PhoneNumber, error) {
+	return ListNumbers(d.DB, filter)
+}
+
+func (d *Database) GetNumber(numberID int) (models.PhoneNumber, error) {
+	number, err := GetNumber(d.DB, numberID)
+	return number, notFound(err)
+}
+
+func (d *Database) CreateNumber(number models.PhoneNumber) (models.PhoneNumber, error) {
+	created, err := CreateNumber(d.DB, number)
+	return created, notFound(err)
+}
+
+func (d *Database) ImportNumbers(numbers []models.PhoneNumber) (int, error) {
+	return ImportNumbers(d.DB, numbers)
+}
+
+func (d *Database) UpdateNumber(number models.PhoneNumber) (models.PhoneNumber, error) {
+	updated, err := UpdateNumber(d.DB, number)
+	return updated, notFound(err)
+}
+
+func (d *Database) DeleteNumber(numberID int) error {
+	return notFound(DeleteNumber(d.DB, numberID))
+}
+
+func (d *Database) SetNumberAvailable(numberID int, available bool) error {
+	return notFound(execUpdate(d.DB, preparedQueries.setNumberAvailable, available, numberID))
+}
+
+func (d *Database) ReleaseNumber(numberID int, now time.Time) ([]storage.ExpiredActivation, error) {
+	released, err := ReleaseNumber(d.DB, numberID, now)
+	return released, notFound(err)
+}
+
+func (d *Database) ListActivations(filter storage.ActivationFilter) ([]models.Activation, error) {
+	return ListActivations(d.DB, filter)
+}
+
+func (d *Database) ListPrices() ([]storage.Price, error) {
+	return ListPrices(d.DB)
+}
+
+func (d *Database) SetPrice(price storage.Price) error {
+	return notFound(SetPrice(d.DB, price))
+}
+
+func (d *Database) DeletePrice(country, operator, service string) error {
+	return notFound(DeletePrice(d.DB, country, operator, service))
+}
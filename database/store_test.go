@@ -0,0 +1,346 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+	"sms-api-service/storage/storagetest"
+)
+
+// newTestDatabase открывает пустую базу во временном каталоге и применяет миграции
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	db, err := Init(DefaultConfig(filepath.Join(t.TempDir(), "sms.db")))
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func seedServices(t *testing.T, db *Database, services ...models.Service) {
+	t.Helper()
+
+	if err := db.Seed(context.Background(), &storage.SeedData{Services: services}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+}
+
+// TestServiceCacheIsPerDatabase проверяет, что кеш сервисов одной базы
+// не отдает записи другой: в каждой базе у tg свой идентификатор
+func TestServiceCacheIsPerDatabase(t *testing.T) {
+	first := newTestDatabase(t)
+	seedServices(t, first, models.Service{Code: "vk", Name: "VKontakte"}, models.Service{Code: "tg", Name: "Telegram"})
+
+	second := newTestDatabase(t)
+	seedServices(t, second, models.Service{Code: "tg", Name: "Telegram"})
+
+	firstTG, err := first.GetServiceByCode("tg")
+	if err != nil {
+		t.Fatalf("first: %v", err)
+	}
+	secondTG, err := second.GetServiceByCode("tg")
+	if err != nil {
+		t.Fatalf("second: %v", err)
+	}
+
+	if firstTG.ID == secondTG.ID {
+		t.Fatalf("both databases returned service id %d", firstTG.ID)
+	}
+}
+
+func TestServiceCacheInvalidatedOnUpdate(t *testing.T) {
+	db := newTestDatabase(t)
+	seedServices(t, db, models.Service{Code: "tg", Name: "Telegram"})
+
+	if _, err := db.GetServiceByCode("tg"); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if _, err := db.UpdateService(models.Service{Code: "tg", Name: "Telegram Messenger"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	service, err := db.GetServiceByCode("tg")
+	if err != nil {
+		t.Fatalf("get after update: %v", err)
+	}
+	if service.Name != "Telegram Messenger" {
+		t.Errorf("name = %q, want the updated name", service.Name)
+	}
+
+	if err := db.DeleteService("tg"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := db.GetServiceByCode("tg"); err != storage.ErrNotFound {
+		t.Errorf("get after delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+// newPoolDatabase заполняет базу данными storagetest.Fixture с номерами
+// перечисленных операторов и ценами prices
+func newPoolDatabase(t *testing.T, prices []storage.Price, operators ...string) (*Database, storage.NumberQuery, int64) {
+	t.Helper()
+
+	f := storagetest.NewFixture(t, func(t *testing.T) storage.Backend { return newTestDatabase(t) }, operators...)
+	f.SetPrices(t, prices...)
+	return f.Store.(*Database), f.TG, f.AccountID
+}
+
+func TestReserveNumberChecksPriceOfSelectedOperator(t *testing.T) {
+	db, query, accountID := newPoolDatabase(t, []storage.Price{
+		{Country: "rus", Operator: "any", Service: "tg", Price: 10},
+		{Country: "rus", Operator: "mts", Service: "tg", Price: 50},
+	}, "mts", "beeline")
+
+	query.Operator = "mts"
+	if _, _, err := db.ReserveNumber(query, accountID, 10); err != storage.ErrPriceTooLow {
+		t.Errorf("mts for 10: err = %v, want ErrPriceTooLow", err)
+	}
+
+	query.Operator = storage.AnyOperator
+	number, _, err := db.ReserveNumber(query, accountID, 10)
+	if err != nil || number.Operator != "beeline" {
+		t.Fatalf("any for 10: got %+v, %v; want the beeline number", number, err)
+	}
+
+	if _, _, err := db.ReserveNumber(query, accountID, 10); err != storage.ErrPriceTooLow {
+		t.Errorf("any for 10 with only mts left: err = %v, want ErrPriceTooLow", err)
+	}
+	if number, _, err := db.ReserveNumber(query, accountID, 50); err != nil || number.Operator != "mts" {
+		t.Errorf("any for 50: got %+v, %v; want the mts number", number, err)
+	}
+}
+
+func TestAvailableNumberQueryArgs(t *testing.T) {
+	query := storage.NumberQuery{
+		Country: "rus", Operator: "mts", ServiceID: 3,
+		ExceptionPrefixes: []string{"7900", "79", "", "7901", "79", " "},
+	}
+	base := []interface{}{"rus", "mts", "mts", 3, 3, int64(0)}
+
+	tests := []struct {
+		name    string
+		filters numberFilters
+		args    []interface{}
+	}{
+		{"no filters", numberFilters{}, base},
+		{"prefixes", numberFilters{prefixes: true}, append(base[:6:6], "79", "7900", "7901")},
+		{"price", numberFilters{price: true}, append(base[:6:6], 3, 12.5)},
+		{"price and prefixes", numberFilters{prefixes: true, price: true}, append(base[:6:6], 3, 12.5, "79", "7900", "7901")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlQuery, args := availableNumberQuery(query, 12.5, tt.filters)
+			if fmt.Sprint(args) != fmt.Sprint(tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+			if placeholders := strings.Count(sqlQuery, "?"); placeholders != len(args) {
+				t.Errorf("%d placeholders for %d args", placeholders, len(args))
+			}
+			// Префиксы одной длины проверяются одним условием
+			if tt.filters.prefixes && strings.Count(sqlQuery, "NOT IN") != 2 {
+				t.Errorf("want one NOT IN per prefix length in %s", sqlQuery)
+			}
+		})
+	}
+}
+
+// TestGetAvailableNumberWithManyPrefixes проверяет выбор в пуле, почти целиком
+// закрытом префиксами: ErrNumberExcluded возвращается, только когда исключены все
+// номера, а отказ по цене имеет приоритет
+func TestGetAvailableNumberWithManyPrefixes(t *testing.T) {
+	const poolSize = 300
+	operators := make([]string, poolSize)
+	for i := range operators {
+		operators[i] = "mts"
+	}
+	db, query, _ := newPoolDatabase(t, []storage.Price{
+		{Country: "rus", Operator: "mts", Service: "tg", Price: 50},
+	}, operators...)
+
+	last := fmt.Sprint(79000000000 + poolSize)
+	var prefixes []string
+	for i := 1; i < poolSize; i++ {
+		prefixes = append(prefixes, fmt.Sprint(79000000000+i))
+	}
+
+	query.ExceptionPrefixes = append(prefixes, "")
+	number, err := GetAvailableNumber(db.DB, query, 50)
+	if err != nil || fmt.Sprint(number.Number) != last {
+		t.Fatalf("one number left: got %v, %v; want %s", number, err, last)
+	}
+	if _, err := GetAvailableNumber(db.DB, query, 10); err != storage.ErrPriceTooLow {
+		t.Errorf("one number left, low sum: err = %v, want ErrPriceTooLow", err)
+	}
+
+	query.ExceptionPrefixes = append(prefixes, last)
+	if _, err := GetAvailableNumber(db.DB, query, 50); err != storage.ErrNumberExcluded {
+		t.Errorf("all excluded: err = %v, want ErrNumberExcluded", err)
+	}
+	if _, err := GetAvailableNumber(db.DB, query, 10); err != storage.ErrNumberExcluded {
+		t.Errorf("all excluded, low sum: err = %v, want ErrNumberExcluded", err)
+	}
+
+	query.ExceptionPrefixes = []string{"7900000"}
+	if _, err := GetAvailableNumber(db.DB, query, 50); err != storage.ErrNumberExcluded {
+		t.Errorf("short prefix: err = %v, want ErrNumberExcluded", err)
+	}
+
+	query.ExceptionPrefixes = []string{"", " "}
+	if _, err := GetAvailableNumber(db.DB, query, 50); err != nil {
+		t.Errorf("empty prefixes: %v", err)
+	}
+
+	query.Country = "kaz"
+	query.ExceptionPrefixes = prefixes
+	if _, err := GetAvailableNumber(db.DB, query, 50); err != sql.ErrNoRows {
+		t.Errorf("empty pool: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestListActivationsFiltersByCreatedAt(t *testing.T) {
+	db, query, accountID := newPoolDatabase(t, nil, "mts", "mts", "mts")
+
+	before := time.Now().Add(-time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, _, err := db.ReserveNumber(query, accountID, 1); err != nil {
+			t.Fatalf("reserve: %v", err)
+		}
+	}
+	after := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name   string
+		filter storage.ActivationFilter
+		want   []uint64
+	}{
+		{"after", storage.ActivationFilter{CreatedAfter: before}, []uint64{3, 2, 1}},
+		{"before", storage.ActivationFilter{CreatedBefore: after}, []uint64{3, 2, 1}},
+		{"range", storage.ActivationFilter{CreatedAfter: before, CreatedBefore: after}, []uint64{3, 2, 1}},
+		{"in the future", storage.ActivationFilter{CreatedAfter: after}, nil},
+		{"in the past", storage.ActivationFilter{CreatedBefore: before}, nil},
+		{"page", storage.ActivationFilter{CreatedAfter: before, Limit: 1, Offset: 1}, []uint64{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activations, err := db.ListActivations(tt.filter)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+
+			var got []uint64
+			for _, activation := range activations {
+				got = append(got, activation.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestComparableTimestampsMigration записывает created_at в форматах до миграции 4
+// и проверяет, что после перезаписи границы по времени отбираются в SQL
+func TestComparableTimestampsMigration(t *testing.T) {
+	db, query, accountID := newPoolDatabase(t, nil, "mts", "mts", "mts")
+	for i := 0; i < 3; i++ {
+		if _, _, err := db.ReserveNumber(query, accountID, 1); err != nil {
+			t.Fatalf("reserve: %v", err)
+		}
+	}
+
+	// 12:00:00.5, 12:30 и 13:00 UTC: time.Time.String() с зоной, CURRENT_TIMESTAMP
+	// и String() со смещением без названия зоны
+	for id, createdAt := range map[int]string{
+		1: "2026-01-01 15:00:00.5 +0300 MSK m=+0.000000001",
+		2: "2026-01-01 12:30:00",
+		3: "2026-01-01 08:00:00 -0500 -0500",
+	} {
+		if _, err := db.Exec("UPDATE activations SET created_at = ? WHERE id = ?", createdAt, id); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := migrateComparableTimestamps(context.Background(), tx); err != nil {
+		tx.Rollback()
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// Границы в другом часовом поясе приводятся к UTC
+	zone := time.FixedZone("UTC+5", 5*3600)
+	from := time.Date(2026, 1, 1, 17, 10, 0, 0, zone)
+	to := time.Date(2026, 1, 1, 17, 45, 0, 0, zone)
+
+	activations, err := db.ListActivations(storage.ActivationFilter{CreatedAfter: from, CreatedBefore: to})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(activations) != 1 || activations[0].ID != 2 {
+		t.Fatalf("activations = %+v, want only 2", activations)
+	}
+	if want := time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC); !activations[0].CreatedAt.Equal(want) {
+		t.Errorf("created_at = %v, want %v", activations[0].CreatedAt, want)
+	}
+
+	expired, err := db.ExpireActivations(from, time.Now(), "test")
+	if err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != 1 {
+		t.Errorf("expired = %+v, want only 1", expired)
+	}
+}
+
+func TestSetPriceOverwritesSeededPrice(t *testing.T) {
+	db, _, _ := newPoolDatabase(t, []storage.Price{{Country: "rus", Operator: "any", Service: "tg", Price: 10}})
+
+	if err := db.SetPrice(storage.Price{Country: "rus", Operator: "any", Service: "tg", Price: 15}); err != nil {
+		t.Fatalf("set price: %v", err)
+	}
+	if err := db.SetPrice(storage.Price{Country: "xxx", Operator: "any", Service: "tg", Price: 15}); err != storage.ErrNotFound {
+		t.Errorf("unknown country: err = %v, want ErrNotFound", err)
+	}
+
+	// Повторное заполнение не возвращает старую цену
+	err := db.Seed(context.Background(), &storage.SeedData{
+		Prices: []storage.Price{{Country: "rus", Operator: "any", Service: "tg", Price: 10}},
+	})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	prices, err := db.ListPrices()
+	if err != nil {
+		t.Fatalf("list prices: %v", err)
+	}
+	if len(prices) != 1 || prices[0].Price != 15 {
+		t.Errorf("prices = %v, want the updated price 15", prices)
+	}
+
+	if err := db.DeletePrice("rus", "any", "tg"); err != nil {
+		t.Fatalf("delete price: %v", err)
+	}
+	if err := db.DeletePrice("rus", "any", "tg"); err != storage.ErrNotFound {
+		t.Errorf("second delete: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"sms-api-service/models"
+)
+
+type contextKey int
+
+const (
+	apiKeyContextKey contextKey = iota
+	requestInfoContextKey
+)
+
+// WithAPIKey сохраняет в контексте ключ, которым аутентифицирован запрос
+func WithAPIKey(ctx context.Context, apiKey *models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, apiKey)
+}
+
+// APIKeyFromContext возвращает ключ запроса или nil, если запрос не аутентифицирован
+func APIKeyFromContext(ctx context.Context) *models.APIKey {
+	apiKey, _ := ctx.Value(apiKeyContextKey).(*models.APIKey)
+	return apiKey
+}
+
+// RequestInfo - сведения о запросе для журнала. Обработчики дополняют их по ходу работы.
+type RequestInfo struct {
+	ID           string
+	Logger       *slog.Logger
+	ActivationID uint64
+}
+
+// WithRequestInfo сохраняет в контексте сведения о запросе
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoContextKey, info)
+}
+
+// RequestInfoFromContext возвращает сведения о запросе или nil
+func RequestInfoFromContext(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*RequestInfo)
+	return info
+}
+
+// requestLogger возвращает журнал запроса с его идентификатором
+func requestLogger(r *http.Request) *slog.Logger {
+	if info := RequestInfoFromContext(r.Context()); info != nil && info.Logger != nil {
+		return info.Logger
+	}
+	return slog.Default()
+}
+
+// setRequestActivation запоминает активацию, к которой относится запрос
+func setRequestActivation(r *http.Request, activationID uint64) {
+	if info := RequestInfoFromContext(r.Context()); info != nil {
+		info.ActivationID = activationID
+	}
+}
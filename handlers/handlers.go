@@ -1,16 +1,17 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	"sms-api-service/config"
-	"sms-api-service/database"
+	"sms-api-service/hub"
+	"sms-api-service/metrics"
+	"sms-api-service/models"
+	"sms-api-service/storage"
 	"sms-api-service/types"
 )
 
@@ -33,33 +34,57 @@ var (
 		},
 	}
 
+	getStatusRequestPool = sync.Pool{
+		New: func() interface{} {
+			return &types.GetStatusRequest{}
+		},
+	}
+
+	waitSMSRequestPool = sync.Pool{
+		New: func() interface{} {
+			return &types.WaitSMSRequest{}
+		},
+	}
+
 	getServicesResponsePool = sync.Pool{
 		New: func() interface{} {
 			return &types.GetServicesResponse{}
 		},
 	}
 
+	getPricesResponsePool = sync.Pool{
+		New: func() interface{} {
+			return &types.GetPricesResponse{}
+		},
+	}
+
 	getNumberResponsePool = sync.Pool{
 		New: func() interface{} {
 			return &types.GetNumberResponse{}
 		},
 	}
 
-	baseResponsePool = sync.Pool{
+	getBalanceResponsePool = sync.Pool{
 		New: func() interface{} {
-			return &types.BaseResponse{}
+			return &types.GetBalanceResponse{}
 		},
 	}
 
-	countryListSlicePool = sync.Pool{
+	getStatusResponsePool = sync.Pool{
 		New: func() interface{} {
-			return make([]types.CountryList, 0, 50)
+			return &types.GetStatusResponse{}
 		},
 	}
 
-	stringBuilderPool = sync.Pool{
+	baseResponsePool = sync.Pool{
 		New: func() interface{} {
-			return &strings.Builder{}
+			return &types.BaseResponse{}
+		},
+	}
+
+	countryListSlicePool = sync.Pool{
+		New: func() interface{} {
+			return make([]types.CountryList, 0, 50)
 		},
 	}
 
@@ -70,6 +95,10 @@ var (
 		dbError            []byte
 		invalidRequest     []byte
 		activationNotFound []byte
+		badStatus          []byte
+		noBalance          []byte
+		lowPrice           []byte
+		tooManyActivations []byte
 		success            []byte
 	}{
 		noNumbers1:         []byte(`{"status":"NO_NUMBERS1"}`),
@@ -78,26 +107,37 @@ var (
 		dbError:            []byte(`{"status":"DATABASE_ERROR"}`),
 		invalidRequest:     []byte(`{"status":"INVALID_REQUEST"}`),
 		activationNotFound: []byte(`{"status":"ACTIVATION_NOT_FOUND"}`),
+		badStatus:          []byte(`{"status":"BAD_STATUS"}`),
+		noBalance:          []byte(`{"status":"NO_BALANCE"}`),
+		lowPrice:           []byte(`{"status":"LOW_PRICE"}`),
+		tooManyActivations: []byte(`{"status":"TOO_MANY_ACTIVATIONS"}`),
 		success:            []byte(`{"status":"SUCCESS"}`),
 	}
 
 	jsonContentType = "application/json; charset=utf-8"
 )
 
+const (
+	defaultWaitSMSTimeout = 5 * time.Second
+	maxWaitSMSTimeout     = config.MaxWaitSMSTimeout
+)
+
 type Handler struct {
-	db     *sql.DB
+	store  storage.Store
 	config config.Config
+	hub    *hub.Hub
 }
 
-func New(db *sql.DB, cfg config.Config) *Handler {
+func New(store storage.Store, cfg config.Config, smsHub *hub.Hub) *Handler {
 	return &Handler{
-		db:     db,
+		store:  store,
 		config: cfg,
+		hub:    smsHub,
 	}
 }
 
 func (h *Handler) HandleGetServices(w http.ResponseWriter) {
-	countryMap, err := database.GetAvailableServices(h.db)
+	countryMap, err := h.store.GetAvailableServices(h.numberUsedSince())
 	if err != nil {
 		h.sendCachedResponse(w, cachedResponses.dbError)
 		return
@@ -115,6 +155,7 @@ func (h *Handler) HandleGetServices(w http.ResponseWriter) {
 	}()
 
 	for country, operators := range countryMap {
+		addAnyOperatorCounts(operators)
 		cl := types.CountryList{
 			Country:     country,
 			OperatorMap: operators,
@@ -134,6 +175,43 @@ func (h *Handler) HandleGetServices(w http.ResponseWriter) {
 	h.SendJSONResponse(w, response)
 }
 
+// addAnyOperatorCounts дополняет счетчики по операторам страны суммой под ключом
+// storage.AnyOperator: запрос с "any" может получить номер любого оператора
+func addAnyOperatorCounts(operators map[string]map[string]int) {
+	total := make(map[string]int, 20)
+	for _, services := range operators {
+		for service, count := range services {
+			total[service] += count
+		}
+	}
+	operators[storage.AnyOperator] = total
+}
+
+func (h *Handler) HandleGetPrices(w http.ResponseWriter) {
+	priceMap, err := h.store.GetPrices()
+	if err != nil {
+		h.sendCachedResponse(w, cachedResponses.dbError)
+		return
+	}
+
+	response := getPricesResponsePool.Get().(*types.GetPricesResponse)
+	defer func() {
+		*response = types.GetPricesResponse{}
+		getPricesResponsePool.Put(response)
+	}()
+
+	response.BaseResponse.Status = "SUCCESS"
+	response.CountryList = make([]types.PriceCountryList, 0, len(priceMap))
+	for country, operators := range priceMap {
+		response.CountryList = append(response.CountryList, types.PriceCountryList{
+			Country:     country,
+			OperatorMap: operators,
+		})
+	}
+
+	h.SendJSONResponse(w, response)
+}
+
 func (h *Handler) HandleGetNumber(w http.ResponseWriter, r *http.Request) {
 	req := getNumberRequestPool.Get().(*types.GetNumberRequest)
 	defer func() {
@@ -141,54 +219,56 @@ func (h *Handler) HandleGetNumber(w http.ResponseWriter, r *http.Request) {
 		getNumberRequestPool.Put(req)
 	}()
 
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Sum < 0 {
 		h.sendCachedResponse(w, cachedResponses.invalidRequest)
 		return
 	}
 
-	phoneNumber, err := database.GetAvailableNumber(h.db, req.Country, req.Operator)
-	if err != nil {
-		h.sendCachedResponse(w, cachedResponses.noNumbers1)
-		return
+	if req.Operator == "" {
+		req.Operator = storage.AnyOperator
 	}
-	defer database.ReturnPhoneNumber(phoneNumber)
-
-	if len(req.ExceptionPhoneSet) > 0 {
-		sb := stringBuilderPool.Get().(*strings.Builder)
-		defer func() {
-			sb.Reset()
-			stringBuilderPool.Put(sb)
-		}()
-
-		sb.WriteString(strconv.FormatUint(phoneNumber.Number, 10))
-		numberStr := sb.String()
 
-		for _, prefix := range req.ExceptionPhoneSet {
-			if strings.HasPrefix(numberStr, prefix) {
-				h.sendCachedResponse(w, cachedResponses.noNumbers2)
-				return
-			}
-		}
-	}
+	accountID := requestAccountID(r)
 
-	service, err := database.GetServiceByCode(h.db, req.Service)
+	service, err := h.store.GetServiceByCode(req.Service)
 	if err != nil {
 		h.sendCachedResponse(w, cachedResponses.invalidService)
 		return
 	}
-	defer database.ReturnService(service)
 
-	activationID, err := database.CreateActivation(h.db, phoneNumber.ID, service.ID, req.Sum)
+	phoneNumber, activationID, err := h.store.ReserveNumber(storage.NumberQuery{
+		Country:           req.Country,
+		Operator:          req.Operator,
+		ServiceID:         service.ID,
+		UsedSince:         h.numberUsedSince(),
+		ExceptionPrefixes: req.ExceptionPhoneSet,
+		MaxActive:         h.maxActiveActivations(r),
+	}, accountID, req.Sum)
 	if err != nil {
-		h.sendCachedResponse(w, cachedResponses.dbError)
+		switch err {
+		case storage.ErrNotFound:
+			h.sendCachedResponse(w, cachedResponses.noNumbers1)
+		case storage.ErrNumberExcluded:
+			h.sendCachedResponse(w, cachedResponses.noNumbers2)
+		case storage.ErrNoBalance:
+			h.sendCachedResponse(w, cachedResponses.noBalance)
+		case storage.ErrPriceTooLow:
+			h.sendCachedResponse(w, cachedResponses.lowPrice)
+		case storage.ErrTooManyActivations:
+			h.sendCachedResponse(w, cachedResponses.tooManyActivations)
+		default:
+			h.sendCachedResponse(w, cachedResponses.dbError)
+		}
 		return
 	}
-
-	go func() {
-		if err := database.SetNumberAvailable(h.db, phoneNumber.ID, false); err != nil {
-			log.Printf("Failed to mark number as unavailable: %v", err)
-		}
-	}()
+	setRequestActivation(r, activationID)
+	h.hub.Publish(hub.Event{
+		Type:         hub.EventActivationCreated,
+		ActivationID: activationID,
+		AccountID:    accountID,
+		Number:       phoneNumber.Number,
+		Service:      service.Code,
+	})
 
 	response := getNumberResponsePool.Get().(*types.GetNumberResponse)
 	defer func() {
@@ -205,6 +285,13 @@ func (h *Handler) HandleGetNumber(w http.ResponseWriter, r *http.Request) {
 	h.SendJSONResponse(w, response)
 }
 
+func (h *Handler) numberUsedSince() time.Time {
+	if h.config.NumberReuseWindow <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-h.config.NumberReuseWindow)
+}
+
 func (h *Handler) HandleFinishActivation(w http.ResponseWriter, r *http.Request) {
 	req := finishActivationRequestPool.Get().(*types.FinishActivationRequest)
 	defer func() {
@@ -217,23 +304,40 @@ func (h *Handler) HandleFinishActivation(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := database.UpdateActivationStatus(h.db, req.ActivationId, req.Status)
-	if err != nil {
-		h.sendCachedResponse(w, cachedResponses.dbError)
+	setRequestActivation(r, req.ActivationId)
+	accountID := requestAccountID(r)
+
+	if !models.IsClientActivationStatus(req.Status) {
+		h.sendCachedResponse(w, cachedResponses.badStatus)
 		return
 	}
 
-	if req.Status == 3 {
-		go func() {
-			if err := database.MakeNumberAvailableByActivation(h.db, req.ActivationId); err != nil {
-				log.Printf("Failed to mark number as available: %v", err)
-			}
-		}()
+	if err := h.store.TransitionActivation(accountID, req.ActivationId, req.Status); err != nil {
+		h.sendTransitionError(w, err)
+		return
 	}
 
+	h.hub.Publish(hub.Event{
+		Type:         hub.EventActivationFinished,
+		ActivationID: req.ActivationId,
+		AccountID:    accountID,
+		Status:       req.Status,
+	})
+
 	h.sendCachedResponse(w, cachedResponses.success)
 }
 
+func (h *Handler) sendTransitionError(w http.ResponseWriter, err error) {
+	switch err {
+	case storage.ErrNotFound:
+		h.sendCachedResponse(w, cachedResponses.activationNotFound)
+	case storage.ErrBadTransition:
+		h.sendCachedResponse(w, cachedResponses.badStatus)
+	default:
+		h.sendCachedResponse(w, cachedResponses.dbError)
+	}
+}
+
 func (h *Handler) HandlePushSMS(w http.ResponseWriter, r *http.Request) {
 	req := pushSMSRequestPool.Get().(*types.PushSMSRequest)
 	defer func() {
@@ -246,19 +350,189 @@ func (h *Handler) HandlePushSMS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exists, err := database.CheckActivationExists(h.db, req.ActivationId)
-	if err != nil || !exists {
+	setRequestActivation(r, req.ActivationId)
+	accountID := requestAccountID(r)
+
+	// Переход и сохранение SMS выполняются одной операцией: SUCCESS означает,
+	// что сообщение уже в хранилище. При ошибке клиент повторяет PUSH_SMS.
+	code, err := h.store.ReceiveSMS(accountID, req.ActivationId, req.SMS)
+	if err != nil {
+		if err != storage.ErrNotFound && err != storage.ErrBadTransition {
+			requestLogger(r).Error("failed to store SMS",
+				slog.Uint64("activation_id", req.ActivationId), slog.Any("error", err))
+		}
+		h.sendTransitionError(w, err)
+		return
+	}
+	metrics.SMSReceived.Inc()
+
+	h.hub.Publish(hub.Event{
+		Type:         hub.EventSMSReceived,
+		ActivationID: req.ActivationId,
+		AccountID:    accountID,
+		SMS:          req.SMS,
+		Code:         code,
+	})
+
+	h.sendCachedResponse(w, cachedResponses.success)
+}
+
+func (h *Handler) HandleGetStatus(w http.ResponseWriter, r *http.Request) {
+	req := getStatusRequestPool.Get().(*types.GetStatusRequest)
+	defer func() {
+		*req = types.GetStatusRequest{}
+		getStatusRequestPool.Put(req)
+	}()
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		h.sendCachedResponse(w, cachedResponses.invalidRequest)
+		return
+	}
+
+	setRequestActivation(r, req.ActivationId)
+	h.sendActivationStatus(w, requestAccountID(r), req.ActivationId)
+}
+
+func (h *Handler) HandleWaitSMS(w http.ResponseWriter, r *http.Request) {
+	req := waitSMSRequestPool.Get().(*types.WaitSMSRequest)
+	defer func() {
+		*req = types.WaitSMSRequest{}
+		waitSMSRequestPool.Put(req)
+	}()
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		h.sendCachedResponse(w, cachedResponses.invalidRequest)
+		return
+	}
+
+	setRequestActivation(r, req.ActivationId)
+	accountID := requestAccountID(r)
+
+	// Подписка до чтения статуса: SMS, пришедшая между ними, не теряется
+	notify, unsubscribe := h.hub.Subscribe(req.ActivationId)
+	defer unsubscribe()
+
+	activation, err := h.store.GetActivation(req.ActivationId)
+	if err != nil && err != storage.ErrNotFound {
+		h.sendCachedResponse(w, cachedResponses.dbError)
+		return
+	}
+	if err != nil || activation.AccountID == nil || *activation.AccountID != accountID {
 		h.sendCachedResponse(w, cachedResponses.activationNotFound)
 		return
 	}
 
-	go func() {
-		if err := database.StoreSMS(h.db, req.ActivationId, req.SMS); err != nil {
-			log.Printf("Failed to store SMS: %v", err)
+	// После RETRY_REQUESTED старые SMS уже получены клиентом, ждем следующую
+	if models.IsAwaitingSMS(activation.Status) {
+		timer := time.NewTimer(waitSMSTimeout(req.Timeout))
+		defer timer.Stop()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	h.sendActivationStatus(w, accountID, req.ActivationId)
+}
+
+func waitSMSTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultWaitSMSTimeout
+	}
+
+	timeout := time.Duration(seconds) * time.Second
+	if timeout > maxWaitSMSTimeout {
+		return maxWaitSMSTimeout
+	}
+	return timeout
+}
+
+func (h *Handler) sendActivationStatus(w http.ResponseWriter, accountID int64, activationID uint64) {
+	activation, err := h.store.GetActivation(activationID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			h.sendCachedResponse(w, cachedResponses.activationNotFound)
+			return
 		}
+		h.sendCachedResponse(w, cachedResponses.dbError)
+		return
+	}
+	if activation.AccountID == nil || *activation.AccountID != accountID {
+		h.sendCachedResponse(w, cachedResponses.activationNotFound)
+		return
+	}
+
+	number, serviceCode, err := h.store.GetActivationTarget(activationID)
+	if err != nil {
+		h.sendCachedResponse(w, cachedResponses.dbError)
+		return
+	}
+
+	messages, err := h.store.GetSMSByActivation(activationID)
+	if err != nil {
+		h.sendCachedResponse(w, cachedResponses.dbError)
+		return
+	}
+
+	response := getStatusResponsePool.Get().(*types.GetStatusResponse)
+	defer func() {
+		*response = types.GetStatusResponse{}
+		getStatusResponsePool.Put(response)
 	}()
 
-	h.sendCachedResponse(w, cachedResponses.success)
+	response.BaseResponse.Status = "SUCCESS"
+	response.ActivationId = activation.ID
+	response.ActivationStatus = activation.Status
+	response.Number = number
+	response.Service = serviceCode
+	response.SMS = make([]string, 0, len(messages))
+	for _, sms := range messages {
+		response.SMS = append(response.SMS, sms.Text)
+		if sms.Code != "" {
+			response.Code = sms.Code
+		}
+	}
+
+	h.SendJSONResponse(w, response)
+}
+
+func (h *Handler) HandleGetBalance(w http.ResponseWriter, r *http.Request) {
+	account, err := h.store.GetAccount(requestAccountID(r))
+	if err != nil {
+		h.sendCachedResponse(w, cachedResponses.dbError)
+		return
+	}
+
+	response := getBalanceResponsePool.Get().(*types.GetBalanceResponse)
+	defer func() {
+		*response = types.GetBalanceResponse{}
+		getBalanceResponsePool.Put(response)
+	}()
+
+	response.BaseResponse.Status = "SUCCESS"
+	response.Balance = account.Balance
+	response.Held = account.Held
+
+	h.SendJSONResponse(w, response)
+}
+
+func requestAccountID(r *http.Request) int64 {
+	if apiKey := APIKeyFromContext(r.Context()); apiKey != nil {
+		return apiKey.AccountID
+	}
+	return 0
+}
+
+// maxActiveActivations возвращает лимит активных активаций ключа запроса или
+// значение из конфигурации
+func (h *Handler) maxActiveActivations(r *http.Request) int {
+	if apiKey := APIKeyFromContext(r.Context()); apiKey != nil && apiKey.MaxActiveActivations != nil {
+		return *apiKey.MaxActiveActivations
+	}
+	return h.config.MaxActiveActivations
 }
 
 func (h *Handler) sendCachedResponse(w http.ResponseWriter, response []byte) {
@@ -287,6 +561,15 @@ func (h *Handler) SendErrorResponse(w http.ResponseWriter, status, message strin
 	case "ACTIVATION_NOT_FOUND":
 		h.sendCachedResponse(w, cachedResponses.activationNotFound)
 		return
+	case "BAD_STATUS":
+		h.sendCachedResponse(w, cachedResponses.badStatus)
+		return
+	case "NO_BALANCE":
+		h.sendCachedResponse(w, cachedResponses.noBalance)
+		return
+	case "LOW_PRICE":
+		h.sendCachedResponse(w, cachedResponses.lowPrice)
+		return
 	}
 
 	response := baseResponsePool.Get().(*types.BaseResponse)
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sms-api-service/config"
+	"sms-api-service/hub"
+	"sms-api-service/memory"
+	"sms-api-service/models"
+	"sms-api-service/storage"
+	"sms-api-service/storage/storagetest"
+	"sms-api-service/types"
+)
+
+type testEnv struct {
+	handler *Handler
+	store   storage.Backend
+	apiKey  *models.APIKey
+}
+
+// newTestEnv создает обработчик поверх хранилища в памяти с данными
+// storagetest.Fixture, номерами операторов mts, mts и beeline и ценой tg 10
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	f := storagetest.NewFixture(t, func(*testing.T) storage.Backend { return memory.New() }, "mts", "mts", "beeline")
+	f.SetPrices(t, storage.Price{Country: "rus", Operator: "any", Service: "tg", Price: 10})
+
+	return &testEnv{
+		handler: New(f.Store, config.Default(), hub.New()),
+		store:   f.Store,
+		apiKey:  &f.APIKey,
+	}
+}
+
+// serve выполняет обработчик с телом body от имени ключа окружения
+func (e *testEnv) serve(handle func(http.ResponseWriter, *http.Request), body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r = r.WithContext(WithAPIKey(r.Context(), e.apiKey))
+	w := httptest.NewRecorder()
+
+	handle(w, r)
+	return w
+}
+
+// call выполняет обработчик и разбирает JSON-ответ в out
+func (e *testEnv) call(t *testing.T, handle func(http.ResponseWriter, *http.Request), body string, out interface{}) {
+	t.Helper()
+
+	w := e.serve(handle, body)
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+}
+
+func (e *testEnv) getNumber(t *testing.T, operator string) types.GetNumberResponse {
+	t.Helper()
+
+	var resp types.GetNumberResponse
+	e.call(t, e.handler.HandleGetNumber,
+		`{"country":"rus","operator":"`+operator+`","service":"tg","sum":10}`, &resp)
+	return resp
+}
+
+func TestGetServicesCountsPerOperator(t *testing.T) {
+	env := newTestEnv(t)
+
+	var resp types.GetServicesResponse
+	env.call(t, func(w http.ResponseWriter, r *http.Request) { env.handler.HandleGetServices(w) }, `{}`, &resp)
+
+	if resp.Status != "SUCCESS" || len(resp.CountryList) != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	operators := resp.CountryList[0].OperatorMap
+	want := map[string]int{"mts": 2, "beeline": 1, "any": 3}
+	for operator, count := range want {
+		if got := operators[operator]["tg"]; got != count {
+			t.Errorf("operator %s: tg count = %d, want %d", operator, got, count)
+		}
+	}
+}
+
+func TestGetNumberOperatorSelection(t *testing.T) {
+	env := newTestEnv(t)
+
+	resp := env.getNumber(t, "beeline")
+	if resp.Status != "SUCCESS" || resp.Number != 79000000003 {
+		t.Fatalf("beeline: got %+v, want number 79000000003", resp)
+	}
+
+	if resp := env.getNumber(t, "tele2"); resp.Status != "NO_NUMBERS1" {
+		t.Errorf("tele2: status = %s, want NO_NUMBERS1", resp.Status)
+	}
+
+	for _, operator := range []string{"any", ""} {
+		resp := env.getNumber(t, operator)
+		if resp.Status != "SUCCESS" || resp.Number == 79000000003 {
+			t.Errorf("operator %q: got %+v, want one of the remaining mts numbers", operator, resp)
+		}
+	}
+}
+
+func TestGetStatusReturnsExtractedCode(t *testing.T) {
+	env := newTestEnv(t)
+	number := env.getNumber(t, "any")
+
+	var base types.BaseResponse
+	env.call(t, env.handler.HandlePushSMS,
+		`{"activationId":1,"sms":"Telegram code: 52817. Do not give this code to anyone"}`, &base)
+	if base.Status != "SUCCESS" {
+		t.Fatalf("PUSH_SMS status = %s", base.Status)
+	}
+
+	var status types.GetStatusResponse
+	env.call(t, env.handler.HandleGetStatus, `{"activationId":1}`, &status)
+
+	if status.ActivationStatus != models.ActivationCodeReceived || status.Code != "52817" ||
+		status.Number != number.Number || status.Service != "tg" || len(status.SMS) != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestWaitSMSWakesOnPush(t *testing.T) {
+	env := newTestEnv(t)
+	env.getNumber(t, "any")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- env.serve(env.handler.HandleWaitSMS, `{"activationId":1,"timeout":5}`)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	var base types.BaseResponse
+	env.call(t, env.handler.HandlePushSMS, `{"activationId":1,"sms":"Your code: 1234"}`, &base)
+
+	select {
+	case w := <-done:
+		var status types.GetStatusResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("decode %q: %v", w.Body.String(), err)
+		}
+		if len(status.SMS) != 1 || status.Code != "1234" {
+			t.Errorf("unexpected status %+v", status)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("WAIT_SMS did not return after PUSH_SMS")
+	}
+}
+
+func TestActivationsOfOtherAccountsAreHidden(t *testing.T) {
+	env := newTestEnv(t)
+	env.getNumber(t, "any")
+
+	env.apiKey = &models.APIKey{ID: 2, AccountID: env.apiKey.AccountID + 1, Enabled: true}
+
+	var base types.BaseResponse
+	env.call(t, env.handler.HandlePushSMS, `{"activationId":1,"sms":"Your code: 1234"}`, &base)
+	if base.Status != "ACTIVATION_NOT_FOUND" {
+		t.Errorf("PUSH_SMS from another account: status = %s", base.Status)
+	}
+
+	env.call(t, env.handler.HandleGetStatus, `{"activationId":1}`, &base)
+	if base.Status != "ACTIVATION_NOT_FOUND" {
+		t.Errorf("GET_STATUS from another account: status = %s", base.Status)
+	}
+}
+
+func TestFinishActivationAcceptsOnlyClientStatuses(t *testing.T) {
+	env := newTestEnv(t)
+	env.getNumber(t, "any")
+
+	for _, status := range []int{
+		models.ActivationWaiting,
+		models.ActivationCodeReceived,
+		models.ActivationExpired,
+		42,
+	} {
+		var base types.BaseResponse
+		env.call(t, env.handler.HandleFinishActivation, fmt.Sprintf(`{"activationId":1,"status":%d}`, status), &base)
+		if base.Status != "BAD_STATUS" {
+			t.Errorf("status %d: response %s, want BAD_STATUS", status, base.Status)
+		}
+	}
+
+	activation, err := env.store.GetActivation(1)
+	if err != nil {
+		t.Fatalf("get activation: %v", err)
+	}
+	if activation.Status != models.ActivationWaiting {
+		t.Errorf("activation status = %d, want it unchanged", activation.Status)
+	}
+
+	var base types.BaseResponse
+	env.call(t, env.handler.HandleFinishActivation, `{"activationId":1,"status":4}`, &base)
+	if base.Status != "SUCCESS" {
+		t.Errorf("cancel: response %s, want SUCCESS", base.Status)
+	}
+}
+
+func TestWaitSMSAfterRetryWaitsForNewSMS(t *testing.T) {
+	env := newTestEnv(t)
+	env.getNumber(t, "any")
+
+	var base types.BaseResponse
+	env.call(t, env.handler.HandlePushSMS, `{"activationId":1,"sms":"Your code: 1111"}`, &base)
+	env.call(t, env.handler.HandleFinishActivation, `{"activationId":1,"status":2}`, &base)
+	if base.Status != "SUCCESS" {
+		t.Fatalf("retry: response %s", base.Status)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- env.serve(env.handler.HandleWaitSMS, `{"activationId":1,"timeout":5}`)
+	}()
+
+	select {
+	case w := <-done:
+		t.Fatalf("WAIT_SMS returned before a new SMS: %s", w.Body.String())
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	env.call(t, env.handler.HandlePushSMS, `{"activationId":1,"sms":"Your code: 2222"}`, &base)
+
+	select {
+	case w := <-done:
+		var status types.GetStatusResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("decode %q: %v", w.Body.String(), err)
+		}
+		if status.ActivationStatus != models.ActivationCodeReceived || status.Code != "2222" || len(status.SMS) != 2 {
+			t.Errorf("unexpected status %+v", status)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("WAIT_SMS did not return after the second PUSH_SMS")
+	}
+}
+
+func TestPushSMSToFinishedActivationIsNotStored(t *testing.T) {
+	env := newTestEnv(t)
+	env.getNumber(t, "any")
+
+	var base types.BaseResponse
+	env.call(t, env.handler.HandleFinishActivation, `{"activationId":1,"status":4}`, &base)
+	env.call(t, env.handler.HandlePushSMS, `{"activationId":1,"sms":"Your code: 1234"}`, &base)
+	if base.Status != "BAD_STATUS" {
+		t.Errorf("PUSH_SMS after cancel: status = %s, want BAD_STATUS", base.Status)
+	}
+
+	messages, err := env.store.GetSMSByActivation(1)
+	if err != nil {
+		t.Fatalf("get sms: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("stored %d messages for a cancelled activation", len(messages))
+	}
+}
+
+func TestGetNumberEnforcesActiveLimitConcurrently(t *testing.T) {
+	env := newTestEnv(t)
+	limit := 1
+	env.apiKey.MaxActiveActivations = &limit
+
+	const workers = 8
+	results := make(chan *httptest.ResponseRecorder, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			results <- env.serve(env.handler.HandleGetNumber, `{"country":"rus","operator":"any","service":"tg","sum":10}`)
+		}()
+	}
+
+	statuses := make(map[string]int)
+	for i := 0; i < workers; i++ {
+		var base types.BaseResponse
+		w := <-results
+		if err := json.Unmarshal(w.Body.Bytes(), &base); err != nil {
+			t.Fatalf("decode %q: %v", w.Body.String(), err)
+		}
+		statuses[base.Status]++
+	}
+
+	if statuses["SUCCESS"] != 1 || statuses["TOO_MANY_ACTIVATIONS"] != workers-1 {
+		t.Errorf("statuses = %v, want one SUCCESS and the rest TOO_MANY_ACTIVATIONS", statuses)
+	}
+}
+
+func TestGetNumberChecksPriceOfSelectedOperator(t *testing.T) {
+	env := newTestEnv(t)
+	err := env.store.Seed(context.Background(), &storage.SeedData{
+		Prices: []storage.Price{{Country: "rus", Operator: "mts", Service: "tg", Price: 50}},
+	})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	if resp := env.getNumber(t, "mts"); resp.Status != "LOW_PRICE" {
+		t.Errorf("mts: status = %s, want LOW_PRICE", resp.Status)
+	}
+
+	resp := env.getNumber(t, "any")
+	if resp.Status != "SUCCESS" || resp.Number != 79000000003 {
+		t.Fatalf("any: got %+v, want the beeline number 79000000003", resp)
+	}
+
+	if resp := env.getNumber(t, "any"); resp.Status != "LOW_PRICE" {
+		t.Errorf("any with only mts left: status = %s, want LOW_PRICE", resp.Status)
+	}
+}
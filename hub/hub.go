@@ -0,0 +1,164 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	EventActivationCreated  = "activation_created"
+	EventSMSReceived        = "sms_received"
+	EventActivationFinished = "activation_finished"
+)
+
+// eventBufferSize ограничивает очередь событий одного подписчика потока
+const eventBufferSize = 64
+
+// Event описывает изменение активации
+type Event struct {
+	Type         string    `json:"type"`
+	ActivationID uint64    `json:"activationId"`
+	AccountID    int64     `json:"-"`
+	Number       uint64    `json:"number,omitempty"`
+	Service      string    `json:"service,omitempty"`
+	Status       int       `json:"status,omitempty"`
+	SMS          string    `json:"sms,omitempty"`
+	Code         string    `json:"code,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// Hub рассылает события активаций: будит запросы WAIT_SMS и наполняет потоки /events
+type Hub struct {
+	mu      sync.Mutex
+	waiters map[uint64]map[chan struct{}]struct{}
+	streams map[chan Event]struct{}
+	closed  bool
+}
+
+// New создает пустой хаб
+func New() *Hub {
+	return &Hub{
+		waiters: make(map[uint64]map[chan struct{}]struct{}),
+		streams: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe регистрирует ожидающего для активации. Канал получает сигнал при
+// новом SMS и закрывается при остановке хаба. Возвращаемая функция снимает подписку.
+func (h *Hub) Subscribe(activationID uint64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	subscribers := h.waiters[activationID]
+	if subscribers == nil {
+		subscribers = make(map[chan struct{}]struct{})
+		h.waiters[activationID] = subscribers
+	}
+	subscribers[ch] = struct{}{}
+
+	return ch, func() { h.unsubscribe(activationID, ch) }
+}
+
+// unsubscribe удаляет ожидающего и освобождает пустые записи
+func (h *Hub) unsubscribe(activationID uint64, ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subscribers, exists := h.waiters[activationID]
+	if !exists {
+		return
+	}
+
+	delete(subscribers, ch)
+	if len(subscribers) == 0 {
+		delete(h.waiters, activationID)
+	}
+}
+
+// SubscribeEvents регистрирует поток всех событий. Канал закрывается при
+// остановке хаба; медленный подписчик теряет события, а не блокирует публикацию.
+func (h *Hub) SubscribeEvents() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	h.streams[ch] = struct{}{}
+
+	return ch, func() { h.unsubscribeEvents(ch) }
+}
+
+// unsubscribeEvents удаляет поток событий
+func (h *Hub) unsubscribeEvents(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.streams, ch)
+}
+
+// Publish отправляет событие во все потоки и будит ожидающих SMS по активации
+func (h *Hub) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	for ch := range h.streams {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	if event.Type != EventSMSReceived {
+		return
+	}
+
+	for ch := range h.waiters[event.ActivationID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close закрывает каналы всех подписчиков и отклоняет новые подписки
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for activationID, subscribers := range h.waiters {
+		for ch := range subscribers {
+			close(ch)
+		}
+		delete(h.waiters, activationID)
+	}
+
+	for ch := range h.streams {
+		close(ch)
+		delete(h.streams, ch)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"sms-api-service/importer"
+	"sms-api-service/storage"
+)
+
+const importUsage = `usage:
+  import-numbers <file.csv|->   columns: number,country,operator[,tags]`
+
+// runImportCommand загружает номера из CSV файла (или stdin для "-") и печатает отчет
+func runImportCommand(store storage.Backend, args []string) error {
+	if len(args) != 1 {
+		return errors.New(importUsage)
+	}
+
+	var input io.Reader = os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	report, err := importer.Import(store, input)
+	for _, rowErr := range report.Errors {
+		fmt.Fprintf(os.Stderr, "line %d: %s\n", rowErr.Line, rowErr.Reason)
+	}
+	fmt.Printf("inserted=%d duplicates=%d invalid=%d\n", report.Inserted, report.Duplicates, report.Invalid)
+	return err
+}
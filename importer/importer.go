@@ -0,0 +1,167 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+const (
+	// BatchSize - число номеров, вставляемых в одной транзакции
+	BatchSize = 100
+
+	maxReportedErrors = 100
+	maxNumberDigits   = 15
+)
+
+// RowError описывает отклоненную строку файла
+type RowError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// Report - итог импорта. Errors содержит не больше maxReportedErrors строк,
+// Invalid учитывает все отклоненные.
+type Report struct {
+	Inserted   int        `json:"inserted"`
+	Duplicates int        `json:"duplicates"`
+	Invalid    int        `json:"invalid"`
+	Errors     []RowError `json:"errors,omitempty"`
+}
+
+func (r *Report) reject(line int, reason string) {
+	r.Invalid++
+	if len(r.Errors) < maxReportedErrors {
+		r.Errors = append(r.Errors, RowError{Line: line, Reason: reason})
+	}
+}
+
+// Import читает CSV выгрузку SIM-банка со столбцами number, country, operator
+// и необязательным tags и добавляет номера в пул батчами по BatchSize.
+// Строка заголовка, начинающаяся с "number", пропускается. Номер должен
+// начинаться с префикса страны, оператор обязателен и не может быть "any".
+// При ошибке хранилища возвращается отчет по уже обработанным батчам.
+func Import(store storage.Admin, r io.Reader) (Report, error) {
+	var report Report
+
+	countries, err := store.ListCountries()
+	if err != nil {
+		return report, fmt.Errorf("failed to load countries: %w", err)
+	}
+	countryByCode := make(map[string]models.Country, len(countries))
+	for _, country := range countries {
+		countryByCode[country.Code] = country
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+	reader.Comment = '#'
+
+	seen := make(map[uint64]struct{})
+	batch := make([]models.PhoneNumber, 0, BatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		inserted, err := store.ImportNumbers(batch)
+		if err != nil {
+			return err
+		}
+		report.Inserted += inserted
+		report.Duplicates += len(batch) - inserted
+		batch = batch[:0]
+		return nil
+	}
+
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.reject(parseErr.StartLine, parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "number") {
+			continue
+		}
+
+		number, err := parseRow(record, countryByCode)
+		if err != nil {
+			report.reject(line, err.Error())
+			continue
+		}
+
+		if _, ok := seen[number.Number]; ok {
+			report.Duplicates++
+			continue
+		}
+		seen[number.Number] = struct{}{}
+
+		batch = append(batch, number)
+		if len(batch) == BatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, flush()
+}
+
+// parseRow разбирает строку CSV и проверяет номер по префиксу страны
+func parseRow(record []string, countries map[string]models.Country) (models.PhoneNumber, error) {
+	if len(record) < 3 || len(record) > 4 {
+		return models.PhoneNumber{}, fmt.Errorf("expected 3 or 4 columns, got %d", len(record))
+	}
+
+	digits := strings.TrimPrefix(strings.TrimSpace(record[0]), "+")
+	value, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || value == 0 || len(digits) > maxNumberDigits {
+		return models.PhoneNumber{}, fmt.Errorf("invalid number %q", record[0])
+	}
+
+	code := strings.ToLower(strings.TrimSpace(record[1]))
+	country, ok := countries[code]
+	if !ok {
+		return models.PhoneNumber{}, fmt.Errorf("unknown country %q", record[1])
+	}
+	if !country.MatchesPrefix(value) {
+		return models.PhoneNumber{}, fmt.Errorf("number %d does not match prefix %d of country %s",
+			value, country.Prefix, country.Code)
+	}
+
+	operator, err := storage.NormalizeOperator(record[2])
+	if err != nil {
+		return models.PhoneNumber{}, err
+	}
+
+	var tags string
+	if len(record) == 4 {
+		tags = strings.TrimSpace(record[3])
+	}
+
+	return models.PhoneNumber{
+		Number:    value,
+		CountryID: country.ID,
+		Operator:  operator,
+		Available: true,
+		Tags:      tags,
+	}, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"sms-api-service/storage"
+)
+
+const keysUsage = `usage:
+  keys list
+  keys create <account> <name> [ttl]
+  keys enable <id>
+  keys disable <id>
+  keys expire <id> <ttl|never>
+  keys limits <id> <rate|default> <burst|default> <max-active|default>`
+
+// runKeysCommand управляет API-ключами клиентов из командной строки
+func runKeysCommand(store storage.Backend, args []string) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		keys, err := store.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, key := range keys {
+			expires := "never"
+			if key.ExpiresAt != nil {
+				expires = key.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d\taccount=%d\tname=%s\tactive=%t\texpires=%s\n",
+				key.ID, key.AccountID, key.Name, key.Active(now), expires)
+		}
+		return nil
+
+	case "create":
+		if len(args) < 3 {
+			return errors.New(keysUsage)
+		}
+		expiresAt, err := parseKeyTTL(args[3:])
+		if err != nil {
+			return err
+		}
+		accountID, err := store.EnsureAccount(args[1])
+		if err != nil {
+			return err
+		}
+		key, apiKey, err := store.CreateAPIKey(accountID, args[2], expiresAt)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "id=%d key=%s\n", apiKey.ID, key)
+		return nil
+
+	case "enable", "disable":
+		if len(args) < 2 {
+			return errors.New(keysUsage)
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q: %w", args[1], err)
+		}
+		return store.SetAPIKeyEnabled(id, args[0] == "enable")
+
+	case "expire":
+		if len(args) < 3 {
+			return errors.New(keysUsage)
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q: %w", args[1], err)
+		}
+		expiresAt, err := parseKeyTTL(args[2:])
+		if err != nil {
+			return err
+		}
+		return store.SetAPIKeyExpiry(id, expiresAt)
+
+	case "limits":
+		if len(args) < 5 {
+			return errors.New(keysUsage)
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q: %w", args[1], err)
+		}
+		var rate *float64
+		if args[2] != "default" {
+			value, err := strconv.ParseFloat(args[2], 64)
+			if err != nil {
+				return fmt.Errorf("invalid rate %q: %w", args[2], err)
+			}
+			rate = &value
+		}
+		burst, err := parseKeyLimit(args[3])
+		if err != nil {
+			return err
+		}
+		maxActive, err := parseKeyLimit(args[4])
+		if err != nil {
+			return err
+		}
+		return store.SetAPIKeyLimits(id, rate, burst, maxActive)
+
+	default:
+		return errors.New(keysUsage)
+	}
+}
+
+// parseKeyLimit разбирает целочисленный лимит; "default" - значение из конфигурации
+func parseKeyLimit(arg string) (*int, error) {
+	if arg == "default" {
+		return nil, nil
+	}
+
+	value, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid limit %q: %w", arg, err)
+	}
+	return &value, nil
+}
+
+// parseKeyTTL превращает срок действия вида "720h" в момент истечения; "never" - бессрочно
+func parseKeyTTL(args []string) (*time.Time, error) {
+	if len(args) == 0 || args[0] == "never" {
+		return nil, nil
+	}
+
+	ttl, err := time.ParseDuration(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ttl %q: %w", args[0], err)
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	return &expiresAt, nil
+}
@@ -3,53 +3,130 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"sms-api-service/admin"
 	"sms-api-service/config"
 	"sms-api-service/database"
+	"sms-api-service/hub"
+	"sms-api-service/memory"
+	"sms-api-service/metrics"
+	"sms-api-service/postgres"
+	"sms-api-service/reaper"
 	"sms-api-service/server"
+	"sms-api-service/storage"
 )
 
 func main() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	cfg := config.Load()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal("Failed to load configuration:", err)
+	}
 
-	dbConfig := database.DefaultConfig(cfg.DBPath)
-	db, err := database.Init(dbConfig)
+	store, err := openStore(cfg)
 	if err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		log.Fatal("Failed to initialize storage:", err)
 	}
 	defer func() {
-		if err := db.Close(); err != nil {
-			log.Printf("Error closing database: %v", err)
+		if err := store.Close(); err != nil {
+			log.Printf("Error closing storage: %v", err)
 		}
 	}()
 
-	seedData := database.DefaultSeedData()
-	if err := db.Seed(ctx, seedData); err != nil {
-		log.Fatal("Failed to seed data:", err)
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(ctx, store, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := prepareSchema(ctx, store, cfg.AutoMigrate); err != nil {
+		log.Fatal("Failed to prepare database schema:", err)
+	}
+
+	if len(args) > 0 && args[0] == "keys" {
+		if err := runKeysCommand(store, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	srv := server.New(db.DB, cfg)
+	if len(args) > 0 && args[0] == "import-numbers" {
+		if err := runImportCommand(store, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if cfg.Seed.Enabled {
+		seedData := storage.DefaultSeedData()
+		seedData.NumbersRange.Min = cfg.Seed.NumbersMin
+		seedData.NumbersRange.Max = cfg.Seed.NumbersMax
+		if cfg.Seed.DemoKey {
+			log.Print("Seeding the demo API key qwerty123; do not enable seed.demo_key in production")
+			seedData.AddDemoKey()
+		}
+		if err := store.Seed(ctx, seedData); err != nil {
+			log.Fatal("Failed to seed data:", err)
+		}
+	}
+
+	// Команда keys работает с отдельным процессом и не видит данные в памяти,
+	// поэтому без демо-ключа такой сервис отвечал бы INVALID_KEY на все запросы
+	if cfg.Storage == "memory" {
+		keys, err := store.ListAPIKeys()
+		if err != nil {
+			log.Fatal("Failed to list API keys:", err)
+		}
+		if len(keys) == 0 {
+			log.Fatal("Memory storage has no API keys and the keys command cannot add them to a running process; start with -seed-demo-key")
+		}
+	}
+
+	smsHub := hub.New()
+
+	reaperCtx, stopReaper := context.WithCancel(ctx)
+	reaperDone := make(chan struct{})
+	go func() {
+		defer close(reaperDone)
+		reaper.New(store, smsHub, cfg.ActivationTTL, cfg.ReaperInterval).Run(reaperCtx)
+	}()
+
+	srv := server.New(store, cfg, smsHub)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/GrizzlySMSbyDima.php", srv.HandleAPIRequest)
 
+	mux.HandleFunc("/events", srv.HandleEvents)
+
 	mux.HandleFunc("/health", handleHealthCheck)
 
+	mux.Handle("/metrics", metrics.Handler(metrics.StoreCollectors(store, cfg.NumberReuseWindow)...))
+
+	if cfg.AdminToken != "" {
+		mux.Handle("/admin/", admin.New(store, smsHub, cfg.AdminToken))
+	} else {
+		log.Print("Admin API is disabled: admin_token is not set")
+	}
+
 	httpServer := &http.Server{
 		Addr:         ":" + cfg.Port,
 		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
+	httpServer.RegisterOnShutdown(smsHub.Close)
 
 	go func() {
 		log.Printf("SMS API Service starting on port %s", cfg.Port)
@@ -58,7 +135,37 @@ func main() {
 		}
 	}()
 
-	waitForShutdown(ctx, httpServer)
+	waitForShutdown(httpServer, cfg.ShutdownTimeout)
+
+	stopReaper()
+	<-reaperDone
+}
+
+// openStore подключает хранилище, выбранное в конфигурации
+func openStore(cfg config.Config) (storage.Backend, error) {
+	switch cfg.Storage {
+	case "memory":
+		return memory.New(), nil
+	case "postgres":
+		return postgres.Open(postgres.Config{
+			DSN:             cfg.PostgresDSN,
+			MaxOpenConns:    cfg.Database.MaxOpenConns,
+			MaxIdleConns:    cfg.Database.MaxIdleConns,
+			ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		})
+	default:
+		return database.Init(&database.DatabaseConfig{
+			Path:            cfg.DBPath,
+			JournalMode:     cfg.Database.JournalMode,
+			Timeout:         cfg.Database.Timeout,
+			Synchronous:     cfg.Database.Synchronous,
+			CacheSize:       cfg.Database.CacheSize,
+			BusyTimeout:     cfg.Database.BusyTimeout,
+			MaxOpenConns:    cfg.Database.MaxOpenConns,
+			MaxIdleConns:    cfg.Database.MaxIdleConns,
+			ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		})
+	}
 }
 
 func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
@@ -67,14 +174,14 @@ func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"ok","service":"sms-api"}`))
 }
 
-func waitForShutdown(ctx context.Context, server *http.Server) {
+func waitForShutdown(server *http.Server, timeout time.Duration) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	<-quit
 	log.Println("Shutting down server...")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
@@ -0,0 +1,233 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+func (s *Store) GetAccount(accountID int64) (models.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[accountID]
+	if !ok {
+		return models.Account{}, storage.ErrNotFound
+	}
+	return *account, nil
+}
+
+func (s *Store) EnsureAccount(name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, _ := s.ensureAccount(name)
+	return account.ID, nil
+}
+
+// ensureAccount возвращает счет и признак того, что он только что создан
+func (s *Store) ensureAccount(name string) (*models.Account, bool) {
+	if id, ok := s.accountByName[name]; ok {
+		return s.accounts[id], false
+	}
+
+	s.nextAccountID++
+	account := &models.Account{ID: s.nextAccountID, Name: name, CreatedAt: time.Now()}
+	s.accounts[account.ID] = account
+	s.accountByName[name] = account.ID
+	return account, true
+}
+
+// settleActivation списывает (capture) или возвращает (refund) заблокированную сумму активации
+func (s *Store) settleActivation(activation *models.Activation, kind string) {
+	if activation.AccountID == nil {
+		return
+	}
+	account, ok := s.accounts[*activation.AccountID]
+	if !ok {
+		return
+	}
+
+	account.Held -= activation.Sum
+	if kind == storage.LedgerRefund {
+		account.Balance += activation.Sum
+	}
+
+	activationID := activation.ID
+	s.appendLedger(account, &activationID, kind, activation.Sum)
+}
+
+// appendLedger записывает операцию в журнал с остатками счета после нее
+func (s *Store) appendLedger(account *models.Account, activationID *uint64, kind string, amount float64) {
+	s.nextLedgerID++
+	s.ledger = append(s.ledger, models.LedgerEntry{
+		ID:           s.nextLedgerID,
+		AccountID:    account.ID,
+		ActivationID: activationID,
+		Kind:         kind,
+		Amount:       amount,
+		BalanceAfter: account.Balance,
+		HeldAfter:    account.Held,
+		CreatedAt:    time.Now(),
+	})
+}
+
+func (s *Store) GetPrices() (map[string]map[string]map[string]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	countryMap := make(map[string]map[string]map[string]float64, len(s.countries))
+	for key, price := range s.prices {
+		if countryMap[key.country] == nil {
+			countryMap[key.country] = make(map[string]map[string]float64, 10)
+		}
+		if countryMap[key.country][key.operator] == nil {
+			countryMap[key.country][key.operator] = make(map[string]float64, 20)
+		}
+		countryMap[key.country][key.operator][key.service] = price
+	}
+
+	return countryMap, nil
+}
+
+// price возвращает цену оператора, а при ее отсутствии - цену для any;
+// вызывается под s.mu
+func (s *Store) price(country, operator, service string) (float64, bool) {
+	price, ok := s.prices[priceKey{country: country, operator: operator, service: service}]
+	if !ok {
+		price, ok = s.prices[priceKey{country: country, operator: storage.AnyOperator, service: service}]
+	}
+	return price, ok
+}
+
+func (s *Store) AuthenticateAPIKey(key string, now time.Time) (models.APIKey, error) {
+	if key == "" {
+		return models.APIKey{}, storage.ErrInvalidKey
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.apiKeyByHash[storage.HashAPIKey(key)]
+	if !ok {
+		return models.APIKey{}, storage.ErrInvalidKey
+	}
+
+	apiKey := s.apiKeys[id]
+	if !apiKey.Active(now) {
+		return models.APIKey{}, storage.ErrInvalidKey
+	}
+	return cloneAPIKey(apiKey), nil
+}
+
+// cloneAPIKey копирует ключ вместе с полями-указателями
+func cloneAPIKey(apiKey *models.APIKey) models.APIKey {
+	clone := *apiKey
+	if apiKey.ExpiresAt != nil {
+		expiresAt := *apiKey.ExpiresAt
+		clone.ExpiresAt = &expiresAt
+	}
+	if apiKey.RateLimit != nil {
+		rateLimit := *apiKey.RateLimit
+		clone.RateLimit = &rateLimit
+	}
+	if apiKey.RateBurst != nil {
+		rateBurst := *apiKey.RateBurst
+		clone.RateBurst = &rateBurst
+	}
+	if apiKey.MaxActiveActivations != nil {
+		maxActive := *apiKey.MaxActiveActivations
+		clone.MaxActiveActivations = &maxActive
+	}
+	return clone
+}
+
+func (s *Store) CreateAPIKey(accountID int64, name string, expiresAt *time.Time) (string, models.APIKey, error) {
+	key, err := storage.NewAPIKey()
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[accountID]; !ok {
+		return "", models.APIKey{}, storage.ErrNotFound
+	}
+
+	apiKey := s.insertAPIKey(accountID, storage.HashAPIKey(key), name)
+	if expiresAt != nil {
+		t := time.Unix(expiresAt.Unix(), 0)
+		apiKey.ExpiresAt = &t
+	}
+	return key, cloneAPIKey(apiKey), nil
+}
+
+func (s *Store) insertAPIKey(accountID int64, hash, name string) *models.APIKey {
+	s.nextAPIKeyID++
+	apiKey := &models.APIKey{
+		ID:        s.nextAPIKeyID,
+		AccountID: accountID,
+		Name:      name,
+		Enabled:   true,
+		CreatedAt: time.Now(),
+	}
+	s.apiKeys[apiKey.ID] = apiKey
+	s.apiKeyByHash[hash] = apiKey.ID
+	return apiKey
+}
+
+func (s *Store) ListAPIKeys() ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]models.APIKey, 0, len(s.apiKeys))
+	for _, apiKey := range s.apiKeys {
+		keys = append(keys, cloneAPIKey(apiKey))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (s *Store) SetAPIKeyEnabled(keyID int64, enabled bool) error {
+	return s.updateAPIKey(keyID, func(apiKey *models.APIKey) {
+		apiKey.Enabled = enabled
+	})
+}
+
+func (s *Store) SetAPIKeyExpiry(keyID int64, expiresAt *time.Time) error {
+	return s.updateAPIKey(keyID, func(apiKey *models.APIKey) {
+		apiKey.ExpiresAt = nil
+		if expiresAt != nil {
+			t := time.Unix(expiresAt.Unix(), 0)
+			apiKey.ExpiresAt = &t
+		}
+	})
+}
+
+func (s *Store) SetAPIKeyLimits(keyID int64, rateLimit *float64, rateBurst, maxActiveActivations *int) error {
+	return s.updateAPIKey(keyID, func(apiKey *models.APIKey) {
+		limits := cloneAPIKey(&models.APIKey{
+			RateLimit:            rateLimit,
+			RateBurst:            rateBurst,
+			MaxActiveActivations: maxActiveActivations,
+		})
+		apiKey.RateLimit = limits.RateLimit
+		apiKey.RateBurst = limits.RateBurst
+		apiKey.MaxActiveActivations = limits.MaxActiveActivations
+	})
+}
+
+func (s *Store) updateAPIKey(keyID int64, update func(apiKey *models.APIKey)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	apiKey, ok := s.apiKeys[keyID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	update(apiKey)
+	return nil
+}
@@ -0,0 +1,443 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+func (s *Store) ListCountries() ([]models.Country, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	countries := make([]models.Country, 0, len(s.countries))
+	for _, country := range s.countries {
+		countries = append(countries, country)
+	}
+	sort.Slice(countries, func(i, j int) bool { return countries[i].Code < countries[j].Code })
+	return countries, nil
+}
+
+func (s *Store) GetCountry(code string) (models.Country, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.countryByCode[code]
+	if !ok {
+		return models.Country{}, storage.ErrNotFound
+	}
+	return s.countries[id], nil
+}
+
+func (s *Store) CreateCountry(country models.Country) (models.Country, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.countryByCode[country.Code]; exists {
+		return models.Country{}, storage.ErrConflict
+	}
+
+	s.nextCountryID++
+	country.ID = s.nextCountryID
+	s.countries[country.ID] = country
+	s.countryByCode[country.Code] = country.ID
+	return country, nil
+}
+
+func (s *Store) UpdateCountry(country models.Country) (models.Country, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.countryByCode[country.Code]
+	if !ok {
+		return models.Country{}, storage.ErrNotFound
+	}
+
+	country.ID = id
+	s.countries[id] = country
+	return country, nil
+}
+
+// DeleteCountry удаляет страну и ее цены, если за страной не числится номеров
+func (s *Store) DeleteCountry(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.countryByCode[code]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	for _, number := range s.numbers {
+		if number.CountryID == id {
+			return storage.ErrConflict
+		}
+	}
+
+	for key := range s.prices {
+		if key.country == code {
+			delete(s.prices, key)
+		}
+	}
+	delete(s.countries, id)
+	delete(s.countryByCode, code)
+	return nil
+}
+
+func (s *Store) ListServices() ([]models.Service, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	services := make([]models.Service, 0, len(s.services))
+	for _, service := range s.services {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Code < services[j].Code })
+	return services, nil
+}
+
+func (s *Store) CreateService(service models.Service) (models.Service, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.serviceByCode[service.Code]; exists {
+		return models.Service{}, storage.ErrConflict
+	}
+
+	s.nextServiceID++
+	service.ID = s.nextServiceID
+	s.services[service.ID] = service
+	s.serviceByCode[service.Code] = service.ID
+	return service, nil
+}
+
+func (s *Store) UpdateService(service models.Service) (models.Service, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.serviceByCode[service.Code]
+	if !ok {
+		return models.Service{}, storage.ErrNotFound
+	}
+
+	service.ID = id
+	s.services[id] = service
+	return service, nil
+}
+
+// DeleteService удаляет сервис, его цены и историю использования номеров,
+// если по сервису не было активаций
+func (s *Store) DeleteService(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.serviceByCode[code]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	for _, activation := range s.activations {
+		if activation.ServiceID == id {
+			return storage.ErrConflict
+		}
+	}
+
+	for key := range s.prices {
+		if key.service == code {
+			delete(s.prices, key)
+		}
+	}
+	for key := range s.usage {
+		if key.serviceID == id {
+			delete(s.usage, key)
+		}
+	}
+	delete(s.services, id)
+	delete(s.serviceByCode, code)
+	return nil
+}
+
+func (s *Store) ListNumbers(filter storage.NumberFilter) ([]models.PhoneNumber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	numbers := []models.PhoneNumber{}
+	for _, number := range s.numbers {
+		country := s.countries[number.CountryID].Code
+		if filter.Country != "" && country != filter.Country {
+			continue
+		}
+		if filter.Operator != "" && number.Operator != filter.Operator {
+			continue
+		}
+		if filter.Available != nil && number.Available != *filter.Available {
+			continue
+		}
+		numbers = append(numbers, s.describeNumber(number))
+	}
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i].ID < numbers[j].ID })
+	start, end := page(len(numbers), filter.Limit, filter.Offset)
+	return numbers[start:end], nil
+}
+
+func (s *Store) GetNumber(numberID int) (models.PhoneNumber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	number, ok := s.numbers[numberID]
+	if !ok {
+		return models.PhoneNumber{}, storage.ErrNotFound
+	}
+	return s.describeNumber(number), nil
+}
+
+// describeNumber копирует номер и дополняет его кодом страны
+func (s *Store) describeNumber(number *models.PhoneNumber) models.PhoneNumber {
+	clone := *number
+	clone.Country = s.countries[number.CountryID].Code
+	return clone
+}
+
+func (s *Store) CreateNumber(number models.PhoneNumber) (models.PhoneNumber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.countries[number.CountryID]; !ok {
+		return models.PhoneNumber{}, storage.ErrNotFound
+	}
+	if !s.insertNumber(number.Number, number.CountryID, number.Operator) {
+		return models.PhoneNumber{}, storage.ErrConflict
+	}
+
+	created := s.numbers[s.nextNumberID]
+	created.Available = number.Available
+	created.Tags = number.Tags
+	return s.describeNumber(created), nil
+}
+
+func (s *Store) ImportNumbers(numbers []models.PhoneNumber) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, number := range numbers {
+		if _, ok := s.countries[number.CountryID]; !ok {
+			return 0, fmt.Errorf("unknown country id %d", number.CountryID)
+		}
+	}
+
+	inserted := 0
+	for _, number := range numbers {
+		if !s.insertNumber(number.Number, number.CountryID, number.Operator) {
+			continue
+		}
+		created := s.numbers[s.nextNumberID]
+		created.Available = number.Available
+		created.Tags = number.Tags
+		inserted++
+	}
+	return inserted, nil
+}
+
+func (s *Store) UpdateNumber(number models.PhoneNumber) (models.PhoneNumber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.numbers[number.ID]
+	if !ok {
+		return models.PhoneNumber{}, storage.ErrNotFound
+	}
+
+	stored.Operator = number.Operator
+	stored.Available = number.Available
+	stored.Tags = number.Tags
+	return s.describeNumber(stored), nil
+}
+
+// DeleteNumber удаляет номер, по которому не было активаций
+func (s *Store) DeleteNumber(numberID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	number, ok := s.numbers[numberID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	for _, activation := range s.activations {
+		if activation.NumberID == numberID {
+			return storage.ErrConflict
+		}
+	}
+
+	for key := range s.usage {
+		if key.numberID == numberID {
+			delete(s.usage, key)
+		}
+	}
+	delete(s.numberByValue, number.Number)
+	delete(s.numbers, numberID)
+	return nil
+}
+
+func (s *Store) SetNumberAvailable(numberID int, available bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	number, ok := s.numbers[numberID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	number.Available = available
+	return nil
+}
+
+// ReleaseNumber отменяет незавершенные активации номера; сумма возвращается
+// по правилам storage.SettlementKind
+func (s *Store) ReleaseNumber(numberID int, now time.Time) ([]storage.ExpiredActivation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.numbers[numberID]; !ok {
+		return nil, storage.ErrNotFound
+	}
+
+	var released []storage.ExpiredActivation
+	for _, activation := range s.activations {
+		if activation.NumberID != numberID || models.IsFinalActivationStatus(activation.Status) {
+			continue
+		}
+
+		kind := storage.SettlementKind(activation.Status, models.ActivationCancelled)
+		finishedAt, finishReason := now, storage.ReleaseReason
+		activation.Status = models.ActivationCancelled
+		activation.FinishedAt = &finishedAt
+		activation.FinishReason = &finishReason
+
+		s.releaseNumber(activation.ID)
+		s.settleActivation(activation, kind)
+
+		var accountID int64
+		if activation.AccountID != nil {
+			accountID = *activation.AccountID
+		}
+		released = append(released, storage.ExpiredActivation{ID: activation.ID, AccountID: accountID})
+	}
+
+	for key := range s.reservations {
+		if key.numberID == numberID {
+			delete(s.reservations, key)
+		}
+	}
+
+	sort.Slice(released, func(i, j int) bool { return released[i].ID < released[j].ID })
+	return released, nil
+}
+
+func (s *Store) ListActivations(filter storage.ActivationFilter) ([]models.Activation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	activations := []models.Activation{}
+	for _, activation := range s.activations {
+		if !s.activationMatches(activation, filter) {
+			continue
+		}
+		activations = append(activations, cloneActivation(activation))
+	}
+
+	sort.Slice(activations, func(i, j int) bool { return activations[i].ID > activations[j].ID })
+	start, end := page(len(activations), filter.Limit, filter.Offset)
+	return activations[start:end], nil
+}
+
+func (s *Store) ListPrices() ([]storage.Price, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prices := make([]storage.Price, 0, len(s.prices))
+	for key, price := range s.prices {
+		prices = append(prices, storage.Price{
+			Country:  key.country,
+			Operator: key.operator,
+			Service:  key.service,
+			Price:    price,
+		})
+	}
+
+	sort.Slice(prices, func(i, j int) bool {
+		a, b := prices[i], prices[j]
+		if a.Country != b.Country {
+			return a.Country < b.Country
+		}
+		if a.Operator != b.Operator {
+			return a.Operator < b.Operator
+		}
+		return a.Service < b.Service
+	})
+	return prices, nil
+}
+
+func (s *Store) SetPrice(price storage.Price) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.countryByCode[price.Country]; !ok {
+		return storage.ErrNotFound
+	}
+	if _, ok := s.serviceByCode[price.Service]; !ok {
+		return storage.ErrNotFound
+	}
+
+	s.prices[priceKey{country: price.Country, operator: price.Operator, service: price.Service}] = price.Price
+	return nil
+}
+
+func (s *Store) DeletePrice(country, operator, service string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := priceKey{country: country, operator: operator, service: service}
+	if _, ok := s.prices[key]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.prices, key)
+	return nil
+}
+
+func (s *Store) activationMatches(activation *models.Activation, filter storage.ActivationFilter) bool {
+	if filter.AccountID != nil && (activation.AccountID == nil || *activation.AccountID != *filter.AccountID) {
+		return false
+	}
+	if filter.Status != nil && activation.Status != *filter.Status {
+		return false
+	}
+	if filter.Service != "" && s.services[activation.ServiceID].Code != filter.Service {
+		return false
+	}
+	if filter.Country != "" {
+		number, ok := s.numbers[activation.NumberID]
+		if !ok || s.countries[number.CountryID].Code != filter.Country {
+			return false
+		}
+	}
+	if filter.NumberID != 0 && activation.NumberID != filter.NumberID {
+		return false
+	}
+	return filter.MatchesCreatedAt(activation.CreatedAt)
+}
+
+// page возвращает границы страницы в списке из total элементов; limit <= 0 не ограничивает выборку
+func page(total, limit, offset int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	if offset < 0 || limit <= 0 {
+		return 0, total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
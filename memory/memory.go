@@ -0,0 +1,482 @@
+package memory
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/smscode"
+	"sms-api-service/storage"
+)
+
+// Store хранит все данные в памяти процесса и реализует storage.Backend
+// с той же семантикой, что и SQL-хранилища. Данные теряются при остановке;
+// подходит для тестов и демонстрационного запуска.
+type Store struct {
+	mu sync.RWMutex
+
+	countries      map[int]models.Country
+	countryByCode  map[string]int
+	services       map[int]models.Service
+	serviceByCode  map[string]int
+	numbers        map[int]*models.PhoneNumber
+	numberByValue  map[uint64]int
+	reservations   map[numberService]uint64
+	usage          map[numberService]int64
+	prices         map[priceKey]float64
+	accounts       map[int64]*models.Account
+	accountByName  map[string]int64
+	ledger         []models.LedgerEntry
+	apiKeys        map[int64]*models.APIKey
+	apiKeyByHash   map[string]int64
+	activations    map[uint64]*models.Activation
+	messages       map[uint64][]models.SMS
+	nextCountryID  int
+	nextServiceID  int
+	nextNumberID   int
+	nextAccountID  int64
+	nextLedgerID   int64
+	nextAPIKeyID   int64
+	nextActivation uint64
+	nextSMSID      int
+}
+
+// numberService - ключ резервирования и истории использования номера сервисом
+type numberService struct {
+	numberID  int
+	serviceID int
+}
+
+// numberFilters - необязательные условия выбора свободного номера. Выбор
+// повторяется без них, чтобы отличить отказ по цене или префиксам от пустого пула.
+type numberFilters struct {
+	prefixes bool // исключать номера с префиксами query.ExceptionPrefixes
+	price    bool // только номера, цена оператора которых не выше суммы клиента
+}
+
+// priceKey - ключ цены по кодам страны и сервиса
+type priceKey struct {
+	country  string
+	operator string
+	service  string
+}
+
+var _ storage.Backend = (*Store)(nil)
+
+// New создает пустое хранилище
+func New() *Store {
+	return &Store{
+		countries:     make(map[int]models.Country),
+		countryByCode: make(map[string]int),
+		services:      make(map[int]models.Service),
+		serviceByCode: make(map[string]int),
+		numbers:       make(map[int]*models.PhoneNumber),
+		numberByValue: make(map[uint64]int),
+		reservations:  make(map[numberService]uint64),
+		usage:         make(map[numberService]int64),
+		prices:        make(map[priceKey]float64),
+		accounts:      make(map[int64]*models.Account),
+		accountByName: make(map[string]int64),
+		apiKeys:       make(map[int64]*models.APIKey),
+		apiKeyByHash:  make(map[string]int64),
+		activations:   make(map[uint64]*models.Activation),
+		messages:      make(map[uint64][]models.SMS),
+	}
+}
+
+func (s *Store) Close() error {
+	return nil
+}
+
+// numberFree сообщает, можно ли выдать номер сервису: номер включен в пул,
+// не зарезервирован для сервиса и не использовался им начиная с usedSince
+func (s *Store) numberFree(number *models.PhoneNumber, serviceID int, usedSince int64) bool {
+	if !number.Available {
+		return false
+	}
+
+	key := numberService{numberID: number.ID, serviceID: serviceID}
+	if _, reserved := s.reservations[key]; reserved {
+		return false
+	}
+	if usedAt, used := s.usage[key]; used && usedAt >= usedSince {
+		return false
+	}
+	return true
+}
+
+func (s *Store) GetAvailableServices(usedSince time.Time) (map[string]map[string]map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	since := storage.NumberQuery{UsedSince: usedSince}.UsedSinceUnix()
+	countryMap := make(map[string]map[string]map[string]int, len(s.countries))
+
+	for _, number := range s.numbers {
+		country := s.countries[number.CountryID].Code
+
+		for _, service := range s.services {
+			if !s.numberFree(number, service.ID, since) {
+				continue
+			}
+
+			if countryMap[country] == nil {
+				countryMap[country] = make(map[string]map[string]int, 10)
+			}
+			if countryMap[country][number.Operator] == nil {
+				countryMap[country][number.Operator] = make(map[string]int, len(s.services))
+			}
+			countryMap[country][number.Operator][service.Code]++
+		}
+	}
+
+	return countryMap, nil
+}
+
+func (s *Store) GetServiceByCode(code string) (models.Service, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.serviceByCode[code]
+	if !ok {
+		return models.Service{}, storage.ErrNotFound
+	}
+	return s.services[id], nil
+}
+
+// candidateNumbers возвращает свободные номера по запросу; withPrefixes
+// дополнительно отбрасывает номера с префиксами из исключений
+func (s *Store) candidateNumbers(query storage.NumberQuery, sum float64, filters numberFilters) []*models.PhoneNumber {
+	countryID, ok := s.countryByCode[query.Country]
+	if !ok {
+		return nil
+	}
+
+	since := query.UsedSinceUnix()
+	var groups []storage.PrefixGroup
+	excluded := make(map[string]struct{})
+	if filters.prefixes {
+		groups = query.PrefixesByLength()
+		for _, group := range groups {
+			for _, prefix := range group.Prefixes {
+				excluded[prefix] = struct{}{}
+			}
+		}
+	}
+	service := s.services[query.ServiceID].Code
+
+	var candidates []*models.PhoneNumber
+	for _, number := range s.numbers {
+		if number.CountryID != countryID || !query.MatchesOperator(number.Operator) {
+			continue
+		}
+		if !s.numberFree(number, query.ServiceID, since) {
+			continue
+		}
+		if hasAnyPrefix(number.Number, groups, excluded) {
+			continue
+		}
+		if filters.price {
+			if price, ok := s.price(query.Country, number.Operator, service); ok && sum < price {
+				continue
+			}
+		}
+		candidates = append(candidates, number)
+	}
+	return candidates
+}
+
+// hasAnyPrefix сравнивает начало номера каждой длины с группой префиксов этой длины
+func hasAnyPrefix(number uint64, groups []storage.PrefixGroup, excluded map[string]struct{}) bool {
+	digits := strconv.FormatUint(number, 10)
+	for _, group := range groups {
+		if group.Length > len(digits) {
+			break
+		}
+		if _, ok := excluded[digits[:group.Length]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) ReserveNumber(query storage.NumberQuery, accountID int64, sum float64) (models.PhoneNumber, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if query.MaxActive > 0 && s.countActive(accountID) >= query.MaxActive {
+		return models.PhoneNumber{}, 0, storage.ErrTooManyActivations
+	}
+
+	candidates := s.candidateNumbers(query, sum, numberFilters{prefixes: true, price: true})
+	if len(candidates) == 0 {
+		if len(s.candidateNumbers(query, sum, numberFilters{prefixes: true})) > 0 {
+			return models.PhoneNumber{}, 0, storage.ErrPriceTooLow
+		}
+		if len(query.UniquePrefixes()) > 0 && len(s.candidateNumbers(query, sum, numberFilters{})) > 0 {
+			return models.PhoneNumber{}, 0, storage.ErrNumberExcluded
+		}
+		return models.PhoneNumber{}, 0, storage.ErrNotFound
+	}
+
+	account, ok := s.accounts[accountID]
+	if !ok || account.Balance < sum {
+		return models.PhoneNumber{}, 0, storage.ErrNoBalance
+	}
+
+	number := candidates[rand.Intn(len(candidates))]
+
+	s.nextActivation++
+	activationID := s.nextActivation
+	owner := accountID
+	s.activations[activationID] = &models.Activation{
+		ID:        activationID,
+		NumberID:  number.ID,
+		ServiceID: query.ServiceID,
+		AccountID: &owner,
+		Status:    models.ActivationWaiting,
+		Sum:       sum,
+		CreatedAt: time.Now(),
+	}
+
+	account.Balance -= sum
+	account.Held += sum
+	s.appendLedger(account, &activationID, storage.LedgerHold, sum)
+
+	s.reservations[numberService{numberID: number.ID, serviceID: query.ServiceID}] = activationID
+
+	phoneNumber := models.PhoneNumber{
+		ID:       number.ID,
+		Number:   number.Number,
+		Operator: number.Operator,
+	}
+	return phoneNumber, activationID, nil
+}
+
+func (s *Store) TransitionActivation(accountID int64, activationID uint64, status int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transitionActivation(accountID, activationID, status)
+}
+
+func (s *Store) ReceiveSMS(accountID int64, activationID uint64, text string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.transitionActivation(accountID, activationID, models.ActivationCodeReceived); err != nil {
+		return "", err
+	}
+
+	_, serviceCode, err := s.activationTarget(activationID)
+	if err != nil {
+		return "", err
+	}
+
+	code := smscode.Extract(serviceCode, text)
+
+	s.nextSMSID++
+	s.messages[activationID] = append(s.messages[activationID], models.SMS{
+		ID:           s.nextSMSID,
+		ActivationID: activationID,
+		Text:         text,
+		Code:         code,
+		ReceivedAt:   time.Now(),
+	})
+
+	return code, nil
+}
+
+// transitionActivation выполняет переход статуса; вызывается под s.mu
+func (s *Store) transitionActivation(accountID int64, activationID uint64, status int) error {
+	if !models.IsKnownActivationStatus(status) {
+		return storage.ErrBadTransition
+	}
+
+	sources := models.ActivationSourceStatuses(status)
+	if len(sources) == 0 {
+		return storage.ErrBadTransition
+	}
+
+	activation, ok := s.activations[activationID]
+	if !ok || activation.AccountID == nil || *activation.AccountID != accountID {
+		return storage.ErrNotFound
+	}
+	if !containsStatus(sources, activation.Status) {
+		return storage.ErrBadTransition
+	}
+
+	now := time.Now()
+	from := activation.Status
+	activation.Status = status
+
+	if status == models.ActivationCodeReceived || status == models.ActivationCompleted {
+		s.usage[numberService{numberID: activation.NumberID, serviceID: activation.ServiceID}] = now.Unix()
+	}
+
+	if models.IsFinalActivationStatus(status) {
+		activation.FinishedAt = &now
+		s.releaseNumber(activationID)
+		s.settleActivation(activation, storage.SettlementKind(from, status))
+	}
+
+	return nil
+}
+
+func containsStatus(statuses []int, status int) bool {
+	for _, candidate := range statuses {
+		if candidate == status {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseNumber снимает резервирование номера, сделанное активацией
+func (s *Store) releaseNumber(activationID uint64) {
+	for key, reservedBy := range s.reservations {
+		if reservedBy == activationID {
+			delete(s.reservations, key)
+		}
+	}
+}
+
+func (s *Store) ExpireActivations(createdBefore, now time.Time, reason string) ([]storage.ExpiredActivation, error) {
+	sources := models.ActivationSourceStatuses(models.ActivationExpired)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []storage.ExpiredActivation
+	for _, activation := range s.activations {
+		if !containsStatus(sources, activation.Status) || !activation.CreatedAt.Before(createdBefore) {
+			continue
+		}
+
+		kind := storage.SettlementKind(activation.Status, models.ActivationExpired)
+		finishedAt, finishReason := now, reason
+		activation.Status = models.ActivationExpired
+		activation.FinishedAt = &finishedAt
+		activation.FinishReason = &finishReason
+
+		s.releaseNumber(activation.ID)
+		s.settleActivation(activation, kind)
+
+		var accountID int64
+		if activation.AccountID != nil {
+			accountID = *activation.AccountID
+		}
+		expired = append(expired, storage.ExpiredActivation{ID: activation.ID, AccountID: accountID})
+	}
+
+	sort.Slice(expired, func(i, j int) bool { return expired[i].ID < expired[j].ID })
+	return expired, nil
+}
+
+func (s *Store) GetActivation(activationID uint64) (models.Activation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	activation, ok := s.activations[activationID]
+	if !ok {
+		return models.Activation{}, storage.ErrNotFound
+	}
+	return cloneActivation(activation), nil
+}
+
+// cloneActivation копирует активацию вместе с полями-указателями,
+// чтобы вызывающий код не мог изменить данные хранилища
+func cloneActivation(activation *models.Activation) models.Activation {
+	clone := *activation
+	if activation.AccountID != nil {
+		accountID := *activation.AccountID
+		clone.AccountID = &accountID
+	}
+	if activation.FinishedAt != nil {
+		finishedAt := *activation.FinishedAt
+		clone.FinishedAt = &finishedAt
+	}
+	if activation.FinishReason != nil {
+		finishReason := *activation.FinishReason
+		clone.FinishReason = &finishReason
+	}
+	return clone
+}
+
+func (s *Store) GetActivationTarget(activationID uint64) (uint64, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.activationTarget(activationID)
+}
+
+func (s *Store) activationTarget(activationID uint64) (uint64, string, error) {
+	activation, ok := s.activations[activationID]
+	if !ok {
+		return 0, "", storage.ErrNotFound
+	}
+
+	number, ok := s.numbers[activation.NumberID]
+	if !ok {
+		return 0, "", storage.ErrNotFound
+	}
+	service, ok := s.services[activation.ServiceID]
+	if !ok {
+		return 0, "", storage.ErrNotFound
+	}
+
+	return number.Number, service.Code, nil
+}
+
+func (s *Store) CheckActivationOwned(activationID uint64, accountID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	activation, ok := s.activations[activationID]
+	return ok && activation.AccountID != nil && *activation.AccountID == accountID, nil
+}
+
+func (s *Store) CountActiveActivations(accountID int64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.countActive(accountID), nil
+}
+
+// countActive считает незавершенные активации счета; вызывается под s.mu
+func (s *Store) countActive(accountID int64) int {
+	count := 0
+	for _, activation := range s.activations {
+		if activation.AccountID == nil || *activation.AccountID != accountID {
+			continue
+		}
+		switch activation.Status {
+		case models.ActivationWaiting, models.ActivationCodeReceived, models.ActivationRetryRequested:
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Store) CountActivationsByStatus() (map[int]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[int]int, 6)
+	for _, activation := range s.activations {
+		counts[activation.Status]++
+	}
+	return counts, nil
+}
+
+func (s *Store) GetSMSByActivation(activationID uint64) ([]models.SMS, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := make([]models.SMS, len(s.messages[activationID]))
+	copy(messages, s.messages[activationID])
+	return messages, nil
+}
@@ -0,0 +1,12 @@
+package memory
+
+import (
+	"testing"
+
+	"sms-api-service/storage"
+	"sms-api-service/storage/storagetest"
+)
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Backend { return New() })
+}
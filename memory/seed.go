@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"context"
+	"log"
+	"math/rand"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+// Migrate ничего не делает: у хранилища в памяти нет схемы
+func (s *Store) Migrate(ctx context.Context) error {
+	return nil
+}
+
+func (s *Store) MigrationStatus(ctx context.Context) ([]storage.MigrationState, error) {
+	return nil, nil
+}
+
+func (s *Store) PendingMigrations(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// Seed заполняет хранилище справочниками, счетами, ключами и тестовыми номерами.
+// Как и в SQL-хранилищах, существующие записи не перезаписываются.
+func (s *Store) Seed(ctx context.Context, seedData *storage.SeedData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, country := range seedData.Countries {
+		if _, exists := s.countryByCode[country.Code]; exists {
+			continue
+		}
+		s.nextCountryID++
+		s.countries[s.nextCountryID] = models.Country{
+			ID:     s.nextCountryID,
+			Code:   country.Code,
+			Name:   country.Name,
+			Prefix: country.Prefix,
+		}
+		s.countryByCode[country.Code] = s.nextCountryID
+	}
+
+	for _, service := range seedData.Services {
+		if _, exists := s.serviceByCode[service.Code]; exists {
+			continue
+		}
+		s.nextServiceID++
+		s.services[s.nextServiceID] = models.Service{ID: s.nextServiceID, Code: service.Code, Name: service.Name}
+		s.serviceByCode[service.Code] = s.nextServiceID
+	}
+
+	for _, price := range seedData.Prices {
+		_, countryExists := s.countryByCode[price.Country]
+		_, serviceExists := s.serviceByCode[price.Service]
+		if !countryExists || !serviceExists {
+			continue
+		}
+
+		key := priceKey{country: price.Country, operator: price.Operator, service: price.Service}
+		if _, exists := s.prices[key]; !exists {
+			s.prices[key] = price.Price
+		}
+	}
+
+	for _, seedAccount := range seedData.Accounts {
+		account, created := s.ensureAccount(seedAccount.Name)
+		if !created || seedAccount.Balance <= 0 {
+			continue
+		}
+		account.Balance += seedAccount.Balance
+		s.appendLedger(account, nil, storage.LedgerDeposit, seedAccount.Balance)
+	}
+
+	for _, key := range seedData.APIKeys {
+		accountID, ok := s.accountByName[key.Account]
+		if !ok {
+			continue
+		}
+		hash := storage.HashAPIKey(key.Key)
+		if _, exists := s.apiKeyByHash[hash]; !exists {
+			s.insertAPIKey(accountID, hash, key.Name)
+		}
+	}
+
+	for _, country := range s.countries {
+		if country.Prefix == 0 {
+			continue
+		}
+		operators := seedData.Operators[country.Code]
+		if len(operators) == 0 {
+			log.Printf("No operators for country %s, skipping test numbers", country.Code)
+			continue
+		}
+
+		count := rand.Intn(seedData.NumbersRange.Max-seedData.NumbersRange.Min+1) + seedData.NumbersRange.Min
+		for i := 0; i < count; i++ {
+			s.insertNumber(storage.GeneratePhoneNumber(country.Prefix), country.ID, storage.RandomOperator(operators))
+		}
+	}
+
+	log.Print("Data seeded successfully")
+	return nil
+}
+
+// insertNumber добавляет номер в пул, пропуская уже существующие
+func (s *Store) insertNumber(number uint64, countryID int, operator string) bool {
+	if _, exists := s.numberByValue[number]; exists {
+		return false
+	}
+
+	s.nextNumberID++
+	s.numbers[s.nextNumberID] = &models.PhoneNumber{
+		ID:        s.nextNumberID,
+		Number:    number,
+		CountryID: countryID,
+		Operator:  operator,
+		Available: true,
+	}
+	s.numberByValue[number] = s.nextNumberID
+	return true
+}
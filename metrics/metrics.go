@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Метрики сервиса. Обновляются из любого пакета, отдаются обработчиком Handler.
+var (
+	Requests = NewCounterVec("sms_api_requests_total",
+		"API requests by action and response status.", "action", "status")
+	RequestDuration = NewHistogramVec("sms_api_request_duration_seconds",
+		"API request latency by action and response status.", DefaultBuckets, "action", "status")
+	SMSReceived = NewCounterVec("sms_api_sms_received_total",
+		"SMS messages accepted by PUSH_SMS and stored.")
+	DBRetries = NewCounterVec("sms_api_db_retries_total",
+		"Database operations retried because the database was locked.")
+	DBLockWait = NewCounterVec("sms_api_db_lock_wait_seconds_total",
+		"Time spent waiting for a locked database before retrying.")
+)
+
+// DefaultBuckets - границы гистограммы задержек в секундах
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// ObserveRequest учитывает обработанный API-запрос
+func ObserveRequest(action, status string, duration time.Duration) {
+	Requests.Inc(action, status)
+	RequestDuration.Observe(duration.Seconds(), action, status)
+}
+
+// Collector пишет свои метрики в текстовом формате Prometheus
+type Collector interface {
+	Expose(w io.Writer) error
+}
+
+// CounterVec - монотонный счетчик с метками
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*counterSeries),
+	}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	series, ok := c.values[key]
+	if !ok {
+		series = &counterSeries{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = series
+	}
+	series.value += value
+}
+
+func (c *CounterVec) Expose(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		_, err := fmt.Fprintf(w, "%s 0\n", c.name)
+		return err
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := c.values[key]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name,
+			formatLabels(c.labels, series.labelValues), formatValue(series.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec - гистограмма с метками и фиксированными границами
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramSeries),
+	}
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series, ok := h.values[key]
+	if !ok {
+		series = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = series
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (h *HistogramVec) Expose(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	labels := append(append([]string(nil), h.labels...), "le")
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.values[key]
+		labelValues := append(append([]string(nil), series.labelValues...), "")
+
+		for i, bound := range h.buckets {
+			labelValues[len(labelValues)-1] = formatValue(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, labelValues), series.counts[i])
+		}
+		labelValues[len(labelValues)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, labelValues), series.count)
+
+		seriesLabels := formatLabels(h.labels, series.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, seriesLabels, formatValue(series.sum))
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", h.name, seriesLabels, series.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GaugeFunc - измеритель, значения которого вычисляются при каждом опросе
+type GaugeFunc struct {
+	Name    string
+	Help    string
+	Labels  []string
+	Collect func() ([]Sample, error)
+}
+
+// Sample - значение измерителя для набора меток
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+func (g GaugeFunc) Expose(w io.Writer) error {
+	samples, err := g.Collect()
+	if err != nil {
+		return fmt.Errorf("failed to collect %s: %w", g.Name, err)
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, "\xff") < strings.Join(samples[j].LabelValues, "\xff")
+	})
+
+	writeHeader(w, g.Name, g.Help, "gauge")
+	for _, sample := range samples {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", g.Name,
+			formatLabels(g.Labels, sample.LabelValues), formatValue(sample.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler отдает метрики сервиса и дополнительные коллекторы в текстовом формате.
+// Ошибка одного коллектора не мешает отдать остальные.
+func Handler(collectors ...Collector) http.Handler {
+	all := append([]Collector{Requests, RequestDuration, SMSReceived, DBRetries, DBLockWait}, collectors...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		buf := bufio.NewWriter(w)
+		for _, collector := range all {
+			if err := collector.Expose(buf); err != nil {
+				log.Printf("Metrics collection failed: %v", err)
+			}
+		}
+		buf.Flush()
+	})
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+// StoreCollectors возвращает измерители пула номеров и незавершенных активаций.
+// reuseWindow совпадает с окном повторной выдачи номера в обработчиках.
+func StoreCollectors(store storage.Store, reuseWindow time.Duration) []Collector {
+	return []Collector{
+		GaugeFunc{
+			Name:   "sms_api_available_numbers",
+			Help:   "Numbers that can be issued right now, by country, operator and service.",
+			Labels: []string{"country", "operator", "service"},
+			Collect: func() ([]Sample, error) {
+				var usedSince time.Time
+				if reuseWindow > 0 {
+					usedSince = time.Now().Add(-reuseWindow)
+				}
+
+				countryMap, err := store.GetAvailableServices(usedSince)
+				if err != nil {
+					return nil, err
+				}
+
+				var samples []Sample
+				for country, operators := range countryMap {
+					for operator, services := range operators {
+						for service, count := range services {
+							samples = append(samples, Sample{
+								LabelValues: []string{country, operator, service},
+								Value:       float64(count),
+							})
+						}
+					}
+				}
+				return samples, nil
+			},
+		},
+		GaugeFunc{
+			Name:   "sms_api_active_activations",
+			Help:   "Activations that are not finished yet, by status.",
+			Labels: []string{"status"},
+			Collect: func() ([]Sample, error) {
+				counts, err := store.CountActivationsByStatus()
+				if err != nil {
+					return nil, err
+				}
+
+				var samples []Sample
+				for status := models.ActivationWaiting; status <= models.ActivationExpired; status++ {
+					if models.IsFinalActivationStatus(status) {
+						continue
+					}
+					samples = append(samples, Sample{
+						LabelValues: []string{strconv.Itoa(status)},
+						Value:       float64(counts[status]),
+					})
+				}
+				return samples, nil
+			},
+		},
+	}
+}
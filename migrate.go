@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"sms-api-service/storage"
+)
+
+const migrateUsage = `usage:
+  migrate         apply pending migrations
+  migrate status  list migrations and when they were applied`
+
+// runMigrateCommand применяет миграции или выводит их состояние
+func runMigrateCommand(ctx context.Context, store storage.Backend, args []string) error {
+	if len(args) == 0 {
+		return store.Migrate(ctx)
+	}
+
+	if args[0] != "status" {
+		return errors.New(migrateUsage)
+	}
+
+	states, err := store.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		applied := "pending"
+		if state.AppliedAt != nil {
+			applied = state.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%d\t%s\t%s\n", state.Version, state.Name, applied)
+	}
+	return nil
+}
+
+// prepareSchema применяет миграции при старте либо отказывается работать
+// со схемой, для которой есть непримененные миграции
+func prepareSchema(ctx context.Context, store storage.Backend, autoMigrate bool) error {
+	if autoMigrate {
+		return store.Migrate(ctx)
+	}
+
+	pending, err := store.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d pending migrations, run the migrate subcommand", pending)
+	}
+	return nil
+}
@@ -1,11 +1,25 @@
 package models
 
-import "time"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Country struct {
-	ID   int    `json:"id"`
-	Code string `json:"code"`
-	Name string `json:"name"`
+	ID     int    `json:"id"`
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Prefix uint64 `json:"prefix"`
+}
+
+// MatchesPrefix сообщает, начинается ли номер с телефонного префикса страны.
+// Страна без префикса принимает любой номер.
+func (c *Country) MatchesPrefix(number uint64) bool {
+	if c.Prefix == 0 {
+		return true
+	}
+	return strings.HasPrefix(strconv.FormatUint(number, 10), strconv.FormatUint(c.Prefix, 10))
 }
 
 type Service struct {
@@ -18,23 +32,119 @@ type PhoneNumber struct {
 	ID        int    `json:"id"`
 	Number    uint64 `json:"number"`
 	CountryID int    `json:"country_id"`
+	Country   string `json:"country,omitempty"`
 	Operator  string `json:"operator"`
 	Available bool   `json:"available"`
+	Tags      string `json:"tags,omitempty"`
 }
 
 type Activation struct {
-	ID         uint64     `json:"id"`
-	NumberID   int        `json:"number_id"`
-	ServiceID  int        `json:"service_id"`
-	Status     int        `json:"status"`
-	Sum        float64    `json:"sum"`
-	CreatedAt  time.Time  `json:"created_at"`
-	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ID           uint64     `json:"id"`
+	NumberID     int        `json:"number_id"`
+	ServiceID    int        `json:"service_id"`
+	AccountID    *int64     `json:"account_id,omitempty"`
+	Status       int        `json:"status"`
+	Sum          float64    `json:"sum"`
+	CreatedAt    time.Time  `json:"created_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	FinishReason *string    `json:"finish_reason,omitempty"`
+}
+
+type Account struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Balance   float64   `json:"balance"`
+	Held      float64   `json:"held"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APIKey struct {
+	ID                   int64      `json:"id"`
+	AccountID            int64      `json:"account_id"`
+	Name                 string     `json:"name"`
+	Enabled              bool       `json:"enabled"`
+	ExpiresAt            *time.Time `json:"expires_at,omitempty"`
+	RateLimit            *float64   `json:"rate_limit,omitempty"`
+	RateBurst            *int       `json:"rate_burst,omitempty"`
+	MaxActiveActivations *int       `json:"max_active_activations,omitempty"`
+	CreatedAt            time.Time  `json:"created_at"`
+}
+
+func (k *APIKey) Active(now time.Time) bool {
+	return k.Enabled && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+type LedgerEntry struct {
+	ID           int64     `json:"id"`
+	AccountID    int64     `json:"account_id"`
+	ActivationID *uint64   `json:"activation_id,omitempty"`
+	Kind         string    `json:"kind"`
+	Amount       float64   `json:"amount"`
+	BalanceAfter float64   `json:"balance_after"`
+	HeldAfter    float64   `json:"held_after"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 type SMS struct {
 	ID           int       `json:"id"`
 	ActivationID uint64    `json:"activation_id"`
 	Text         string    `json:"text"`
+	Code         string    `json:"code,omitempty"`
 	ReceivedAt   time.Time `json:"received_at"`
 }
+
+const (
+	ActivationWaiting        = 0
+	ActivationCodeReceived   = 1
+	ActivationRetryRequested = 2
+	ActivationCompleted      = 3
+	ActivationCancelled      = 4
+	ActivationExpired        = 5
+)
+
+// activationTransitions перечисляет допустимые переходы статусов активации.
+// Повторный переход в ActivationCodeReceived разрешен: по активации может прийти несколько SMS.
+var activationTransitions = map[int][]int{
+	ActivationWaiting:        {ActivationCodeReceived, ActivationCancelled, ActivationExpired},
+	ActivationCodeReceived:   {ActivationCodeReceived, ActivationRetryRequested, ActivationCompleted, ActivationCancelled, ActivationExpired},
+	ActivationRetryRequested: {ActivationCodeReceived, ActivationCancelled, ActivationExpired},
+}
+
+// IsClientActivationStatus сообщает, может ли клиент запросить статус через FINISH_ACTIVATION.
+// ActivationCodeReceived выставляет PUSH_SMS, ActivationExpired - сборщик.
+func IsClientActivationStatus(status int) bool {
+	return status == ActivationRetryRequested || status == ActivationCompleted || status == ActivationCancelled
+}
+
+// IsAwaitingSMS сообщает, ждет ли активация новую SMS
+func IsAwaitingSMS(status int) bool {
+	return status == ActivationWaiting || status == ActivationRetryRequested
+}
+
+func IsKnownActivationStatus(status int) bool {
+	return status >= ActivationWaiting && status <= ActivationExpired
+}
+
+func IsFinalActivationStatus(status int) bool {
+	return status == ActivationCompleted || status == ActivationCancelled || status == ActivationExpired
+}
+
+func CanTransitionActivation(from, to int) bool {
+	for _, allowed := range activationTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ActivationSourceStatuses возвращает статусы, из которых разрешен переход в to
+func ActivationSourceStatuses(to int) []int {
+	var sources []int
+	for from := ActivationWaiting; from <= ActivationExpired; from++ {
+		if CanTransitionActivation(from, to) {
+			sources = append(sources, from)
+		}
+	}
+	return sources
+}
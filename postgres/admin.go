@@ -0,0 +1,545 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+var adminQueries = struct {
+	listCountries   string
+	getCountry      string
+	createCountry   string
+	updateCountry   string
+	listServices    string
+	createService   string
+	updateService   string
+	listNumbers     string
+	createNumber    string
+	updateNumber    string
+	setAvailable    string
+	listActivations string
+}{
+	listCountries: `SELECT id, code, name, prefix FROM countries ORDER BY code`,
+
+	getCountry: `SELECT id, code, name, prefix FROM countries WHERE code = $1`,
+
+	createCountry: `
+		INSERT INTO countries (code, name, prefix) VALUES ($1, $2, $3)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING id`,
+
+	updateCountry: `UPDATE countries SET name = $1, prefix = $2 WHERE code = $3 RETURNING id`,
+
+	listServices: `SELECT id, code, name FROM services ORDER BY code`,
+
+	createService: `
+		INSERT INTO services (code, name) VALUES ($1, $2)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING id`,
+
+	updateService: `UPDATE services SET name = $1 WHERE code = $2 RETURNING id`,
+
+	listNumbers: `
+		SELECT pn.id, pn.number, pn.country_id, c.code, pn.operator, pn.available, pn.tags
+		FROM phone_numbers pn
+		JOIN countries c ON pn.country_id = c.id
+		WHERE TRUE`,
+
+	createNumber: `
+		INSERT INTO phone_numbers (number, country_id, operator, available, tags)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (number) DO NOTHING
+		RETURNING id`,
+
+	updateNumber: `
+		UPDATE phone_numbers SET operator = $1, available = $2, tags = $3
+		WHERE id = $4 RETURNING id`,
+
+	setAvailable: `UPDATE phone_numbers SET available = $1 WHERE id = $2 RETURNING id`,
+
+	listActivations: `
+		SELECT a.id, a.number_id, a.service_id, a.account_id, a.status, a.sum,
+			a.created_at, a.finished_at, a.finish_reason
+		FROM activations a
+		JOIN services srv ON a.service_id = srv.id
+		JOIN phone_numbers pn ON a.number_id = pn.id
+		JOIN countries c ON pn.country_id = c.id
+		WHERE TRUE`,
+}
+
+func (s *Store) ListCountries() ([]models.Country, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, adminQueries.listCountries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	countries := []models.Country{}
+	for rows.Next() {
+		var country models.Country
+		if err := rows.Scan(&country.ID, &country.Code, &country.Name, &country.Prefix); err != nil {
+			return nil, err
+		}
+		countries = append(countries, country)
+	}
+
+	return countries, rows.Err()
+}
+
+func (s *Store) GetCountry(code string) (models.Country, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var country models.Country
+	err := s.db.QueryRowContext(ctx, adminQueries.getCountry, code).
+		Scan(&country.ID, &country.Code, &country.Name, &country.Prefix)
+	if err != nil {
+		return models.Country{}, notFound(err)
+	}
+	return country, nil
+}
+
+func (s *Store) CreateCountry(country models.Country) (models.Country, error) {
+	err := s.execReturning(adminQueries.createCountry, storage.ErrConflict,
+		country.Code, country.Name, int64(country.Prefix))
+	if err != nil {
+		return models.Country{}, err
+	}
+	return s.GetCountry(country.Code)
+}
+
+func (s *Store) UpdateCountry(country models.Country) (models.Country, error) {
+	err := s.execReturning(adminQueries.updateCountry, storage.ErrNotFound,
+		country.Name, int64(country.Prefix), country.Code)
+	if err != nil {
+		return models.Country{}, err
+	}
+	return s.GetCountry(country.Code)
+}
+
+// DeleteCountry удаляет страну и ее цены, если за страной не числится номеров
+func (s *Store) DeleteCountry(code string) error {
+	return s.withTx(func(ctx context.Context, tx *sql.Tx) error {
+		var countryID int64
+		if err := tx.QueryRowContext(ctx, "SELECT id FROM countries WHERE code = $1 FOR UPDATE", code).Scan(&countryID); err != nil {
+			return notFound(err)
+		}
+
+		if err := ensureUnused(ctx, tx, "SELECT EXISTS (SELECT 1 FROM phone_numbers WHERE country_id = $1)", countryID); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			"DELETE FROM prices WHERE country_id = $1",
+			"DELETE FROM countries WHERE id = $1",
+		} {
+			if _, err := tx.ExecContext(ctx, query, countryID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) ListServices() ([]models.Service, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, adminQueries.listServices)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []models.Service{}
+	for rows.Next() {
+		var service models.Service
+		if err := rows.Scan(&service.ID, &service.Code, &service.Name); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+
+	return services, rows.Err()
+}
+
+func (s *Store) CreateService(service models.Service) (models.Service, error) {
+	if err := s.execReturning(adminQueries.createService, storage.ErrConflict, service.Code, service.Name); err != nil {
+		return models.Service{}, err
+	}
+	return s.GetServiceByCode(service.Code)
+}
+
+func (s *Store) UpdateService(service models.Service) (models.Service, error) {
+	if err := s.execReturning(adminQueries.updateService, storage.ErrNotFound, service.Name, service.Code); err != nil {
+		return models.Service{}, err
+	}
+	s.forgetService(service.Code)
+	return s.GetServiceByCode(service.Code)
+}
+
+// DeleteService удаляет сервис, его цены и историю использования номеров,
+// если по сервису не было активаций
+func (s *Store) DeleteService(code string) error {
+	err := s.withTx(func(ctx context.Context, tx *sql.Tx) error {
+		var serviceID int64
+		if err := tx.QueryRowContext(ctx, "SELECT id FROM services WHERE code = $1 FOR UPDATE", code).Scan(&serviceID); err != nil {
+			return notFound(err)
+		}
+
+		if err := ensureUnused(ctx, tx, "SELECT EXISTS (SELECT 1 FROM activations WHERE service_id = $1)", serviceID); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			"DELETE FROM prices WHERE service_id = $1",
+			"DELETE FROM number_usage WHERE service_id = $1",
+			"DELETE FROM services WHERE id = $1",
+		} {
+			if _, err := tx.ExecContext(ctx, query, serviceID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		s.forgetService(code)
+	}
+	return err
+}
+
+// forgetService убирает сервис из кеша после изменения
+func (s *Store) forgetService(code string) {
+	s.servicesMu.Lock()
+	delete(s.services, code)
+	s.servicesMu.Unlock()
+}
+
+func (s *Store) ListNumbers(filter storage.NumberFilter) ([]models.PhoneNumber, error) {
+	var sb strings.Builder
+	sb.WriteString(adminQueries.listNumbers)
+	var args []interface{}
+
+	if filter.Country != "" {
+		args = append(args, filter.Country)
+		fmt.Fprintf(&sb, " AND c.code = $%d", len(args))
+	}
+	if filter.Operator != "" {
+		args = append(args, filter.Operator)
+		fmt.Fprintf(&sb, " AND pn.operator = $%d", len(args))
+	}
+	if filter.Available != nil {
+		args = append(args, *filter.Available)
+		fmt.Fprintf(&sb, " AND pn.available = $%d", len(args))
+	}
+	sb.WriteString(" ORDER BY pn.id")
+	args = appendPage(&sb, args, filter.Limit, filter.Offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	numbers := []models.PhoneNumber{}
+	for rows.Next() {
+		var number models.PhoneNumber
+		if err := rows.Scan(&number.ID, &number.Number, &number.CountryID, &number.Country,
+			&number.Operator, &number.Available, &number.Tags); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+
+	return numbers, rows.Err()
+}
+
+func (s *Store) GetNumber(numberID int) (models.PhoneNumber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var number models.PhoneNumber
+	err := s.db.QueryRowContext(ctx, adminQueries.listNumbers+" AND pn.id = $1", numberID).
+		Scan(&number.ID, &number.Number, &number.CountryID, &number.Country,
+			&number.Operator, &number.Available, &number.Tags)
+	if err != nil {
+		return models.PhoneNumber{}, notFound(err)
+	}
+	return number, nil
+}
+
+func (s *Store) CreateNumber(number models.PhoneNumber) (models.PhoneNumber, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var id int
+	err := s.db.QueryRowContext(ctx, adminQueries.createNumber,
+		int64(number.Number), number.CountryID, number.Operator, number.Available, number.Tags).Scan(&id)
+	if err == sql.ErrNoRows {
+		return models.PhoneNumber{}, storage.ErrConflict
+	}
+	if err != nil {
+		return models.PhoneNumber{}, err
+	}
+	return s.GetNumber(id)
+}
+
+// ImportNumbers вставляет батч номеров одним многострочным INSERT, как при заполнении базы
+func (s *Store) ImportNumbers(numbers []models.PhoneNumber) (int, error) {
+	if len(numbers) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	values := make([]string, len(numbers))
+	args := make([]interface{}, 0, len(numbers)*5)
+	for i, number := range numbers {
+		args = append(args, int64(number.Number), number.CountryID, number.Operator, number.Available, number.Tags)
+		n := len(args)
+		values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n-4, n-3, n-2, n-1, n)
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO phone_numbers (number, country_id, operator, available, tags)
+		VALUES `+strings.Join(values, ", ")+`
+		ON CONFLICT (number) DO NOTHING`, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	inserted, err := result.RowsAffected()
+	return int(inserted), err
+}
+
+func (s *Store) UpdateNumber(number models.PhoneNumber) (models.PhoneNumber, error) {
+	err := s.execReturning(adminQueries.updateNumber, storage.ErrNotFound,
+		number.Operator, number.Available, number.Tags, number.ID)
+	if err != nil {
+		return models.PhoneNumber{}, err
+	}
+	return s.GetNumber(number.ID)
+}
+
+// DeleteNumber удаляет номер, по которому не было активаций
+func (s *Store) DeleteNumber(numberID int) error {
+	return s.withTx(func(ctx context.Context, tx *sql.Tx) error {
+		var id int64
+		if err := tx.QueryRowContext(ctx, "SELECT id FROM phone_numbers WHERE id = $1 FOR UPDATE", numberID).Scan(&id); err != nil {
+			return notFound(err)
+		}
+
+		if err := ensureUnused(ctx, tx, "SELECT EXISTS (SELECT 1 FROM activations WHERE number_id = $1)", id); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			"DELETE FROM number_usage WHERE number_id = $1",
+			"DELETE FROM phone_numbers WHERE id = $1",
+		} {
+			if _, err := tx.ExecContext(ctx, query, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) SetNumberAvailable(numberID int, available bool) error {
+	return s.execReturning(adminQueries.setAvailable, storage.ErrNotFound, available, numberID)
+}
+
+// ReleaseNumber отменяет незавершенные активации номера; сумма возвращается
+// по правилам storage.SettlementKind
+func (s *Store) ReleaseNumber(numberID int, now time.Time) ([]storage.ExpiredActivation, error) {
+	var released []storage.ExpiredActivation
+
+	err := s.withTx(func(ctx context.Context, tx *sql.Tx) error {
+		var id int64
+		if err := tx.QueryRowContext(ctx, "SELECT id FROM phone_numbers WHERE id = $1 FOR UPDATE", numberID).Scan(&id); err != nil {
+			return notFound(err)
+		}
+
+		// Подзапрос блокирует строки и возвращает статус до обновления
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE activations a
+			SET status = $1, finished_at = $2, finish_reason = $3
+			FROM (
+				SELECT id, status FROM activations
+				WHERE number_id = $4 AND status IN ($5, $6, $7)
+				FOR UPDATE
+			) old
+			WHERE a.id = old.id
+			RETURNING a.id, COALESCE(a.account_id, 0), old.status`,
+			models.ActivationCancelled, now, storage.ReleaseReason, id,
+			models.ActivationWaiting, models.ActivationCodeReceived, models.ActivationRetryRequested)
+		if err != nil {
+			return err
+		}
+
+		var from []int
+		for rows.Next() {
+			var (
+				activation storage.ExpiredActivation
+				status     int
+			)
+			if err := rows.Scan(&activation.ID, &activation.AccountID, &status); err != nil {
+				rows.Close()
+				return err
+			}
+			released = append(released, activation)
+			from = append(from, status)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		rows.Close()
+
+		for i, activation := range released {
+			kind := storage.SettlementKind(from[i], models.ActivationCancelled)
+			if err := settleActivation(ctx, tx, activation.ID, kind); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM number_reservations WHERE number_id = $1", id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return released, nil
+}
+
+func (s *Store) ListActivations(filter storage.ActivationFilter) ([]models.Activation, error) {
+	var sb strings.Builder
+	sb.WriteString(adminQueries.listActivations)
+	var args []interface{}
+
+	if filter.AccountID != nil {
+		args = append(args, *filter.AccountID)
+		fmt.Fprintf(&sb, " AND a.account_id = $%d", len(args))
+	}
+	if filter.Status != nil {
+		args = append(args, *filter.Status)
+		fmt.Fprintf(&sb, " AND a.status = $%d", len(args))
+	}
+	if filter.Service != "" {
+		args = append(args, filter.Service)
+		fmt.Fprintf(&sb, " AND srv.code = $%d", len(args))
+	}
+	if filter.Country != "" {
+		args = append(args, filter.Country)
+		fmt.Fprintf(&sb, " AND c.code = $%d", len(args))
+	}
+	if filter.NumberID != 0 {
+		args = append(args, filter.NumberID)
+		fmt.Fprintf(&sb, " AND a.number_id = $%d", len(args))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		args = append(args, filter.CreatedAfter)
+		fmt.Fprintf(&sb, " AND a.created_at >= $%d", len(args))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		args = append(args, filter.CreatedBefore)
+		fmt.Fprintf(&sb, " AND a.created_at < $%d", len(args))
+	}
+	sb.WriteString(" ORDER BY a.id DESC")
+	args = appendPage(&sb, args, filter.Limit, filter.Offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activations := []models.Activation{}
+	for rows.Next() {
+		var activation models.Activation
+		if err := rows.Scan(
+			&activation.ID,
+			&activation.NumberID,
+			&activation.ServiceID,
+			&activation.AccountID,
+			&activation.Status,
+			&activation.Sum,
+			&activation.CreatedAt,
+			&activation.FinishedAt,
+			&activation.FinishReason,
+		); err != nil {
+			return nil, err
+		}
+		activations = append(activations, activation)
+	}
+
+	return activations, rows.Err()
+}
+
+// appendPage дописывает к запросу LIMIT и OFFSET; limit <= 0 не ограничивает выборку
+func appendPage(sb *strings.Builder, args []interface{}, limit, offset int) []interface{} {
+	if limit <= 0 {
+		return args
+	}
+	args = append(args, limit, offset)
+	fmt.Fprintf(sb, " LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	return args
+}
+
+// execReturning выполняет запрос с RETURNING; отсутствие строки превращается в missing
+func (s *Store) execReturning(query string, missing error, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return missing
+	}
+	return err
+}
+
+// ensureUnused возвращает ErrConflict, если запрос существования нашел зависимые записи
+func ensureUnused(ctx context.Context, tx *sql.Tx, query string, id int64) error {
+	var used bool
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&used); err != nil {
+		return err
+	}
+	if used {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+func (s *Store) withTx(fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,195 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+var apiKeyQueries = struct {
+	getAPIKeyByHash string
+	createAPIKey    string
+	seedAPIKey      string
+	setEnabled      string
+	setExpiresAt    string
+	setLimits       string
+	listAPIKeys     string
+}{
+	getAPIKeyByHash: `
+		SELECT id, account_id, name, enabled, expires_at, rate_limit, rate_burst, max_active_activations, created_at
+		FROM api_keys WHERE key_hash = $1`,
+
+	createAPIKey: `
+		INSERT INTO api_keys (account_id, key_hash, name, enabled, expires_at, created_at)
+		VALUES ($1, $2, $3, TRUE, $4, $5)
+		RETURNING id`,
+
+	seedAPIKey: `
+		INSERT INTO api_keys (account_id, key_hash, name, enabled, created_at)
+		SELECT id, $1, $2, TRUE, $3 FROM accounts WHERE name = $4
+		ON CONFLICT (key_hash) DO NOTHING`,
+
+	setEnabled: `UPDATE api_keys SET enabled = $1 WHERE id = $2`,
+
+	setExpiresAt: `UPDATE api_keys SET expires_at = $1 WHERE id = $2`,
+
+	setLimits: `
+		UPDATE api_keys
+		SET rate_limit = $1, rate_burst = $2, max_active_activations = $3
+		WHERE id = $4`,
+
+	listAPIKeys: `
+		SELECT id, account_id, name, enabled, expires_at, rate_limit, rate_burst, max_active_activations, created_at
+		FROM api_keys ORDER BY id`,
+}
+
+func (s *Store) AuthenticateAPIKey(key string, now time.Time) (models.APIKey, error) {
+	if key == "" {
+		return models.APIKey{}, storage.ErrInvalidKey
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	apiKey, err := scanAPIKey(s.db.QueryRowContext(ctx, apiKeyQueries.getAPIKeyByHash, storage.HashAPIKey(key)))
+	if err == sql.ErrNoRows {
+		return models.APIKey{}, storage.ErrInvalidKey
+	}
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	if !apiKey.Active(now) {
+		return models.APIKey{}, storage.ErrInvalidKey
+	}
+
+	return apiKey, nil
+}
+
+func (s *Store) CreateAPIKey(accountID int64, name string, expiresAt *time.Time) (string, models.APIKey, error) {
+	key, err := storage.NewAPIKey()
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var id int64
+	err = s.db.QueryRowContext(ctx, apiKeyQueries.createAPIKey,
+		accountID, storage.HashAPIKey(key), name, unixOrNil(expiresAt), now).Scan(&id)
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	return key, models.APIKey{
+		ID:        id,
+		AccountID: accountID,
+		Name:      name,
+		Enabled:   true,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}, nil
+}
+
+func (s *Store) ListAPIKeys() ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, apiKeyQueries.listAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, apiKey)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *Store) SetAPIKeyEnabled(keyID int64, enabled bool) error {
+	return s.execAPIKeyUpdate(apiKeyQueries.setEnabled, enabled, keyID)
+}
+
+func (s *Store) SetAPIKeyExpiry(keyID int64, expiresAt *time.Time) error {
+	return s.execAPIKeyUpdate(apiKeyQueries.setExpiresAt, unixOrNil(expiresAt), keyID)
+}
+
+func (s *Store) SetAPIKeyLimits(keyID int64, rateLimit *float64, rateBurst, maxActiveActivations *int) error {
+	return s.execAPIKeyUpdate(apiKeyQueries.setLimits, rateLimit, rateBurst, maxActiveActivations, keyID)
+}
+
+func (s *Store) execAPIKeyUpdate(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var (
+		apiKey    models.APIKey
+		expiresAt sql.NullInt64
+		rateLimit sql.NullFloat64
+		rateBurst sql.NullInt64
+		maxActive sql.NullInt64
+	)
+	err := row.Scan(&apiKey.ID, &apiKey.AccountID, &apiKey.Name, &apiKey.Enabled, &expiresAt,
+		&rateLimit, &rateBurst, &maxActive, &apiKey.CreatedAt)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	if rateLimit.Valid {
+		apiKey.RateLimit = &rateLimit.Float64
+	}
+	if rateBurst.Valid {
+		burst := int(rateBurst.Int64)
+		apiKey.RateBurst = &burst
+	}
+	if maxActive.Valid {
+		limit := int(maxActive.Int64)
+		apiKey.MaxActiveActivations = &limit
+	}
+
+	if expiresAt.Valid {
+		t := time.Unix(expiresAt.Int64, 0)
+		apiKey.ExpiresAt = &t
+	}
+
+	return apiKey, nil
+}
+
+func unixOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
@@ -0,0 +1,281 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+var billingQueries = struct {
+	getAccountByName    string
+	getAccountByID      string
+	createAccount       string
+	holdFunds           string
+	captureFunds        string
+	refundFunds         string
+	depositFunds        string
+	insertLedgerEntry   string
+	getActivationCharge string
+	getPrices           string
+	listPrices          string
+	setPrice            string
+	deletePrice         string
+	seedPrice           string
+}{
+	getAccountByName: `SELECT id, name, balance, held, created_at FROM accounts WHERE name = $1`,
+
+	getAccountByID: `SELECT id, name, balance, held, created_at FROM accounts WHERE id = $1`,
+
+	createAccount: `
+		INSERT INTO accounts (name, balance, held, created_at) VALUES ($1, 0, 0, $2)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id`,
+
+	holdFunds: `
+		UPDATE accounts
+		SET balance = balance - $1, held = held + $1
+		WHERE id = $2 AND balance >= $1`,
+
+	captureFunds: `UPDATE accounts SET held = held - $1 WHERE id = $2`,
+
+	refundFunds: `UPDATE accounts SET held = held - $1, balance = balance + $1 WHERE id = $2`,
+
+	depositFunds: `UPDATE accounts SET balance = balance + $1 WHERE id = $2`,
+
+	insertLedgerEntry: `
+		INSERT INTO ledger_entries (account_id, activation_id, kind, amount, balance_after, held_after, created_at)
+		SELECT id, $1, $2, $3, balance, held, $4 FROM accounts WHERE id = $5`,
+
+	getActivationCharge: `
+		SELECT account_id, sum FROM activations
+		WHERE id = $1 AND account_id IS NOT NULL`,
+
+	getPrices: `
+		SELECT c.code, p.operator, srv.code, p.price
+		FROM prices p
+		JOIN countries c ON p.country_id = c.id
+		JOIN services srv ON p.service_id = srv.id`,
+
+	listPrices: `
+		SELECT c.code, p.operator, srv.code, p.price
+		FROM prices p
+		JOIN countries c ON p.country_id = c.id
+		JOIN services srv ON p.service_id = srv.id
+		ORDER BY c.code, p.operator, srv.code`,
+
+	setPrice: `
+		INSERT INTO prices (country_id, operator, service_id, price, updated_at)
+		SELECT c.id, $1, srv.id, $2, $3
+		FROM countries c, services srv
+		WHERE c.code = $4 AND srv.code = $5
+		ON CONFLICT (country_id, operator, service_id)
+		DO UPDATE SET price = excluded.price, updated_at = excluded.updated_at
+		RETURNING country_id`,
+
+	deletePrice: `
+		DELETE FROM prices
+		WHERE operator = $1
+		AND country_id = (SELECT id FROM countries WHERE code = $2)
+		AND service_id = (SELECT id FROM services WHERE code = $3)
+		RETURNING country_id`,
+
+	seedPrice: `
+		INSERT INTO prices (country_id, operator, service_id, price, updated_at)
+		SELECT c.id, $1, srv.id, $2, $3
+		FROM countries c, services srv
+		WHERE c.code = $4 AND srv.code = $5
+		ON CONFLICT (country_id, operator, service_id) DO NOTHING`,
+}
+
+func (s *Store) GetAccount(accountID int64) (models.Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var account models.Account
+	err := s.db.QueryRowContext(ctx, billingQueries.getAccountByID, accountID).
+		Scan(&account.ID, &account.Name, &account.Balance, &account.Held, &account.CreatedAt)
+	if err != nil {
+		return models.Account{}, notFound(err)
+	}
+	return account, nil
+}
+
+func (s *Store) EnsureAccount(name string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	accountID, _, err := ensureAccount(ctx, s.db, name)
+	return accountID, err
+}
+
+// ensureAccount возвращает идентификатор счета и признак того, что счет только что создан
+func ensureAccount(ctx context.Context, db *sql.DB, name string) (int64, bool, error) {
+	var accountID int64
+	err := db.QueryRowContext(ctx, billingQueries.createAccount, name, time.Now()).Scan(&accountID)
+	if err == nil {
+		return accountID, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	var account models.Account
+	err = db.QueryRowContext(ctx, billingQueries.getAccountByName, name).
+		Scan(&account.ID, &account.Name, &account.Balance, &account.Held, &account.CreatedAt)
+	return account.ID, false, err
+}
+
+// deposit зачисляет средства на счет и записывает операцию в журнал
+func (s *Store) deposit(ctx context.Context, accountID int64, amount float64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, billingQueries.depositFunds, amount, accountID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return storage.ErrNotFound
+	}
+
+	if err := appendLedger(ctx, tx, accountID, nil, storage.LedgerDeposit, amount); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// holdFunds блокирует стоимость активации на счете клиента
+func holdFunds(ctx context.Context, tx *sql.Tx, accountID int64, activationID uint64, amount float64) error {
+	result, err := tx.ExecContext(ctx, billingQueries.holdFunds, amount, accountID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return storage.ErrNoBalance
+	}
+
+	return appendLedger(ctx, tx, accountID, &activationID, storage.LedgerHold, amount)
+}
+
+// settleActivation списывает (capture) или возвращает (refund) заблокированную сумму
+// активации. Активации без счета пропускаются.
+func settleActivation(ctx context.Context, tx *sql.Tx, activationID uint64, kind string) error {
+	var (
+		accountID int64
+		amount    float64
+	)
+	err := tx.QueryRowContext(ctx, billingQueries.getActivationCharge, activationID).Scan(&accountID, &amount)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case storage.LedgerCapture:
+		_, err = tx.ExecContext(ctx, billingQueries.captureFunds, amount, accountID)
+	case storage.LedgerRefund:
+		_, err = tx.ExecContext(ctx, billingQueries.refundFunds, amount, accountID)
+	default:
+		return errors.New("unknown settlement kind: " + kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	return appendLedger(ctx, tx, accountID, &activationID, kind, amount)
+}
+
+func appendLedger(ctx context.Context, tx *sql.Tx, accountID int64, activationID *uint64, kind string, amount float64) error {
+	var activation interface{}
+	if activationID != nil {
+		activation = int64(*activationID)
+	}
+
+	_, err := tx.ExecContext(ctx, billingQueries.insertLedgerEntry,
+		activation, kind, amount, time.Now(), accountID)
+	return err
+}
+
+func (s *Store) GetPrices() (map[string]map[string]map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, billingQueries.getPrices)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	countryMap := make(map[string]map[string]map[string]float64, 50)
+
+	for rows.Next() {
+		var country, operator, service string
+		var price float64
+
+		if err := rows.Scan(&country, &operator, &service, &price); err != nil {
+			return nil, err
+		}
+
+		if countryMap[country] == nil {
+			countryMap[country] = make(map[string]map[string]float64, 10)
+		}
+		if countryMap[country][operator] == nil {
+			countryMap[country][operator] = make(map[string]float64, 20)
+		}
+
+		countryMap[country][operator][service] = price
+	}
+
+	return countryMap, rows.Err()
+}
+
+func (s *Store) ListPrices() ([]storage.Price, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, billingQueries.listPrices)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []storage.Price{}
+	for rows.Next() {
+		var price storage.Price
+		if err := rows.Scan(&price.Country, &price.Operator, &price.Service, &price.Price); err != nil {
+			return nil, err
+		}
+		prices = append(prices, price)
+	}
+
+	return prices, rows.Err()
+}
+
+func (s *Store) SetPrice(price storage.Price) error {
+	return s.execReturning(billingQueries.setPrice, storage.ErrNotFound,
+		price.Operator, price.Price, time.Now(), price.Country, price.Service)
+}
+
+func (s *Store) DeletePrice(country, operator, service string) error {
+	return s.execReturning(billingQueries.deletePrice, storage.ErrNotFound, operator, country, service)
+}
@@ -0,0 +1,279 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"sms-api-service/storage"
+)
+
+// migration - пронумерованное изменение схемы PostgreSQL. Номера и названия
+// совпадают с миграциями SQLite, чтобы состояние схемы читалось одинаково.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+var migrations = []migration{
+	{Version: 1, Name: "initial schema", SQL: initialSchema},
+	{Version: 2, Name: "country prefixes", SQL: countryPrefixes},
+	{Version: 3, Name: "number tags", SQL: numberTags},
+	{Version: 4, Name: "comparable timestamps", SQL: comparableTimestamps},
+}
+
+const createMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`
+
+const initialSchema = `
+	CREATE TABLE countries (
+		id BIGSERIAL PRIMARY KEY,
+		code TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE services (
+		id BIGSERIAL PRIMARY KEY,
+		code TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE phone_numbers (
+		id BIGSERIAL PRIMARY KEY,
+		number BIGINT UNIQUE NOT NULL,
+		country_id BIGINT NOT NULL REFERENCES countries (id),
+		operator TEXT NOT NULL,
+		available BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE prices (
+		country_id BIGINT NOT NULL REFERENCES countries (id),
+		operator TEXT NOT NULL,
+		service_id BIGINT NOT NULL REFERENCES services (id),
+		price DOUBLE PRECISION NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (country_id, operator, service_id)
+	);
+
+	CREATE TABLE accounts (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT UNIQUE NOT NULL,
+		balance DOUBLE PRECISION NOT NULL DEFAULT 0,
+		held DOUBLE PRECISION NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE api_keys (
+		id BIGSERIAL PRIMARY KEY,
+		account_id BIGINT NOT NULL REFERENCES accounts (id),
+		key_hash TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		expires_at BIGINT,
+		rate_limit DOUBLE PRECISION,
+		rate_burst INTEGER,
+		max_active_activations INTEGER,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE activations (
+		id BIGSERIAL PRIMARY KEY,
+		number_id BIGINT NOT NULL REFERENCES phone_numbers (id),
+		service_id BIGINT NOT NULL REFERENCES services (id),
+		account_id BIGINT REFERENCES accounts (id),
+		status INTEGER NOT NULL DEFAULT 0,
+		sum DOUBLE PRECISION NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		finished_at TIMESTAMPTZ,
+		finish_reason TEXT
+	);
+
+	CREATE TABLE ledger_entries (
+		id BIGSERIAL PRIMARY KEY,
+		account_id BIGINT NOT NULL REFERENCES accounts (id),
+		activation_id BIGINT REFERENCES activations (id),
+		kind TEXT NOT NULL,
+		amount DOUBLE PRECISION NOT NULL,
+		balance_after DOUBLE PRECISION NOT NULL,
+		held_after DOUBLE PRECISION NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE number_reservations (
+		number_id BIGINT NOT NULL REFERENCES phone_numbers (id),
+		service_id BIGINT NOT NULL REFERENCES services (id),
+		activation_id BIGINT NOT NULL REFERENCES activations (id),
+		PRIMARY KEY (number_id, service_id)
+	);
+
+	CREATE TABLE number_usage (
+		number_id BIGINT NOT NULL REFERENCES phone_numbers (id),
+		service_id BIGINT NOT NULL REFERENCES services (id),
+		used_at BIGINT NOT NULL,
+		PRIMARY KEY (number_id, service_id)
+	);
+
+	CREATE TABLE sms_messages (
+		id BIGSERIAL PRIMARY KEY,
+		activation_id BIGINT NOT NULL REFERENCES activations (id),
+		text TEXT NOT NULL,
+		code TEXT,
+		received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE INDEX idx_phone_numbers_country_available ON phone_numbers (country_id, available);
+	CREATE INDEX idx_activations_status ON activations (status);
+	CREATE INDEX idx_activations_created_at ON activations (created_at);
+	CREATE INDEX idx_activations_account_id ON activations (account_id);
+	CREATE INDEX idx_number_reservations_activation_id ON number_reservations (activation_id);
+	CREATE INDEX idx_ledger_entries_account_id ON ledger_entries (account_id);
+	CREATE INDEX idx_sms_messages_activation_id ON sms_messages (activation_id);
+	`
+
+const countryPrefixes = `
+	ALTER TABLE countries ADD COLUMN prefix BIGINT NOT NULL DEFAULT 0;
+
+	UPDATE countries SET prefix = CASE code
+		WHEN 'rus' THEN 7
+		WHEN 'uzb' THEN 998
+		WHEN 'bel' THEN 375
+		ELSE prefix
+	END;
+	`
+
+const numberTags = `
+	ALTER TABLE phone_numbers ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+	`
+
+// comparableTimestamps ничего не меняет: в SQLite миграция 4 переписывает время
+// в сравнимый формат, а TIMESTAMPTZ сравнивается и так. Номер занят, чтобы
+// нумерация совпадала.
+const comparableTimestamps = `SELECT 1`
+
+// Migrate применяет все непримененные миграции по порядку
+func (s *Store) Migrate(ctx context.Context) error {
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		if err := s.applyMigration(ctx, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %d: %s", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// PendingMigrations возвращает число непримененных миграций
+func (s *Store) PendingMigrations(ctx context.Context) (int, error) {
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// MigrationStatus возвращает все известные миграции с отметкой о применении
+func (s *Store) MigrationStatus(ctx context.Context) ([]storage.MigrationState, error) {
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]storage.MigrationState, len(migrations))
+	for i, m := range migrations {
+		states[i].Version = m.Version
+		states[i].Name = m.Name
+		if appliedAt, ok := applied[m.Version]; ok {
+			states[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return states, nil
+}
+
+func (s *Store) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	if _, err := s.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// applyMigration выполняет миграцию в транзакции; advisory lock не дает
+// двум экземплярам сервиса применять миграции одновременно
+func (s *Store) applyMigration(ctx context.Context, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))"); err != nil {
+		return err
+	}
+
+	var exists int
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM schema_migrations WHERE version = $1", m.Version).Scan(&exists)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+		m.Version, m.Name, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,699 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"sms-api-service/models"
+	"sms-api-service/smscode"
+	"sms-api-service/storage"
+)
+
+// Config содержит строку подключения и параметры пула соединений
+type Config struct {
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Store реализует storage.Backend поверх PostgreSQL
+type Store struct {
+	db *sql.DB
+
+	servicesMu sync.RWMutex
+	services   map[string]models.Service
+}
+
+var _ storage.Backend = (*Store)(nil)
+
+var queries = struct {
+	getAvailableServices   string
+	getAvailableNumber     string
+	reserveNumber          string
+	recordNumberUsage      string
+	getServiceByCode       string
+	createActivation       string
+	releaseNumber          string
+	checkActivationOwned   string
+	lockActivationStatus   string
+	countActiveActivations string
+	lockAccount            string
+	countByStatus          string
+	storeSMS               string
+	getActivationByID      string
+	getActivationTarget    string
+	getSMSByActivation     string
+}{
+	getAvailableServices: `
+		SELECT c.code, pn.operator, srv.code, COUNT(*)
+		FROM phone_numbers pn
+		JOIN countries c ON pn.country_id = c.id
+		CROSS JOIN services srv
+		WHERE pn.available
+		AND NOT EXISTS (
+			SELECT 1 FROM number_reservations nr
+			WHERE nr.number_id = pn.id AND nr.service_id = srv.id
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM number_usage nu
+			WHERE nu.number_id = pn.id AND nu.service_id = srv.id AND nu.used_at >= $1
+		)
+		GROUP BY c.code, pn.operator, srv.code`,
+
+	getAvailableNumber: `
+		SELECT pn.id, pn.number, pn.operator
+		FROM phone_numbers pn
+		JOIN countries c ON pn.country_id = c.id
+		WHERE c.code = $1 AND ($2 = 'any' OR pn.operator = $2) AND pn.available
+		AND NOT EXISTS (
+			SELECT 1 FROM number_reservations nr
+			WHERE nr.number_id = pn.id AND nr.service_id = $3
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM number_usage nu
+			WHERE nu.number_id = pn.id AND nu.service_id = $3 AND nu.used_at >= $4
+		)`,
+
+	reserveNumber: `
+		INSERT INTO number_reservations (number_id, service_id, activation_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (number_id, service_id) DO NOTHING`,
+
+	recordNumberUsage: `
+		INSERT INTO number_usage (number_id, service_id, used_at)
+		SELECT number_id, service_id, $1 FROM activations WHERE id = $2
+		ON CONFLICT (number_id, service_id) DO UPDATE SET used_at = excluded.used_at`,
+
+	getServiceByCode: `SELECT id, code, name FROM services WHERE code = $1`,
+
+	createActivation: `
+		INSERT INTO activations (number_id, service_id, account_id, sum, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+
+	releaseNumber: `DELETE FROM number_reservations WHERE activation_id = $1`,
+
+	checkActivationOwned: `SELECT 1 FROM activations WHERE id = $1 AND account_id = $2`,
+
+	lockActivationStatus: `SELECT status FROM activations WHERE id = $1 AND account_id = $2 FOR UPDATE`,
+
+	countActiveActivations: `
+		SELECT COUNT(*) FROM activations
+		WHERE account_id = $1 AND status IN ($2, $3, $4)`,
+
+	lockAccount: `SELECT 1 FROM accounts WHERE id = $1 FOR UPDATE`,
+
+	countByStatus: `SELECT status, COUNT(*) FROM activations GROUP BY status`,
+
+	storeSMS: `
+		INSERT INTO sms_messages (activation_id, text, code, received_at)
+		VALUES ($1, $2, $3, $4)`,
+
+	getActivationByID: `
+		SELECT id, number_id, service_id, account_id, status, sum, created_at, finished_at, finish_reason
+		FROM activations WHERE id = $1`,
+
+	getActivationTarget: `
+		SELECT pn.number, srv.code
+		FROM activations a
+		JOIN phone_numbers pn ON a.number_id = pn.id
+		JOIN services srv ON a.service_id = srv.id
+		WHERE a.id = $1`,
+
+	getSMSByActivation: `
+		SELECT id, activation_id, text, COALESCE(code, ''), received_at
+		FROM sms_messages
+		WHERE activation_id = $1
+		ORDER BY received_at ASC, id ASC`,
+}
+
+// Open подключается к PostgreSQL. Схема создается миграциями (Migrate).
+func Open(config Config) (*Store, error) {
+	db, err := sql.Open("pgx", config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &Store{
+		db:       db,
+		services: make(map[string]models.Service),
+	}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// notFound переводит sql.ErrNoRows в ошибку хранилища
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+// placeholders возвращает список "$start, $start+1, ..." из n параметров
+func placeholders(start, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", start+i)
+	}
+	return strings.Join(list, ", ")
+}
+
+func (s *Store) GetAvailableServices(usedSince time.Time) (map[string]map[string]map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, queries.getAvailableServices,
+		storage.NumberQuery{UsedSince: usedSince}.UsedSinceUnix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	countryMap := make(map[string]map[string]map[string]int, 50)
+
+	for rows.Next() {
+		var country, operator, service string
+		var count int
+
+		if err := rows.Scan(&country, &operator, &service, &count); err != nil {
+			return nil, err
+		}
+
+		if countryMap[country] == nil {
+			countryMap[country] = make(map[string]map[string]int, 10)
+		}
+		if countryMap[country][operator] == nil {
+			countryMap[country][operator] = make(map[string]int, 20)
+		}
+
+		countryMap[country][operator][service] = count
+	}
+
+	return countryMap, rows.Err()
+}
+
+func (s *Store) GetServiceByCode(code string) (models.Service, error) {
+	s.servicesMu.RLock()
+	service, cached := s.services[code]
+	s.servicesMu.RUnlock()
+	if cached {
+		return service, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, queries.getServiceByCode, code).
+		Scan(&service.ID, &service.Code, &service.Name)
+	if err != nil {
+		return models.Service{}, notFound(err)
+	}
+
+	s.servicesMu.Lock()
+	s.services[code] = service
+	s.servicesMu.Unlock()
+
+	return service, nil
+}
+
+// numberFilters - необязательные условия выборки свободного номера. Выборка
+// повторяется без них, чтобы отличить отказ по цене или префиксам от пустого пула.
+type numberFilters struct {
+	prefixes bool // исключать номера с префиксами query.ExceptionPrefixes
+	price    bool // только номера, цена оператора которых не выше суммы клиента
+}
+
+// availableNumberQuery дополняет выборку свободного номера условием на цену и
+// исключениями по префиксам. Строка номера не блокируется: номер свободен или
+// занят для конкретного сервиса, и его резервирование закрепляет вставка в
+// number_reservations.
+func availableNumberQuery(query storage.NumberQuery, sum float64, filters numberFilters) (string, []interface{}) {
+	conditions, args := availableNumberConditions(query, sum, filters)
+	return queries.getAvailableNumber + conditions + `
+		ORDER BY random()
+		LIMIT 1`, args
+}
+
+// availableNumberExistsQuery проверяет наличие подходящего номера без сортировки
+// всех кандидатов
+func availableNumberExistsQuery(query storage.NumberQuery, sum float64, filters numberFilters) (string, []interface{}) {
+	conditions, args := availableNumberConditions(query, sum, filters)
+	return "SELECT EXISTS (" + queries.getAvailableNumber + conditions + "\n\t)", args
+}
+
+func availableNumberConditions(query storage.NumberQuery, sum float64, filters numberFilters) (string, []interface{}) {
+	args := []interface{}{query.Country, query.Operator, query.ServiceID, query.UsedSinceUnix()}
+
+	var sb strings.Builder
+	if filters.price {
+		// Цена оператора номера, при ее отсутствии - цена для any; номера без цены
+		// доступны при любой сумме
+		args = append(args, sum)
+		fmt.Fprintf(&sb, `
+		AND COALESCE((
+			SELECT p.price FROM prices p
+			WHERE p.country_id = pn.country_id AND p.service_id = $3
+			AND p.operator IN (pn.operator, 'any')
+			ORDER BY p.operator = 'any'
+			LIMIT 1
+		), 0) <= $%d`, len(args))
+	}
+
+	if filters.prefixes {
+		// Одно сравнение начала номера с массивом на каждую длину префикса
+		for _, group := range query.PrefixesByLength() {
+			args = append(args, group.Prefixes)
+			fmt.Fprintf(&sb, "\n\t\tAND left(pn.number::text, %d) <> ALL($%d::text[])", group.Length, len(args))
+		}
+	}
+
+	return sb.String(), args
+}
+
+// selectAvailableNumber выбирает свободный номер, подходящий по цене и префиксам.
+// Если такого нет, возвращает ErrPriceTooLow, когда мешает только цена,
+// ErrNumberExcluded, когда все номера под исключенными префиксами, и ErrNotFound
+// для пустого пула.
+func selectAvailableNumber(ctx context.Context, tx *sql.Tx, query storage.NumberQuery, sum float64, phoneNumber *models.PhoneNumber) error {
+	sqlQuery, args := availableNumberQuery(query, sum, numberFilters{prefixes: true, price: true})
+	err := tx.QueryRowContext(ctx, sqlQuery, args...).Scan(&phoneNumber.ID, &phoneNumber.Number, &phoneNumber.Operator)
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	exists, err := availableNumberExists(ctx, tx, query, sum, numberFilters{prefixes: true})
+	if err != nil {
+		return err
+	}
+	if exists {
+		return storage.ErrPriceTooLow
+	}
+
+	if len(query.UniquePrefixes()) == 0 {
+		return storage.ErrNotFound
+	}
+
+	exists, err = availableNumberExists(ctx, tx, query, sum, numberFilters{})
+	if err != nil {
+		return err
+	}
+	if exists {
+		return storage.ErrNumberExcluded
+	}
+	return storage.ErrNotFound
+}
+
+func availableNumberExists(ctx context.Context, tx *sql.Tx, query storage.NumberQuery, sum float64, filters numberFilters) (bool, error) {
+	sqlQuery, args := availableNumberExistsQuery(query, sum, filters)
+
+	var exists bool
+	err := tx.QueryRowContext(ctx, sqlQuery, args...).Scan(&exists)
+	return exists, err
+}
+
+// errReservationConflict означает, что выбранный номер успела зарезервировать для
+// того же сервиса параллельная транзакция
+var errReservationConflict = errors.New("number reserved by a concurrent transaction")
+
+// ReserveNumber повторяет резервирование, пока выбранный номер перехватывают
+// параллельные транзакции. Каждый конфликт означает, что другая транзакция
+// зафиксировала свое резервирование, поэтому повторы не зацикливаются.
+func (s *Store) ReserveNumber(query storage.NumberQuery, accountID int64, sum float64) (models.PhoneNumber, uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for {
+		phoneNumber, activationID, err := s.reserveNumber(ctx, query, accountID, sum)
+		if err != errReservationConflict {
+			return phoneNumber, activationID, err
+		}
+	}
+}
+
+func (s *Store) reserveNumber(ctx context.Context, query storage.NumberQuery, accountID int64, sum float64) (models.PhoneNumber, uint64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.PhoneNumber{}, 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkActiveLimit(ctx, tx, accountID, query.MaxActive); err != nil {
+		return models.PhoneNumber{}, 0, err
+	}
+
+	var phoneNumber models.PhoneNumber
+	if err := selectAvailableNumber(ctx, tx, query, sum, &phoneNumber); err != nil {
+		return models.PhoneNumber{}, 0, err
+	}
+
+	var activationID uint64
+	err = tx.QueryRowContext(ctx, queries.createActivation,
+		phoneNumber.ID, query.ServiceID, accountID, sum, time.Now()).Scan(&activationID)
+	if err != nil {
+		return models.PhoneNumber{}, 0, err
+	}
+
+	if err := holdFunds(ctx, tx, accountID, activationID, sum); err != nil {
+		return models.PhoneNumber{}, 0, err
+	}
+
+	// При конфликте вставка дожидается завершения параллельной транзакции
+	result, err := tx.ExecContext(ctx, queries.reserveNumber, phoneNumber.ID, query.ServiceID, activationID)
+	if err != nil {
+		return models.PhoneNumber{}, 0, err
+	}
+	if reserved, err := result.RowsAffected(); err != nil {
+		return models.PhoneNumber{}, 0, err
+	} else if reserved == 0 {
+		return models.PhoneNumber{}, 0, errReservationConflict
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.PhoneNumber{}, 0, err
+	}
+
+	return phoneNumber, activationID, nil
+}
+
+// checkActiveLimit проверяет лимит незавершенных активаций счета. Строка счета
+// блокируется до конца транзакции, чтобы параллельные резервирования одного
+// счета не прошли проверку одновременно.
+func checkActiveLimit(ctx context.Context, tx *sql.Tx, accountID int64, maxActive int) error {
+	if maxActive <= 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, queries.lockAccount, accountID); err != nil {
+		return err
+	}
+
+	var count int
+	err := tx.QueryRowContext(ctx, queries.countActiveActivations, accountID,
+		models.ActivationWaiting, models.ActivationCodeReceived, models.ActivationRetryRequested).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count >= maxActive {
+		return storage.ErrTooManyActivations
+	}
+	return nil
+}
+
+func (s *Store) TransitionActivation(accountID int64, activationID uint64, status int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := transitionActivation(ctx, tx, accountID, activationID, status); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ReceiveSMS(accountID int64, activationID uint64, text string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err := transitionActivation(ctx, tx, accountID, activationID, models.ActivationCodeReceived); err != nil {
+		return "", err
+	}
+
+	var number uint64
+	var serviceCode string
+	if err := tx.QueryRowContext(ctx, queries.getActivationTarget, activationID).Scan(&number, &serviceCode); err != nil {
+		return "", notFound(err)
+	}
+
+	code := smscode.Extract(serviceCode, text)
+
+	var storedCode interface{}
+	if code != "" {
+		storedCode = code
+	}
+
+	if _, err := tx.ExecContext(ctx, queries.storeSMS, activationID, text, storedCode, time.Now()); err != nil {
+		return "", err
+	}
+
+	return code, tx.Commit()
+}
+
+// transitionActivation выполняет переход статуса внутри транзакции tx
+func transitionActivation(ctx context.Context, tx *sql.Tx, accountID int64, activationID uint64, status int) error {
+	if !models.IsKnownActivationStatus(status) {
+		return storage.ErrBadTransition
+	}
+
+	// Прежний статус нужен, чтобы решить судьбу заблокированной суммы;
+	// блокировка строки не дает изменить его до конца транзакции
+	var from int
+	err := tx.QueryRowContext(ctx, queries.lockActivationStatus, activationID, accountID).Scan(&from)
+	if err != nil {
+		return notFound(err)
+	}
+	if !models.CanTransitionActivation(from, status) {
+		return storage.ErrBadTransition
+	}
+
+	final := models.IsFinalActivationStatus(status)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE activations
+		SET status = $1, finished_at = CASE WHEN $2 THEN $3 ELSE finished_at END
+		WHERE id = $4`,
+		status, final, time.Now(), activationID)
+	if err != nil {
+		return err
+	}
+
+	if status == models.ActivationCodeReceived || status == models.ActivationCompleted {
+		if _, err := tx.ExecContext(ctx, queries.recordNumberUsage, time.Now().Unix(), activationID); err != nil {
+			return err
+		}
+	}
+
+	if final {
+		if _, err := tx.ExecContext(ctx, queries.releaseNumber, activationID); err != nil {
+			return err
+		}
+
+		if err := settleActivation(ctx, tx, activationID, storage.SettlementKind(from, status)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) ExpireActivations(createdBefore, now time.Time, reason string) ([]storage.ExpiredActivation, error) {
+	sources := models.ActivationSourceStatuses(models.ActivationExpired)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	args := make([]interface{}, 0, len(sources)+4)
+	args = append(args, models.ActivationExpired, now, reason, createdBefore)
+	for _, source := range sources {
+		args = append(args, source)
+	}
+
+	// Подзапрос блокирует строки и возвращает статус до обновления
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE activations a
+		SET status = $1, finished_at = $2, finish_reason = $3
+		FROM (
+			SELECT id, status FROM activations
+			WHERE created_at < $4 AND status IN (`+placeholders(5, len(sources))+`)
+			FOR UPDATE
+		) old
+		WHERE a.id = old.id
+		RETURNING a.id, COALESCE(a.account_id, 0), old.status`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		expired []storage.ExpiredActivation
+		from    []int
+	)
+	for rows.Next() {
+		var (
+			activation storage.ExpiredActivation
+			status     int
+		)
+		if err := rows.Scan(&activation.ID, &activation.AccountID, &status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, activation)
+		from = append(from, status)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	for i, activation := range expired {
+		if _, err := tx.ExecContext(ctx, queries.releaseNumber, activation.ID); err != nil {
+			return nil, err
+		}
+
+		kind := storage.SettlementKind(from[i], models.ActivationExpired)
+		if err := settleActivation(ctx, tx, activation.ID, kind); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+func (s *Store) GetActivation(activationID uint64) (models.Activation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var activation models.Activation
+	err := s.db.QueryRowContext(ctx, queries.getActivationByID, activationID).Scan(
+		&activation.ID,
+		&activation.NumberID,
+		&activation.ServiceID,
+		&activation.AccountID,
+		&activation.Status,
+		&activation.Sum,
+		&activation.CreatedAt,
+		&activation.FinishedAt,
+		&activation.FinishReason,
+	)
+	if err != nil {
+		return models.Activation{}, notFound(err)
+	}
+
+	return activation, nil
+}
+
+func (s *Store) GetActivationTarget(activationID uint64) (uint64, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var number uint64
+	var serviceCode string
+	err := s.db.QueryRowContext(ctx, queries.getActivationTarget, activationID).Scan(&number, &serviceCode)
+	if err != nil {
+		return 0, "", notFound(err)
+	}
+
+	return number, serviceCode, nil
+}
+
+func (s *Store) CheckActivationOwned(activationID uint64, accountID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var exists int
+	err := s.db.QueryRowContext(ctx, queries.checkActivationOwned, activationID, accountID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Store) CountActiveActivations(accountID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, queries.countActiveActivations, accountID,
+		models.ActivationWaiting, models.ActivationCodeReceived, models.ActivationRetryRequested).Scan(&count)
+	return count, err
+}
+
+func (s *Store) CountActivationsByStatus() (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, queries.countByStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int, 6)
+	for rows.Next() {
+		var status, count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+func (s *Store) GetSMSByActivation(activationID uint64) ([]models.SMS, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, queries.getSMSByActivation, activationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.SMS
+	for rows.Next() {
+		var sms models.SMS
+		if err := rows.Scan(&sms.ID, &sms.ActivationID, &sms.Text, &sms.Code, &sms.ReceivedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, sms)
+	}
+
+	return messages, rows.Err()
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"sms-api-service/storage"
+	"sms-api-service/storage/storagetest"
+)
+
+// testDSNEnv - переменная окружения со строкой подключения к тестовому серверу;
+// без нее тесты PostgreSQL пропускаются
+const testDSNEnv = "SMS_API_TEST_POSTGRES_DSN"
+
+// newTestStore создает временную схему на сервере из testDSNEnv, применяет в
+// ней миграции и удаляет схему по завершении теста
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("sms_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	store, err := Open(Config{DSN: withSearchPath(dsn, schema), MaxOpenConns: 10, MaxIdleConns: 10})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return store
+}
+
+// withSearchPath добавляет search_path к строке подключения в виде URL или
+// списка ключ=значение
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Backend { return newTestStore(t) })
+}
+
+func TestAvailableNumberQueryPlaceholders(t *testing.T) {
+	query := storage.NumberQuery{
+		Country: "rus", Operator: "any", ServiceID: 3,
+		ExceptionPrefixes: []string{"7900", "79", "", "7901", "79"},
+	}
+
+	sqlQuery, args := availableNumberQuery(query, 12.5, numberFilters{prefixes: true, price: true})
+	want := []interface{}{"rus", "any", 3, int64(0), 12.5, []string{"79"}, []string{"7900", "7901"}}
+	if fmt.Sprint(args) != fmt.Sprint(want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+	for i := range args {
+		if !strings.Contains(sqlQuery, fmt.Sprintf("$%d", i+1)) {
+			t.Errorf("placeholder $%d is not used", i+1)
+		}
+	}
+	if strings.Contains(sqlQuery, fmt.Sprintf("$%d", len(args)+1)) {
+		t.Errorf("query references more than %d args", len(args))
+	}
+}
@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"sms-api-service/storage"
+)
+
+// Seed заполняет базу справочниками, счетами, ключами и тестовыми номерами.
+// Повторный запуск не перезаписывает существующие записи.
+func (s *Store) Seed(ctx context.Context, seedData *storage.SeedData) error {
+	for _, country := range seedData.Countries {
+		_, err := s.db.ExecContext(ctx,
+			"INSERT INTO countries (code, name, prefix) VALUES ($1, $2, $3) ON CONFLICT (code) DO NOTHING",
+			country.Code, country.Name, int64(country.Prefix))
+		if err != nil {
+			return fmt.Errorf("failed to insert country %s: %w", country.Code, err)
+		}
+	}
+
+	for _, service := range seedData.Services {
+		_, err := s.db.ExecContext(ctx,
+			"INSERT INTO services (code, name) VALUES ($1, $2) ON CONFLICT (code) DO NOTHING",
+			service.Code, service.Name)
+		if err != nil {
+			return fmt.Errorf("failed to insert service %s: %w", service.Code, err)
+		}
+	}
+
+	for _, price := range seedData.Prices {
+		_, err := s.db.ExecContext(ctx, billingQueries.seedPrice,
+			price.Operator, price.Price, time.Now(), price.Country, price.Service)
+		if err != nil {
+			return fmt.Errorf("failed to insert price %s/%s/%s: %w",
+				price.Country, price.Operator, price.Service, err)
+		}
+	}
+
+	for _, account := range seedData.Accounts {
+		accountID, created, err := ensureAccount(ctx, s.db, account.Name)
+		if err != nil {
+			return fmt.Errorf("failed to insert account %s: %w", account.Name, err)
+		}
+		if !created || account.Balance <= 0 {
+			continue
+		}
+		if err := s.deposit(ctx, accountID, account.Balance); err != nil {
+			return fmt.Errorf("failed to deposit initial balance for %s: %w", account.Name, err)
+		}
+	}
+
+	for _, key := range seedData.APIKeys {
+		_, err := s.db.ExecContext(ctx, apiKeyQueries.seedAPIKey,
+			storage.HashAPIKey(key.Key), key.Name, time.Now(), key.Account)
+		if err != nil {
+			return fmt.Errorf("failed to insert api key %s: %w", key.Name, err)
+		}
+	}
+
+	if err := s.generateTestNumbers(ctx, seedData); err != nil {
+		return fmt.Errorf("failed to generate test numbers: %w", err)
+	}
+
+	log.Print("Data seeded successfully")
+	return nil
+}
+
+// generateTestNumbers добавляет каждой стране с заданным префиксом случайное число
+// номеров, распределенных между операторами страны
+func (s *Store) generateTestNumbers(ctx context.Context, seedData *storage.SeedData) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, code, prefix FROM countries WHERE prefix > 0")
+	if err != nil {
+		return fmt.Errorf("failed to get countries: %w", err)
+	}
+
+	type seedCountry struct {
+		id     int64
+		code   string
+		prefix uint64
+	}
+
+	var countries []seedCountry
+	for rows.Next() {
+		var (
+			country seedCountry
+			prefix  int64
+		)
+		if err := rows.Scan(&country.id, &country.code, &prefix); err != nil {
+			rows.Close()
+			return err
+		}
+		country.prefix = uint64(prefix)
+		countries = append(countries, country)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	const batchSize = 100
+
+	minCount, maxCount := seedData.NumbersRange.Min, seedData.NumbersRange.Max
+	for _, country := range countries {
+		count := rand.Intn(maxCount-minCount+1) + minCount
+		operators := seedData.Operators[country.code]
+		if len(operators) == 0 {
+			log.Printf("No operators for country %s, skipping test numbers", country.code)
+			continue
+		}
+
+		for start := 0; start < count; start += batchSize {
+			end := start + batchSize
+			if end > count {
+				end = count
+			}
+
+			if err := s.insertNumbersBatch(ctx, country.id, country.prefix, operators, end-start); err != nil {
+				return fmt.Errorf("failed to insert numbers for country %s: %w", country.code, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// insertNumbersBatch вставляет батч номеров одним запросом
+func (s *Store) insertNumbersBatch(ctx context.Context, countryID int64, prefix uint64, operators []string, count int) error {
+	if count <= 0 {
+		return nil
+	}
+
+	values := make([]string, count)
+	args := make([]interface{}, 0, count*3)
+	for i := range values {
+		args = append(args, int64(storage.GeneratePhoneNumber(prefix)), countryID, storage.RandomOperator(operators))
+		values[i] = fmt.Sprintf("($%d, $%d, $%d, TRUE)", len(args)-2, len(args)-1, len(args))
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO phone_numbers (number, country_id, operator, available)
+		VALUES `+strings.Join(values, ", ")+`
+		ON CONFLICT (number) DO NOTHING`, args...)
+	return err
+}
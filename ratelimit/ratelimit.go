@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// cleanupInterval - как часто Allow удаляет корзины простаивающих ключей
+const cleanupInterval = time.Minute
+
+// Limiter хранит token bucket для каждого API-ключа
+type Limiter struct {
+	mu          sync.Mutex
+	buckets     map[int64]*bucket
+	lastCleanup time.Time
+
+	// Now возвращает текущее время; подменяется в тестах
+	Now func() time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	updated  time.Time
+	rate     float64
+	capacity float64
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets: make(map[int64]*bucket),
+		Now:     time.Now,
+	}
+}
+
+// Allow списывает один токен из корзины ключа. rate - токенов в секунду,
+// burst - емкость корзины. Неположительный rate отключает ограничение.
+func (l *Limiter) Allow(keyID int64, rate float64, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	now := l.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) >= cleanupInterval {
+		l.cleanup(now)
+		l.lastCleanup = now
+	}
+
+	b, exists := l.buckets[keyID]
+	if !exists || b.rate != rate || b.capacity != float64(burst) {
+		b = &bucket{
+			tokens:   float64(burst),
+			updated:  now,
+			rate:     rate,
+			capacity: float64(burst),
+		}
+		l.buckets[keyID] = b
+	}
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.updated = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// cleanup удаляет корзины, которые к моменту now наполнились до емкости: такая
+// корзина ничем не отличается от новой, и без удаления карта росла бы с каждым
+// ключом, когда-либо делавшим запросы
+func (l *Limiter) cleanup(now time.Time) {
+	for keyID, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.rate >= b.capacity {
+			delete(l.buckets, keyID)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock - время, которое тест передвигает вручную
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestLimiter() (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := New()
+	l.Now = clock.Now
+	return l, clock
+}
+
+func TestAllow(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		keyID   int64
+		want    bool
+	}
+
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{
+			name: "burst cap", rate: 1, burst: 3,
+			steps: []step{
+				{0, 1, true}, {0, 1, true}, {0, 1, true}, {0, 1, false},
+				// За минуту простоя корзина наполняется не больше чем до burst
+				{time.Minute, 1, true}, {0, 1, true}, {0, 1, true}, {0, 1, false},
+			},
+		},
+		{
+			name: "refill rate", rate: 2, burst: 1,
+			steps: []step{
+				{0, 1, true}, {0, 1, false},
+				{250 * time.Millisecond, 1, false},
+				{250 * time.Millisecond, 1, true},
+				{400 * time.Millisecond, 1, false},
+				{100 * time.Millisecond, 1, true},
+			},
+		},
+		{
+			name: "fractional rate", rate: 0.1, burst: 1,
+			steps: []step{
+				{0, 1, true}, {9 * time.Second, 1, false}, {time.Second, 1, true},
+			},
+		},
+		{
+			name: "per-key isolation", rate: 1, burst: 2,
+			steps: []step{
+				{0, 1, true}, {0, 1, true}, {0, 1, false},
+				{0, 2, true}, {0, 2, true}, {0, 2, false},
+				{time.Second, 1, true}, {0, 1, false}, {0, 2, true},
+			},
+		},
+		{
+			name: "zero burst allows one request", rate: 1, burst: 0,
+			steps: []step{{0, 1, true}, {0, 1, false}, {time.Second, 1, true}},
+		},
+		{
+			name: "disabled", rate: 0, burst: 1,
+			steps: []step{{0, 1, true}, {0, 1, true}, {0, 1, true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter()
+			for i, step := range tt.steps {
+				clock.Advance(step.advance)
+				if got := l.Allow(step.keyID, tt.rate, tt.burst); got != step.want {
+					t.Fatalf("step %d (key %d): Allow = %v, want %v", i, step.keyID, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowResetsBucketOnLimitChange(t *testing.T) {
+	l, _ := newTestLimiter()
+
+	if !l.Allow(1, 1, 1) || l.Allow(1, 1, 1) {
+		t.Fatal("bucket of 1 token should allow exactly one request")
+	}
+	// Новые лимиты ключа применяются сразу, с полной корзиной
+	if !l.Allow(1, 1, 2) || !l.Allow(1, 1, 2) || l.Allow(1, 1, 2) {
+		t.Error("bucket was not recreated with the new burst")
+	}
+}
+
+func TestIdleBucketsAreRemoved(t *testing.T) {
+	l, clock := newTestLimiter()
+
+	// Ключ 1 тратит один токен из 10, ключ 2 - всю корзину с медленным пополнением
+	l.Allow(1, 1, 10)
+	for i := 0; i < 5; i++ {
+		l.Allow(2, 0.001, 5)
+	}
+
+	clock.Advance(cleanupInterval)
+	l.Allow(3, 1, 1)
+
+	if _, ok := l.buckets[1]; ok {
+		t.Error("refilled bucket of key 1 was not removed")
+	}
+	if _, ok := l.buckets[2]; !ok {
+		t.Error("bucket of key 2 is not full yet and must be kept")
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("%d buckets, want keys 2 and 3", len(l.buckets))
+	}
+
+	// Удаленная корзина не дает лишних токенов: новая начинается с burst
+	for i := 0; i < 10; i++ {
+		if !l.Allow(1, 1, 10) {
+			t.Fatalf("request %d of key 1 was limited", i+1)
+		}
+	}
+	if l.Allow(1, 1, 10) {
+		t.Error("key 1 exceeded its burst after cleanup")
+	}
+
+	// Очистка выполняется не чаще раза в cleanupInterval
+	clock.Advance(cleanupInterval / 2)
+	l.Allow(4, 1, 1)
+	if _, ok := l.buckets[3]; !ok {
+		t.Error("cleanup ran before cleanupInterval elapsed")
+	}
+}
@@ -0,0 +1,80 @@
+package reaper
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sms-api-service/hub"
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+// ExpireReason записывается в активацию, закрытую по истечении TTL
+const ExpireReason = "ttl_expired"
+
+// Reaper периодически переводит зависшие активации в статус ActivationExpired
+// и возвращает их номера в пул
+type Reaper struct {
+	store    storage.Store
+	hub      *hub.Hub
+	ttl      time.Duration
+	interval time.Duration
+
+	// Now возвращает текущее время; подменяется в тестах
+	Now func() time.Time
+}
+
+// New создает сборщик с системными часами
+func New(store storage.Store, smsHub *hub.Hub, ttl, interval time.Duration) *Reaper {
+	return &Reaper{
+		store:    store,
+		hub:      smsHub,
+		ttl:      ttl,
+		interval: interval,
+		Now:      time.Now,
+	}
+}
+
+// Run запускает проходы сборщика до отмены контекста
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := r.RunOnce(); err != nil {
+				log.Printf("Failed to expire activations: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunOnce истекает активации старше TTL и возвращает их
+func (r *Reaper) RunOnce() ([]storage.ExpiredActivation, error) {
+	now := r.Now()
+
+	expired, err := r.store.ExpireActivations(now.Add(-r.ttl), now, ExpireReason)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, activation := range expired {
+		r.hub.Publish(hub.Event{
+			Type:         hub.EventActivationFinished,
+			ActivationID: activation.ID,
+			AccountID:    activation.AccountID,
+			Status:       models.ActivationExpired,
+			Time:         now,
+		})
+	}
+
+	if len(expired) > 0 {
+		log.Printf("Expired %d stale activations", len(expired))
+	}
+
+	return expired, nil
+}
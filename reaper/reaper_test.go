@@ -0,0 +1,148 @@
+package reaper
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sms-api-service/database"
+	"sms-api-service/hub"
+	"sms-api-service/memory"
+	"sms-api-service/models"
+	"sms-api-service/storage"
+	"sms-api-service/storage/storagetest"
+)
+
+const testTTL = 20 * time.Minute
+
+// backends возвращает фабрики хранилищ, на которых проверяется сборщик
+func backends() map[string]storagetest.Factory {
+	return map[string]storagetest.Factory{
+		"memory": func(*testing.T) storage.Backend { return memory.New() },
+		"sqlite": func(t *testing.T) storage.Backend {
+			db, err := database.Init(database.DefaultConfig(filepath.Join(t.TempDir(), "sms.db")))
+			if err != nil {
+				t.Fatalf("init sqlite: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if err := db.Migrate(context.Background()); err != nil {
+				t.Fatalf("migrate sqlite: %v", err)
+			}
+			return db
+		},
+	}
+}
+
+// reserve создает storagetest.Fixture с одним номером и резервирует его для tg
+// за 10 со счета с балансом 100
+func reserve(t *testing.T, newBackend storagetest.Factory) (*storagetest.Fixture, uint64) {
+	t.Helper()
+
+	f := storagetest.NewFixture(t, newBackend, "mts")
+	_, activationID, err := f.Store.ReserveNumber(f.TG, f.AccountID, 10)
+	if err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	return f, activationID
+}
+
+func TestRunOnceExpiresActivationsPastTTL(t *testing.T) {
+	for name, newBackend := range backends() {
+		t.Run(name, func(t *testing.T) {
+			f, activationID := reserve(t, newBackend)
+			store, accountID := f.Store, f.AccountID
+
+			smsHub := hub.New()
+			events, unsubscribe := smsHub.SubscribeEvents()
+			defer unsubscribe()
+
+			reaper := New(store, smsHub, testTTL, time.Minute)
+			start := time.Now()
+
+			reaper.Now = func() time.Time { return start.Add(testTTL - time.Minute) }
+			expired, err := reaper.RunOnce()
+			if err != nil || len(expired) != 0 {
+				t.Fatalf("before TTL: expired %v, err %v; want nothing", expired, err)
+			}
+
+			reaper.Now = func() time.Time { return start.Add(testTTL + time.Minute) }
+			expired, err = reaper.RunOnce()
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if len(expired) != 1 || expired[0].ID != activationID || expired[0].AccountID != accountID {
+				t.Fatalf("expired = %v, want activation %d of account %d", expired, activationID, accountID)
+			}
+
+			activation, err := store.GetActivation(activationID)
+			if err != nil {
+				t.Fatalf("get activation: %v", err)
+			}
+			if activation.Status != models.ActivationExpired {
+				t.Errorf("status = %d, want %d", activation.Status, models.ActivationExpired)
+			}
+			if activation.FinishReason == nil || *activation.FinishReason != ExpireReason {
+				t.Errorf("finish reason = %v, want %q", activation.FinishReason, ExpireReason)
+			}
+			if activation.FinishedAt == nil {
+				t.Error("finished_at is not set")
+			}
+
+			account, err := store.GetAccount(accountID)
+			if err != nil {
+				t.Fatalf("get account: %v", err)
+			}
+			if account.Balance != 100 || account.Held != 0 {
+				t.Errorf("balance %v, held %v; want the hold refunded", account.Balance, account.Held)
+			}
+
+			select {
+			case event := <-events:
+				if event.Type != hub.EventActivationFinished || event.ActivationID != activationID ||
+					event.Status != models.ActivationExpired {
+					t.Errorf("unexpected event %+v", event)
+				}
+			default:
+				t.Error("no event published for the expired activation")
+			}
+
+			if expired, err := reaper.RunOnce(); err != nil || len(expired) != 0 {
+				t.Errorf("second run: expired %v, err %v; want nothing", expired, err)
+			}
+
+			if _, _, err := store.ReserveNumber(f.TG, accountID, 10); err != nil {
+				t.Errorf("number was not released: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunOnceSkipsFinishedActivations(t *testing.T) {
+	for name, newBackend := range backends() {
+		t.Run(name, func(t *testing.T) {
+			f, activationID := reserve(t, newBackend)
+			store, accountID := f.Store, f.AccountID
+
+			if err := store.TransitionActivation(accountID, activationID, models.ActivationCancelled); err != nil {
+				t.Fatalf("cancel: %v", err)
+			}
+
+			reaper := New(store, hub.New(), testTTL, time.Minute)
+			reaper.Now = func() time.Time { return time.Now().Add(testTTL + time.Minute) }
+
+			expired, err := reaper.RunOnce()
+			if err != nil || len(expired) != 0 {
+				t.Fatalf("expired %v, err %v; want nothing", expired, err)
+			}
+
+			activation, err := store.GetActivation(activationID)
+			if err != nil {
+				t.Fatalf("get activation: %v", err)
+			}
+			if activation.Status != models.ActivationCancelled {
+				t.Errorf("status = %d, want %d", activation.Status, models.ActivationCancelled)
+			}
+		})
+	}
+}
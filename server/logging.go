@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"sms-api-service/handlers"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// newRequestID возвращает случайный идентификатор запроса
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// redactKey оставляет от ключа только начало, достаточное для поиска в журнале
+func redactKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return "***"
+	}
+	return key[:4] + "***"
+}
+
+// logRequest пишет итоговую запись журнала по API-запросу
+func logRequest(info *handlers.RequestInfo, action, key, status string, duration time.Duration) {
+	attrs := []slog.Attr{
+		slog.String("action", action),
+		slog.String("key", key),
+		slog.String("status", status),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+	}
+	if info.ActivationID != 0 {
+		attrs = append(attrs, slog.Uint64("activation_id", info.ActivationID))
+	}
+
+	level := slog.LevelInfo
+	if status == "DATABASE_ERROR" {
+		level = slog.LevelError
+	}
+	info.Logger.LogAttrs(context.Background(), level, "api request", attrs...)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+)
+
+var statusPrefix = []byte(`{"status":"`)
+
+// responseRecorder запоминает статус ответа API (поле "status" в начале JSON) для метрик
+type responseRecorder struct {
+	http.ResponseWriter
+	status string
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: "NO_RESPONSE"}
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == "NO_RESPONSE" {
+		r.status = "UNKNOWN"
+		if bytes.HasPrefix(p, statusPrefix) {
+			rest := p[len(statusPrefix):]
+			if end := bytes.IndexByte(rest, '"'); end >= 0 {
+				r.status = string(rest[:end])
+			}
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == "NO_RESPONSE" && code >= http.StatusBadRequest {
+		r.status = http.StatusText(code)
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
@@ -2,14 +2,20 @@ package server
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
 	"sync"
+	"time"
 
 	cfg "sms-api-service/config"
 	"sms-api-service/handlers"
+	"sms-api-service/hub"
+	"sms-api-service/metrics"
+	"sms-api-service/models"
+	"sms-api-service/ratelimit"
+	"sms-api-service/storage"
 	"sms-api-service/types"
 )
 
@@ -27,27 +33,45 @@ var (
 	}
 
 	errorResponses = map[string][]byte{
-		"INVALID_REQUEST": []byte(`{"status":"INVALID_REQUEST"}`),
-		"INVALID_KEY":     []byte(`{"status":"INVALID_KEY"}`),
-		"INVALID_ACTION":  []byte(`{"status":"INVALID_ACTION"}`),
+		"INVALID_REQUEST":      []byte(`{"status":"INVALID_REQUEST"}`),
+		"INVALID_KEY":          []byte(`{"status":"INVALID_KEY"}`),
+		"INVALID_ACTION":       []byte(`{"status":"INVALID_ACTION"}`),
+		"DATABASE_ERROR":       []byte(`{"status":"DATABASE_ERROR"}`),
+		"RATE_LIMITED":         []byte(`{"status":"RATE_LIMITED"}`),
+		"TOO_MANY_ACTIVATIONS": []byte(`{"status":"TOO_MANY_ACTIVATIONS"}`),
 	}
 
 	jsonContentType = []byte("application/json; charset=utf-8")
+
+	knownActions = map[string]bool{
+		"GET_NUMBER":        true,
+		"PUSH_SMS":          true,
+		"FINISH_ACTIVATION": true,
+		"GET_SERVICES":      true,
+		"GET_STATUS":        true,
+		"WAIT_SMS":          true,
+		"GET_BALANCE":       true,
+		"GET_PRICES":        true,
+	}
 )
 
+const eventsKeepAliveInterval = 10 * time.Second
+
 type Server struct {
-	db      *sql.DB
+	store   storage.Store
 	config  cfg.Config
 	handler *handlers.Handler
-	apiKey  []byte
+	hub     *hub.Hub
+	limiter *ratelimit.Limiter
 }
 
-func New(db *sql.DB, config cfg.Config) *Server {
+func New(store storage.Store, config cfg.Config, smsHub *hub.Hub) *Server {
 	return &Server{
-		db:      db,
+		store:   store,
 		config:  config,
-		handler: handlers.New(db, config),
-		apiKey:  []byte(config.APIKey),
+		handler: handlers.New(store, config, smsHub),
+		hub:     smsHub,
+		limiter: ratelimit.New(),
 	}
 }
 
@@ -61,6 +85,23 @@ func (s *Server) HandleAPIRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	start := time.Now()
+	rec := newResponseRecorder(w)
+	w = rec
+	action, key := "UNKNOWN", ""
+
+	requestID := newRequestID()
+	w.Header().Set(requestIDHeader, requestID)
+	info := &handlers.RequestInfo{
+		ID:     requestID,
+		Logger: slog.Default().With(slog.String("request_id", requestID)),
+	}
+	defer func() {
+		duration := time.Since(start)
+		metrics.ObserveRequest(action, rec.status, duration)
+		logRequest(info, action, key, rec.status, duration)
+	}()
+
 	buf := bytesBufferPool.Get().([]byte)
 	defer func() {
 		buf = buf[:0]
@@ -83,12 +124,21 @@ func (s *Server) HandleAPIRequest(w http.ResponseWriter, r *http.Request) {
 		s.sendErrorResponseFast(w, "INVALID_REQUEST")
 		return
 	}
+	if knownActions[baseReq.Action] {
+		action = baseReq.Action
+	}
+	key = redactKey(baseReq.Key)
 
-	if !bytes.Equal([]byte(baseReq.Key), s.apiKey) {
-		s.sendErrorResponseFast(w, "INVALID_KEY")
+	apiKey, ok := s.authenticate(w, baseReq.Key)
+	if !ok {
 		return
 	}
 
+	if !s.checkLimits(w, apiKey) {
+		return
+	}
+
+	r = r.WithContext(handlers.WithRequestInfo(handlers.WithAPIKey(r.Context(), apiKey), info))
 	r.Body = io.NopCloser(bytes.NewReader(body))
 
 	switch baseReq.Action {
@@ -100,11 +150,119 @@ func (s *Server) HandleAPIRequest(w http.ResponseWriter, r *http.Request) {
 		s.handler.HandleFinishActivation(w, r)
 	case "GET_SERVICES":
 		s.handler.HandleGetServices(w)
+	case "GET_STATUS":
+		s.handler.HandleGetStatus(w, r)
+	case "WAIT_SMS":
+		s.handler.HandleWaitSMS(w, r)
+	case "GET_BALANCE":
+		s.handler.HandleGetBalance(w, r)
+	case "GET_PRICES":
+		s.handler.HandleGetPrices(w)
 	default:
 		s.sendErrorResponseFast(w, "INVALID_ACTION")
 	}
 }
 
+func (s *Server) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Ключ принимается только в заголовке: параметр URL попадает в журналы
+	// прокси и балансировщиков
+	apiKey, ok := s.authenticate(w, r.Header.Get("X-API-Key"))
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Поток живет дольше WriteTimeout сервера
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	events, unsubscribe := s.hub.SubscribeEvents()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.AccountID != apiKey.AccountID {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+
+			if _, err := w.Write([]byte("event: " + event.Type + "\ndata: ")); err != nil {
+				return
+			}
+			w.Write(data)
+			w.Write([]byte("\n\n"))
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// authenticate проверяет ключ клиента и сам отвечает на запрос при отказе
+func (s *Server) authenticate(w http.ResponseWriter, key string) (*models.APIKey, bool) {
+	apiKey, err := s.store.AuthenticateAPIKey(key, time.Now())
+	if err == nil {
+		return &apiKey, true
+	}
+
+	if err == storage.ErrInvalidKey {
+		s.sendErrorResponseFast(w, "INVALID_KEY")
+	} else {
+		s.sendErrorResponseFast(w, "DATABASE_ERROR")
+	}
+	return nil, false
+}
+
+// checkLimits применяет лимит частоты запросов ключа. Лимит активных активаций
+// проверяет GET_NUMBER вместе с резервированием номера.
+func (s *Server) checkLimits(w http.ResponseWriter, apiKey *models.APIKey) bool {
+	rate, burst := s.config.RateLimit, s.config.RateBurst
+	if apiKey.RateLimit != nil {
+		rate = *apiKey.RateLimit
+	}
+	if apiKey.RateBurst != nil {
+		burst = *apiKey.RateBurst
+	}
+
+	if !s.limiter.Allow(apiKey.ID, rate, burst) {
+		s.sendErrorResponseFast(w, "RATE_LIMITED")
+		return false
+	}
+
+	return true
+}
+
 func (s *Server) sendErrorResponseFast(w http.ResponseWriter, errorType string) {
 	w.Header().Set("Content-Type", string(jsonContentType))
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,521 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cfg "sms-api-service/config"
+	"sms-api-service/hub"
+	"sms-api-service/memory"
+	"sms-api-service/models"
+	"sms-api-service/storage"
+	"sms-api-service/storage/storagetest"
+	"sms-api-service/types"
+)
+
+var errStoreDown = errors.New("store is down")
+
+// failingStore имитирует сбой хранилища при проверке ключа или резервировании номера
+type failingStore struct {
+	storage.Backend
+	failAuth bool
+}
+
+func (s failingStore) AuthenticateAPIKey(key string, now time.Time) (models.APIKey, error) {
+	if s.failAuth {
+		return models.APIKey{}, errStoreDown
+	}
+	return s.Backend.AuthenticateAPIKey(key, now)
+}
+
+func (s failingStore) ReserveNumber(query storage.NumberQuery, accountID int64, sum float64) (models.PhoneNumber, uint64, error) {
+	return models.PhoneNumber{}, 0, errStoreDown
+}
+
+type testEnv struct {
+	server *Server
+	store  storage.Backend
+	key    string
+}
+
+// newTestEnv создает сервер поверх хранилища в памяти с данными
+// storagetest.Fixture, номерами операторов mts, mts и beeline и ценой tg 10.
+// Лимит частоты по умолчанию отключен; configure может изменить конфигурацию.
+func newTestEnv(t *testing.T, configure func(*cfg.Config)) *testEnv {
+	t.Helper()
+
+	f := storagetest.NewFixture(t, func(*testing.T) storage.Backend { return memory.New() }, "mts", "mts", "beeline")
+	f.SetPrices(t, storage.Price{Country: "rus", Operator: "any", Service: "tg", Price: 10})
+
+	config := cfg.Default()
+	config.RateLimit = 0
+	if configure != nil {
+		configure(&config)
+	}
+
+	return &testEnv{
+		server: New(f.Store, config, hub.New()),
+		store:  f.Store,
+		key:    f.Key,
+	}
+}
+
+// serve отправляет запрос action с полями fields от имени ключа окружения
+func (e *testEnv) serve(action, fields string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"action":%q,"key":%q`, action, e.key)
+	if fields != "" {
+		body += "," + fields
+	}
+	return e.serveBody(body + "}")
+}
+
+func (e *testEnv) serveBody(body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	e.server.HandleAPIRequest(w, r)
+	return w
+}
+
+// call выполняет запрос и разбирает JSON-ответ в out
+func (e *testEnv) call(t *testing.T, action, fields string, out interface{}) {
+	t.Helper()
+
+	w := e.serve(action, fields)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: HTTP status %d", action, w.Code)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		t.Fatalf("%s: decode %q: %v", action, w.Body.String(), err)
+	}
+}
+
+func (e *testEnv) status(t *testing.T, action, fields string) string {
+	t.Helper()
+
+	var resp types.BaseResponse
+	e.call(t, action, fields, &resp)
+	return resp.Status
+}
+
+func (e *testEnv) getNumber(t *testing.T, fields string) types.GetNumberResponse {
+	t.Helper()
+
+	var resp types.GetNumberResponse
+	e.call(t, "GET_NUMBER", fields, &resp)
+	return resp
+}
+
+// reserve выдает номер tg и завершает тест при отказе
+func (e *testEnv) reserve(t *testing.T) types.GetNumberResponse {
+	t.Helper()
+
+	resp := e.getNumber(t, `"country":"rus","operator":"any","service":"tg","sum":10`)
+	if resp.Status != "SUCCESS" {
+		t.Fatalf("get number: status %s", resp.Status)
+	}
+	return resp
+}
+
+func TestGetServices(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.reserve(t)
+
+	var resp types.GetServicesResponse
+	env.call(t, "GET_SERVICES", "", &resp)
+	if resp.Status != "SUCCESS" || len(resp.CountryList) != 1 || resp.CountryList[0].Country != "rus" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	operators := resp.CountryList[0].OperatorMap
+	if operators["any"]["tg"] != 2 || operators["any"]["wa"] != 3 {
+		t.Errorf("any: tg %d, wa %d; want 2 and 3", operators["any"]["tg"], operators["any"]["wa"])
+	}
+	if operators["beeline"]["wa"] != 1 || operators["mts"]["wa"] != 2 {
+		t.Errorf("unexpected operator counts %v", operators)
+	}
+}
+
+func TestGetNumber(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	resp := env.getNumber(t, `"country":"rus","operator":"beeline","service":"tg","sum":10`)
+	if resp.Status != "SUCCESS" || resp.Number != 79000000003 || resp.ActivationId == 0 {
+		t.Fatalf("beeline: unexpected response %+v", resp)
+	}
+
+	activation, err := env.store.GetActivation(resp.ActivationId)
+	if err != nil || activation.Status != models.ActivationWaiting || activation.Sum != 10 {
+		t.Errorf("activation %+v, err %v", activation, err)
+	}
+
+	var balance types.GetBalanceResponse
+	env.call(t, "GET_BALANCE", "", &balance)
+	if balance.Balance != 90 || balance.Held != 10 {
+		t.Errorf("balance %v, held %v; want 90 and 10", balance.Balance, balance.Held)
+	}
+}
+
+func TestGetNumberWithExceptionPhoneSet(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	resp := env.getNumber(t, `"country":"rus","operator":"any","service":"tg","sum":10,`+
+		`"exceptionPhoneSet":["79000000001","","79000000002"]`)
+	if resp.Status != "SUCCESS" || resp.Number != 79000000003 {
+		t.Fatalf("got %+v, want the only number outside the excluded prefixes", resp)
+	}
+
+	excluded := `"country":"rus","operator":"any","service":"tg","sum":10,"exceptionPhoneSet":["7900"]`
+	if resp := env.getNumber(t, excluded); resp.Status != "NO_NUMBERS2" {
+		t.Errorf("all excluded: status %s, want NO_NUMBERS2", resp.Status)
+	}
+
+	// Пустой префикс ничего не исключает
+	if resp := env.getNumber(t, `"country":"rus","operator":"mts","service":"tg","sum":10,"exceptionPhoneSet":[""]`); resp.Status != "SUCCESS" {
+		t.Errorf("empty prefix: status %s, want SUCCESS", resp.Status)
+	}
+}
+
+func TestPushSMSAndFinishActivation(t *testing.T) {
+	env := newTestEnv(t, nil)
+	number := env.reserve(t)
+	activation := fmt.Sprintf(`"activationId":%d`, number.ActivationId)
+
+	if status := env.status(t, "PUSH_SMS", activation+`,"sms":"Telegram code: 52817"`); status != "SUCCESS" {
+		t.Fatalf("push sms: status %s", status)
+	}
+
+	var resp types.GetStatusResponse
+	env.call(t, "GET_STATUS", activation, &resp)
+	if resp.ActivationStatus != models.ActivationCodeReceived || resp.Code != "52817" || resp.Number != number.Number ||
+		resp.Service != "tg" || len(resp.SMS) != 1 {
+		t.Errorf("unexpected status %+v", resp)
+	}
+
+	if status := env.status(t, "FINISH_ACTIVATION", activation+`,"status":3`); status != "SUCCESS" {
+		t.Fatalf("finish: status %s", status)
+	}
+
+	env.call(t, "GET_STATUS", activation, &resp)
+	if resp.ActivationStatus != models.ActivationCompleted {
+		t.Errorf("activation status %d, want %d", resp.ActivationStatus, models.ActivationCompleted)
+	}
+
+	var balance types.GetBalanceResponse
+	env.call(t, "GET_BALANCE", "", &balance)
+	if balance.Balance != 90 || balance.Held != 0 {
+		t.Errorf("balance %v, held %v; want the sum captured", balance.Balance, balance.Held)
+	}
+}
+
+// handlerStatuses - статусы из handlers.cachedResponses, которые должен покрыть
+// TestResponseStatuses
+var handlerStatuses = []string{
+	"NO_NUMBERS1", "NO_NUMBERS2", "INVALID_SERVICE", "DATABASE_ERROR", "INVALID_REQUEST",
+	"ACTIVATION_NOT_FOUND", "BAD_STATUS", "NO_BALANCE", "LOW_PRICE", "TOO_MANY_ACTIVATIONS", "SUCCESS",
+}
+
+func TestResponseStatuses(t *testing.T) {
+	const anyTG = `"country":"rus","operator":"any","service":"tg"`
+
+	tests := []struct {
+		name      string
+		configure func(*cfg.Config)
+		// wrap подменяет хранилище сервера
+		wrap func(storage.Backend) storage.Store
+		// prepare выполняет предварительные запросы и возвращает поля проверяемого
+		prepare func(t *testing.T, env *testEnv) string
+		action  string
+		fields  string
+		body    string // тело запроса целиком вместо action и fields
+		want    string
+	}{
+		{name: "invalid json", body: `{"action":`, want: "INVALID_REQUEST"},
+		{name: "invalid key", body: `{"action":"GET_SERVICES","key":"wrong"}`, want: "INVALID_KEY"},
+		{name: "unknown action", action: "GET_SOMETHING", want: "INVALID_ACTION"},
+		{
+			name:   "store down on auth",
+			wrap:   func(s storage.Backend) storage.Store { return failingStore{Backend: s, failAuth: true} },
+			action: "GET_SERVICES", want: "DATABASE_ERROR",
+		},
+		{
+			name:      "rate limited",
+			configure: func(c *cfg.Config) { c.RateLimit, c.RateBurst = 0.001, 1 },
+			prepare: func(t *testing.T, env *testEnv) string {
+				env.status(t, "GET_SERVICES", "")
+				return ""
+			},
+			action: "GET_SERVICES", want: "RATE_LIMITED",
+		},
+		{name: "services", action: "GET_SERVICES", want: "SUCCESS"},
+
+		{name: "get number", action: "GET_NUMBER", fields: anyTG + `,"sum":10`, want: "SUCCESS"},
+		{name: "negative sum", action: "GET_NUMBER", fields: anyTG + `,"sum":-1`, want: "INVALID_REQUEST"},
+		{name: "unknown service", action: "GET_NUMBER", fields: `"country":"rus","operator":"any","service":"xx","sum":10`, want: "INVALID_SERVICE"},
+		{name: "unknown operator", action: "GET_NUMBER", fields: `"country":"rus","operator":"tele2","service":"tg","sum":10`, want: "NO_NUMBERS1"},
+		{name: "all excluded", action: "GET_NUMBER", fields: anyTG + `,"sum":10,"exceptionPhoneSet":["79"]`, want: "NO_NUMBERS2"},
+		{name: "low price", action: "GET_NUMBER", fields: anyTG + `,"sum":5`, want: "LOW_PRICE"},
+		{name: "no balance", action: "GET_NUMBER", fields: anyTG + `,"sum":1000`, want: "NO_BALANCE"},
+		{
+			name:      "too many activations",
+			configure: func(c *cfg.Config) { c.MaxActiveActivations = 1 },
+			prepare: func(t *testing.T, env *testEnv) string {
+				env.reserve(t)
+				return anyTG + `,"sum":10`
+			},
+			action: "GET_NUMBER", want: "TOO_MANY_ACTIVATIONS",
+		},
+		{
+			name:   "store down on reserve",
+			wrap:   func(s storage.Backend) storage.Store { return failingStore{Backend: s} },
+			action: "GET_NUMBER", fields: anyTG + `,"sum":10`, want: "DATABASE_ERROR",
+		},
+
+		{name: "sms for unknown activation", action: "PUSH_SMS", fields: `"activationId":999,"sms":"Code: 1234"`, want: "ACTIVATION_NOT_FOUND"},
+		{name: "malformed sms", action: "PUSH_SMS", fields: `"activationId":"x"`, want: "INVALID_REQUEST"},
+		{
+			name: "sms for cancelled activation",
+			prepare: func(t *testing.T, env *testEnv) string {
+				activation := fmt.Sprintf(`"activationId":%d`, env.reserve(t).ActivationId)
+				env.status(t, "FINISH_ACTIVATION", activation+`,"status":4`)
+				return activation + `,"sms":"Code: 1234"`
+			},
+			action: "PUSH_SMS", want: "BAD_STATUS",
+		},
+
+		{name: "finish unknown activation", action: "FINISH_ACTIVATION", fields: `"activationId":999,"status":4`, want: "ACTIVATION_NOT_FOUND"},
+		{
+			name: "finish with server status",
+			prepare: func(t *testing.T, env *testEnv) string {
+				return fmt.Sprintf(`"activationId":%d,"status":1`, env.reserve(t).ActivationId)
+			},
+			action: "FINISH_ACTIVATION", want: "BAD_STATUS",
+		},
+		{
+			name: "complete without sms",
+			prepare: func(t *testing.T, env *testEnv) string {
+				return fmt.Sprintf(`"activationId":%d,"status":3`, env.reserve(t).ActivationId)
+			},
+			action: "FINISH_ACTIVATION", want: "BAD_STATUS",
+		},
+		{
+			name: "cancel",
+			prepare: func(t *testing.T, env *testEnv) string {
+				return fmt.Sprintf(`"activationId":%d,"status":4`, env.reserve(t).ActivationId)
+			},
+			action: "FINISH_ACTIVATION", want: "SUCCESS",
+		},
+	}
+
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		covered[tt.want] = true
+
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, tt.configure)
+			if tt.wrap != nil {
+				env.server = New(tt.wrap(env.store), env.server.config, env.server.hub)
+			}
+
+			fields := tt.fields
+			if tt.prepare != nil {
+				fields = tt.prepare(t, env)
+			}
+
+			var w *httptest.ResponseRecorder
+			if tt.body != "" {
+				w = env.serveBody(tt.body)
+			} else {
+				w = env.serve(tt.action, fields)
+			}
+
+			var resp types.BaseResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode %q: %v", w.Body.String(), err)
+			}
+			if w.Code != http.StatusOK || resp.Status != tt.want {
+				t.Errorf("HTTP %d, status %s; want %s", w.Code, resp.Status, tt.want)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != string(jsonContentType) {
+				t.Errorf("Content-Type = %q", contentType)
+			}
+		})
+	}
+
+	for status := range errorResponses {
+		if !covered[status] {
+			t.Errorf("server status %s is not covered", status)
+		}
+	}
+	for _, status := range handlerStatuses {
+		if !covered[status] {
+			t.Errorf("handler status %s is not covered", status)
+		}
+	}
+}
+
+func TestHandleAPIRequestRejectsGet(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	w := httptest.NewRecorder()
+	env.server.HandleAPIRequest(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("HTTP status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandleEventsAcceptsKeyOnlyInHeader(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	tests := []struct {
+		name, url, header string
+		want              string
+	}{
+		{name: "header", url: "/events", header: env.key, want: "text/event-stream"},
+		{name: "query", url: "/events?key=" + env.key, want: "INVALID_KEY"},
+		{name: "no key", url: "/events", want: "INVALID_KEY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Отмененный контекст завершает поток сразу после заголовков
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil).WithContext(ctx)
+			if tt.header != "" {
+				r.Header.Set("X-API-Key", tt.header)
+			}
+			w := httptest.NewRecorder()
+			env.server.HandleEvents(w, r)
+
+			if tt.want == "text/event-stream" {
+				if contentType := w.Header().Get("Content-Type"); contentType != tt.want {
+					t.Errorf("Content-Type = %q, want the event stream", contentType)
+				}
+				return
+			}
+			var resp types.BaseResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Status != tt.want {
+				t.Errorf("response %q, want status %s", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestConcurrentActivations разбирает пул параллельными клиентами, каждый из
+// которых проводит активацию до конца: номер выдается сервису один раз, а
+// списания сходятся с балансом
+func TestConcurrentActivations(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	const workers = 12
+	type result struct {
+		service string
+		number  types.GetNumberResponse
+		errs    []string
+	}
+	results := make(chan result, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		service := []string{"tg", "wa"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var res result
+			res.service = service
+			w := env.serve("GET_NUMBER", `"country":"rus","operator":"any","service":"`+service+`","sum":10`)
+			if err := json.Unmarshal(w.Body.Bytes(), &res.number); err != nil {
+				res.errs = append(res.errs, err.Error())
+			}
+			if res.number.Status == "SUCCESS" {
+				activation := fmt.Sprintf(`"activationId":%d`, res.number.ActivationId)
+				for _, step := range []struct{ action, fields string }{
+					{"PUSH_SMS", activation + `,"sms":"Code: 1234"`},
+					{"GET_STATUS", activation},
+					{"FINISH_ACTIVATION", activation + `,"status":3`},
+				} {
+					var resp types.BaseResponse
+					w := env.serve(step.action, step.fields)
+					if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Status != "SUCCESS" {
+						res.errs = append(res.errs, fmt.Sprintf("%s: %s", step.action, w.Body.String()))
+					}
+				}
+			}
+			results <- res
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	numbers := map[string]map[uint64]bool{"tg": {}, "wa": {}}
+	activations := make(map[uint64]bool)
+	statuses := make(map[string]int)
+	for res := range results {
+		for _, err := range res.errs {
+			t.Error(err)
+		}
+		statuses[res.number.Status]++
+		if res.number.Status != "SUCCESS" {
+			continue
+		}
+		if numbers[res.service][res.number.Number] {
+			t.Errorf("number %d issued twice for %s", res.number.Number, res.service)
+		}
+		numbers[res.service][res.number.Number] = true
+		if activations[res.number.ActivationId] {
+			t.Errorf("activation id %d issued twice", res.number.ActivationId)
+		}
+		activations[res.number.ActivationId] = true
+	}
+
+	if statuses["SUCCESS"] != 6 || statuses["NO_NUMBERS1"] != workers-6 {
+		t.Errorf("statuses = %v, want 6 SUCCESS and the rest NO_NUMBERS1", statuses)
+	}
+
+	var balance types.GetBalanceResponse
+	env.call(t, "GET_BALANCE", "", &balance)
+	if balance.Balance != 40 || balance.Held != 0 {
+		t.Errorf("balance %v, held %v; want 40 and 0", balance.Balance, balance.Held)
+	}
+}
+
+func TestConcurrentRateLimit(t *testing.T) {
+	const burst, workers = 5, 20
+	env := newTestEnv(t, func(c *cfg.Config) { c.RateLimit, c.RateBurst = 0.001, burst })
+
+	statuses := make(chan string, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var resp types.BaseResponse
+			w := env.serve("GET_SERVICES", "")
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				resp.Status = w.Body.String()
+			}
+			statuses <- resp.Status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[string]int)
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts["SUCCESS"] != burst || counts["RATE_LIMITED"] != workers-burst {
+		t.Errorf("statuses = %v, want %d SUCCESS and the rest RATE_LIMITED", counts, burst)
+	}
+}
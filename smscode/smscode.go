@@ -0,0 +1,66 @@
+package smscode
+
+import (
+	"regexp"
+	"strings"
+)
+
+// defaultPatterns применяются к сервисам без собственных шаблонов и как запасной вариант.
+// Первая группа каждого шаблона содержит код; пробелы и дефисы внутри кода удаляются.
+// Шаблон с двоеточием идет первым: в "Ваш код 2024 года: 8812" кодом считается
+// число после двоеточия, а не первое число после слова "код".
+var defaultPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(?:code|код|kod|pin)[^:\n]{0,30}:\s*(\d{3}[- ]\d{3}|\d{4,8})(?:[^\d]|$)`),
+	regexp.MustCompile(`(?i)(?:code|код|kod|pin)[^\d]{0,20}(\d{3}[- ]\d{3}|\d{4,8})`),
+	regexp.MustCompile(`(?:^|[^\d])(\d{3}-\d{3})(?:[^\d]|$)`),
+	regexp.MustCompile(`(?:^|[^\d])(\d{4,8})(?:[^\d]|$)`),
+}
+
+// servicePatterns содержит шаблоны, проверяемые раньше шаблонов по умолчанию
+var servicePatterns = map[string][]*regexp.Regexp{
+	"tg": {
+		regexp.MustCompile(`(?i)(?:telegram|login)\s+code:?\s*(\d{5,6})`),
+		regexp.MustCompile(`(?i)код\s+(?:для\s+входа\s+)?(?:в\s+)?(?:telegram)?:?\s*(\d{5,6})`),
+	},
+	"wa": {
+		regexp.MustCompile(`(?i)whatsapp[^\d]{0,40}(\d{3}[- ]\d{3})`),
+		regexp.MustCompile(`(?:^|[^\d])(\d{3}-\d{3})(?:[^\d]|$)`),
+	},
+	"vk": {
+		regexp.MustCompile(`(?i)(?:vk|вк)[^\d]{0,5}(\d{4,6})`),
+		regexp.MustCompile(`(?i)(\d{4,6})\s*[-—–]\s*(?:код|code)`),
+	},
+	"ok": {
+		regexp.MustCompile(`(?i)(?:ok\.ru|одноклассники)[^\d]{0,30}(\d{4,6})`),
+	},
+	"fb": {
+		regexp.MustCompile(`(?i)FB-(\d{5,8})`),
+		regexp.MustCompile(`(?i)facebook[^\d]{0,30}(\d{5,8})`),
+	},
+}
+
+// Extract возвращает код подтверждения из текста SMS или пустую строку
+func Extract(serviceCode, text string) string {
+	for _, pattern := range servicePatterns[serviceCode] {
+		if code := match(pattern, text); code != "" {
+			return code
+		}
+	}
+
+	for _, pattern := range defaultPatterns {
+		if code := match(pattern, text); code != "" {
+			return code
+		}
+	}
+
+	return ""
+}
+
+func match(pattern *regexp.Regexp, text string) string {
+	groups := pattern.FindStringSubmatch(text)
+	if len(groups) < 2 {
+		return ""
+	}
+
+	return strings.NewReplacer("-", "", " ", "").Replace(groups[1])
+}
@@ -0,0 +1,55 @@
+package smscode
+
+import "testing"
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		text    string
+		want    string
+	}{
+		// Шаблоны по умолчанию
+		{"code with colon", "", "Your code: 1234", "1234"},
+		{"russian code", "", "Ваш код подтверждения 482913", "482913"},
+		{"pin", "", "PIN 7788", "7788"},
+		{"kod", "", "Tasdiqlash kod: 55123", "55123"},
+		{"split code", "", "Code 123-456 is valid for 5 minutes", "123456"},
+		{"spaced code", "", "Code: 123 456", "123456"},
+		{"dashed number without keyword", "", "Use 321-654 to sign in", "321654"},
+		{"bare number", "", "Enter 90817 to continue", "90817"},
+		{"year before code", "", "Ваш код 2024 года: 8812", "8812"},
+		{"year before code in english", "", "Your 2024 promo code: 5521", "5521"},
+		{"time after code", "", "Код: 4455, действует до 12:30", "4455"},
+		{"unknown service uses defaults", "xx", "Your code: 1234", "1234"},
+
+		// Шаблоны сервисов
+		{"tg login code", "tg", "Telegram code: 52817. Do not give this code to anyone", "52817"},
+		{"tg login code in russian", "tg", "Код для входа в Telegram: 71234", "71234"},
+		{"tg ignores the first number", "tg", "2 попытки. Код для входа: 64012", "64012"},
+		{"wa split code", "wa", "Your WhatsApp code: 123-456", "123456"},
+		{"wa code without name", "wa", "Код 789-012 не сообщайте никому", "789012"},
+		{"vk code", "vk", "VK: 4821 - код для входа", "4821"},
+		{"vk code before word", "vk", "5521 — код подтверждения", "5521"},
+		{"ok code", "ok", "OK.RU: код 66213", "66213"},
+		{"ok code in russian", "ok", "Одноклассники. Ваш код 77190", "77190"},
+		{"fb prefixed code", "fb", "FB-83920 is your confirmation code", "83920"},
+		{"fb code", "fb", "Your Facebook code is 552310", "552310"},
+
+		// Кода нет
+		{"empty text", "", "", ""},
+		{"no digits", "", "Ваш заказ доставлен", ""},
+		{"too short", "", "Code: 12", ""},
+		{"too long", "", "Call 1234567890123", ""},
+		{"short number for tg", "tg", "Telegram code: 12", ""},
+		{"short number for fb", "fb", "FB-12", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Extract(tt.service, tt.text); got != tt.want {
+				t.Errorf("Extract(%q, %q) = %q, want %q", tt.service, tt.text, got, tt.want)
+			}
+		})
+	}
+}
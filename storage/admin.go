@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"sms-api-service/models"
+)
+
+// ErrConflict возвращается, когда запись с таким кодом уже есть или удаляемая запись еще используется
+var ErrConflict = errors.New("record already exists or is still in use")
+
+// ReleaseReason - причина отмены активаций при принудительном освобождении номера
+const ReleaseReason = "released by admin"
+
+// Admin - операции административного API над справочниками, номерами и активациями.
+// Страны и сервисы адресуются кодом, номера - идентификатором.
+type Admin interface {
+	ListCountries() ([]models.Country, error)
+	GetCountry(code string) (models.Country, error)
+	CreateCountry(country models.Country) (models.Country, error)
+	// UpdateCountry меняет название и префикс страны с кодом country.Code
+	UpdateCountry(country models.Country) (models.Country, error)
+	// DeleteCountry удаляет страну вместе с ее ценами; ErrConflict, если у страны есть номера
+	DeleteCountry(code string) error
+
+	ListServices() ([]models.Service, error)
+	CreateService(service models.Service) (models.Service, error)
+	UpdateService(service models.Service) (models.Service, error)
+	// DeleteService удаляет сервис вместе с его ценами; ErrConflict, если по сервису были активации
+	DeleteService(code string) error
+
+	ListNumbers(filter NumberFilter) ([]models.PhoneNumber, error)
+	GetNumber(numberID int) (models.PhoneNumber, error)
+	// CreateNumber добавляет номер в пул; ErrConflict, если номер уже есть
+	CreateNumber(number models.PhoneNumber) (models.PhoneNumber, error)
+	// ImportNumbers добавляет батч номеров одной транзакцией, пропуская уже
+	// существующие, и возвращает число вставленных
+	ImportNumbers(numbers []models.PhoneNumber) (int, error)
+	// UpdateNumber меняет оператора, доступность и метки номера
+	UpdateNumber(number models.PhoneNumber) (models.PhoneNumber, error)
+	// DeleteNumber удаляет номер; ErrConflict, если по нему были активации
+	DeleteNumber(numberID int) error
+	// SetNumberAvailable блокирует номер для выдачи (false) или возвращает его в пул (true)
+	SetNumberAvailable(numberID int, available bool) error
+	// ReleaseNumber отменяет незавершенные активации номера, рассчитывает их
+	// заблокированные суммы по SettlementKind и снимает все его резервирования
+	ReleaseNumber(numberID int, now time.Time) ([]ExpiredActivation, error)
+
+	ListActivations(filter ActivationFilter) ([]models.Activation, error)
+
+	// ListPrices возвращает цены, упорядоченные по стране, оператору и сервису
+	ListPrices() ([]Price, error)
+	// SetPrice создает или заменяет цену; ErrNotFound, если нет страны или сервиса
+	SetPrice(price Price) error
+	// DeletePrice удаляет цену; ErrNotFound, если ее нет
+	DeletePrice(country, operator, service string) error
+}
+
+// NumberFilter - условия выборки номеров; пустые поля не ограничивают выборку
+type NumberFilter struct {
+	Country   string
+	Operator  string
+	Available *bool
+	Limit     int
+	Offset    int
+}
+
+// ActivationFilter - условия выборки активаций; пустые поля не ограничивают выборку
+type ActivationFilter struct {
+	AccountID     *int64
+	Status        *int
+	Service       string
+	Country       string
+	NumberID      int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Limit         int
+	Offset        int
+}
+
+// MatchesCreatedAt сообщает, попадает ли время создания в [CreatedAfter, CreatedBefore);
+// нулевые границы не ограничивают
+func (f ActivationFilter) MatchesCreatedAt(createdAt time.Time) bool {
+	if !f.CreatedAfter.IsZero() && createdAt.Before(f.CreatedAfter) {
+		return false
+	}
+	return f.CreatedBefore.IsZero() || createdAt.Before(f.CreatedBefore)
+}
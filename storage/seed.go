@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+
+	"sms-api-service/models"
+)
+
+// Price задает стоимость номера для страны, оператора и сервиса.
+// Оператор "any" действует для всех операторов страны без собственной цены.
+type Price struct {
+	Country  string  `json:"country"`
+	Operator string  `json:"operator"`
+	Service  string  `json:"service"`
+	Price    float64 `json:"price"`
+}
+
+// SeedAPIKey описывает ключ, создаваемый при заполнении базы
+type SeedAPIKey struct {
+	Account string
+	Name    string
+	Key     string
+}
+
+// SeedData структура для конфигурации тестовых данных
+type SeedData struct {
+	Countries    []models.Country
+	Services     []models.Service
+	Accounts     []models.Account
+	Prices       []Price
+	APIKeys      []SeedAPIKey
+	NumbersRange struct {
+		Min, Max int
+	}
+	// Operators - операторы страны по ее коду; сгенерированные номера
+	// распределяются между ними случайно. Для стран без операторов номера
+	// не генерируются.
+	Operators map[string][]string
+}
+
+// DefaultSeedData возвращает данные для заполнения по умолчанию
+func DefaultSeedData() *SeedData {
+	return &SeedData{
+		Countries: []models.Country{
+			{Code: "rus", Name: "Russia", Prefix: 7},
+			{Code: "uzb", Name: "Uzbekistan", Prefix: 998},
+			{Code: "bel", Name: "Belarus", Prefix: 375},
+		},
+		Services: []models.Service{
+			{Code: "vk", Name: "VKontakte"},
+			{Code: "ok", Name: "Odnoklassniki"},
+			{Code: "wa", Name: "WhatsApp"},
+			{Code: "tg", Name: "Telegram"},
+			{Code: "fb", Name: "Facebook"},
+		},
+		Prices: []Price{
+			{Country: "rus", Operator: "any", Service: "vk", Price: 15},
+			{Country: "rus", Operator: "any", Service: "ok", Price: 10},
+			{Country: "rus", Operator: "any", Service: "wa", Price: 20},
+			{Country: "rus", Operator: "any", Service: "tg", Price: 20},
+			{Country: "rus", Operator: "any", Service: "fb", Price: 12},
+			{Country: "uzb", Operator: "any", Service: "vk", Price: 8},
+			{Country: "uzb", Operator: "any", Service: "ok", Price: 6},
+			{Country: "uzb", Operator: "any", Service: "wa", Price: 12},
+			{Country: "uzb", Operator: "any", Service: "tg", Price: 12},
+			{Country: "uzb", Operator: "any", Service: "fb", Price: 8},
+			{Country: "bel", Operator: "any", Service: "vk", Price: 10},
+			{Country: "bel", Operator: "any", Service: "ok", Price: 8},
+			{Country: "bel", Operator: "any", Service: "wa", Price: 15},
+			{Country: "bel", Operator: "any", Service: "tg", Price: 15},
+			{Country: "bel", Operator: "any", Service: "fb", Price: 10},
+		},
+		NumbersRange: struct{ Min, Max int }{Min: 20, Max: 30},
+		Operators: map[string][]string{
+			"rus": {"mts", "beeline", "megafon", "tele2"},
+			"uzb": {"beeline", "ucell", "uzmobile", "mobiuz"},
+			"bel": {"a1", "mts", "life"},
+		},
+	}
+}
+
+// AddDemoKey добавляет демонстрационный счет default с балансом 1000 и ключ
+// qwerty123. Ключ общеизвестен, поэтому создается только по явному запросу.
+func (d *SeedData) AddDemoKey() {
+	d.Accounts = append(d.Accounts, models.Account{Name: "default", Balance: 1000})
+	d.APIKeys = append(d.APIKeys, SeedAPIKey{Account: "default", Name: "default", Key: "qwerty123"})
+}
+
+// GeneratePhoneNumber генерирует номер телефона для заданного префикса
+func GeneratePhoneNumber(prefix uint64) uint64 {
+	switch prefix {
+	case 7: // Russia
+		return 79000000000 + uint64(mathrand.Intn(999999999))
+	case 998: // Uzbekistan
+		return 998000000000 + uint64(mathrand.Intn(999999999))
+	case 375: // Belarus
+		return 375000000000 + uint64(mathrand.Intn(999999999))
+	default:
+		return prefix*1000000000 + uint64(mathrand.Intn(999999999))
+	}
+}
+
+// RandomOperator выбирает оператора для сгенерированного номера; operators не пуст
+func RandomOperator(operators []string) string {
+	return operators[mathrand.Intn(len(operators))]
+}
+
+// HashAPIKey возвращает хеш, под которым ключ хранится в базе
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey генерирует открытое значение нового ключа
+func NewAPIKey() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"sms-api-service/models"
+)
+
+var (
+	// ErrNotFound возвращается, когда запрошенная запись отсутствует
+	ErrNotFound       = errors.New("record not found")
+	ErrNumberExcluded = errors.New("all available numbers match excluded prefixes")
+	ErrBadTransition  = errors.New("activation status transition not allowed")
+	ErrNoBalance      = errors.New("insufficient balance")
+	ErrPriceTooLow    = errors.New("sum is below the current price")
+	ErrInvalidKey     = errors.New("api key is unknown, disabled or expired")
+	// ErrTooManyActivations возвращается ReserveNumber, когда у счета уже
+	// NumberQuery.MaxActive незавершенных активаций
+	ErrTooManyActivations = errors.New("too many active activations")
+)
+
+// Виды операций журнала ledger_entries
+const (
+	LedgerDeposit = "deposit"
+	LedgerHold    = "hold"
+	LedgerCapture = "capture"
+	LedgerRefund  = "refund"
+)
+
+// SettlementKind определяет судьбу заблокированной суммы при переходе из статуса
+// from в финальный статус to. После получения кода номер уже использован, поэтому
+// отмена и истечение списывают сумму так же, как завершение; возврат - только
+// для активаций, не дождавшихся SMS.
+func SettlementKind(from, to int) string {
+	if to == models.ActivationCompleted || from != models.ActivationWaiting {
+		return LedgerCapture
+	}
+	return LedgerRefund
+}
+
+// Store - операции с номерами, сервисами, активациями и SMS, которые нужны
+// обработчикам API и сборщику. Реализации должны быть безопасны для
+// конкурентного использования и возвращать ошибки этого пакета.
+type Store interface {
+	// GetAvailableServices возвращает число свободных номеров по стране, оператору и сервису
+	GetAvailableServices(usedSince time.Time) (map[string]map[string]map[string]int, error)
+	GetServiceByCode(code string) (models.Service, error)
+	// ReserveNumber выбирает номер, цена оператора которого не выше sum, создает
+	// активацию и блокирует sum на счете. ErrPriceTooLow означает, что подходящие
+	// номера есть, но все дороже sum; отсутствие цены не ограничивает.
+	ReserveNumber(query NumberQuery, accountID int64, sum float64) (models.PhoneNumber, uint64, error)
+	// TransitionActivation переводит активацию счета в новый статус; финальный статус
+	// освобождает номер и списывает или возвращает заблокированную сумму
+	TransitionActivation(accountID int64, activationID uint64, status int) error
+	// ExpireActivations закрывает незавершенные активации, созданные до createdBefore
+	ExpireActivations(createdBefore, now time.Time, reason string) ([]ExpiredActivation, error)
+	GetActivation(activationID uint64) (models.Activation, error)
+	// GetActivationTarget возвращает номер и код сервиса активации
+	GetActivationTarget(activationID uint64) (uint64, string, error)
+	CheckActivationOwned(activationID uint64, accountID int64) (bool, error)
+	CountActiveActivations(accountID int64) (int, error)
+	// CountActivationsByStatus возвращает число активаций всех счетов по статусам
+	CountActivationsByStatus() (map[int]int, error)
+	// ReceiveSMS переводит активацию счета в CODE_RECEIVED и сохраняет SMS одной
+	// операцией; возвращает извлеченный из сообщения код
+	ReceiveSMS(accountID int64, activationID uint64, text string) (string, error)
+	GetSMSByActivation(activationID uint64) ([]models.SMS, error)
+
+	GetAccount(accountID int64) (models.Account, error)
+	GetPrices() (map[string]map[string]map[string]float64, error)
+	AuthenticateAPIKey(key string, now time.Time) (models.APIKey, error)
+}
+
+// Backend - хранилище вместе с операциями обслуживания: схемой, начальными
+// данными, административным API и управлением ключами
+type Backend interface {
+	Store
+	Admin
+
+	Migrate(ctx context.Context) error
+	MigrationStatus(ctx context.Context) ([]MigrationState, error)
+	PendingMigrations(ctx context.Context) (int, error)
+	Seed(ctx context.Context, seedData *SeedData) error
+
+	// EnsureAccount возвращает идентификатор счета, создавая счет при необходимости
+	EnsureAccount(name string) (int64, error)
+	// CreateAPIKey выпускает ключ и возвращает его открытое значение
+	CreateAPIKey(accountID int64, name string, expiresAt *time.Time) (string, models.APIKey, error)
+	ListAPIKeys() ([]models.APIKey, error)
+	SetAPIKeyEnabled(keyID int64, enabled bool) error
+	SetAPIKeyExpiry(keyID int64, expiresAt *time.Time) error
+	SetAPIKeyLimits(keyID int64, rateLimit *float64, rateBurst, maxActiveActivations *int) error
+
+	Close() error
+}
+
+// AnyOperator в запросе номера означает любого оператора страны, а в ценах -
+// цену для операторов без собственной
+const AnyOperator = "any"
+
+// NormalizeOperator приводит оператора номера к нижнему регистру. У номера
+// должен быть конкретный оператор: пустое значение и AnyOperator отклоняются.
+func NormalizeOperator(operator string) (string, error) {
+	operator = strings.ToLower(strings.TrimSpace(operator))
+	switch operator {
+	case "":
+		return "", errors.New("operator is required")
+	case AnyOperator:
+		return "", fmt.Errorf("operator %q is reserved for requests and prices", AnyOperator)
+	}
+	return operator, nil
+}
+
+// NumberQuery описывает критерии выбора свободного номера
+type NumberQuery struct {
+	Country   string
+	Operator  string
+	ServiceID int
+	// UsedSince исключает номера, уже использованные для сервиса начиная с этого момента.
+	// Нулевое значение исключает любое прошлое использование.
+	UsedSince         time.Time
+	ExceptionPrefixes []string
+	// MaxActive ограничивает число незавершенных активаций счета; проверка
+	// выполняется атомарно с резервированием. 0 снимает ограничение.
+	MaxActive int
+}
+
+// UsedSinceUnix возвращает границу UsedSince в секундах; 0 для нулевого значения
+func (q NumberQuery) UsedSinceUnix() int64 {
+	if q.UsedSince.IsZero() {
+		return 0
+	}
+	return q.UsedSince.Unix()
+}
+
+// MatchesOperator сообщает, подходит ли номер оператора operator под запрос
+func (q NumberQuery) MatchesOperator(operator string) bool {
+	return q.Operator == AnyOperator || q.Operator == operator
+}
+
+// UniquePrefixes возвращает префиксы исключений без повторов в исходном порядке.
+// Пустые префиксы пропускаются: иначе под исключение попал бы весь пул.
+func (q NumberQuery) UniquePrefixes() []string {
+	seen := make(map[string]struct{}, len(q.ExceptionPrefixes))
+	prefixes := make([]string, 0, len(q.ExceptionPrefixes))
+	for _, prefix := range q.ExceptionPrefixes {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" {
+			continue
+		}
+		if _, duplicate := seen[prefix]; duplicate {
+			continue
+		}
+		seen[prefix] = struct{}{}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// PrefixGroup - префиксы исключений одной длины
+type PrefixGroup struct {
+	Length   int
+	Prefixes []string
+}
+
+// PrefixesByLength группирует UniquePrefixes по длине в порядке ее возрастания.
+// Хранилища сравнивают начало номера каждой длины со всей группой сразу, так что
+// проверка номера стоит столько, сколько разных длин, а не префиксов.
+func (q NumberQuery) PrefixesByLength() []PrefixGroup {
+	var groups []PrefixGroup
+	index := make(map[int]int)
+	for _, prefix := range q.UniquePrefixes() {
+		i, ok := index[len(prefix)]
+		if !ok {
+			i = len(groups)
+			index[len(prefix)] = i
+			groups = append(groups, PrefixGroup{Length: len(prefix)})
+		}
+		groups[i].Prefixes = append(groups[i].Prefixes, prefix)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Length < groups[j].Length })
+	return groups
+}
+
+// ExpiredActivation описывает активацию, закрытую сборщиком или освобождением номера
+type ExpiredActivation struct {
+	ID        uint64
+	AccountID int64
+}
+
+// MigrationState описывает миграцию схемы и момент ее применения
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
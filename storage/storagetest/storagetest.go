@@ -0,0 +1,597 @@
+// Package storagetest содержит общие проверки реализаций storage.Backend.
+// Тесты каждого хранилища вызывают Run со своей фабрикой, поэтому SQLite,
+// PostgreSQL и хранилище в памяти проверяются на одинаковую семантику.
+package storagetest
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"sms-api-service/models"
+	"sms-api-service/storage"
+)
+
+// Factory создает пустое хранилище с примененной схемой; закрывать его
+// должна сама фабрика через t.Cleanup
+type Factory func(t *testing.T) storage.Backend
+
+// Run проверяет хранилища, создаваемые newBackend
+func Run(t *testing.T, newBackend Factory) {
+	t.Run("Reserve", func(t *testing.T) { testReserve(t, newBackend) })
+	t.Run("ReserveExcludedPrefixes", func(t *testing.T) { testReserveExcludedPrefixes(t, newBackend) })
+	t.Run("ReserveManyPrefixes", func(t *testing.T) { testReserveManyPrefixes(t, newBackend) })
+	t.Run("ReserveActiveLimit", func(t *testing.T) { testReserveActiveLimit(t, newBackend) })
+	t.Run("Transition", func(t *testing.T) { testTransition(t, newBackend) })
+	t.Run("Expire", func(t *testing.T) { testExpire(t, newBackend) })
+	t.Run("ReceiveSMS", func(t *testing.T) { testReceiveSMS(t, newBackend) })
+	t.Run("Billing", func(t *testing.T) { testBilling(t, newBackend) })
+	t.Run("Prices", func(t *testing.T) { testPrices(t, newBackend) })
+	t.Run("ConcurrentReserve", func(t *testing.T) { testConcurrentReserve(t, newBackend) })
+}
+
+// Fixture - хранилище со страной rus, сервисами tg и wa, счетом client с
+// балансом 100, ключом API этого счета и номерами 79000000001, 79000000002, ...
+// заданных операторов. Общие данные для тестов хранилищ, обработчиков и сервера.
+type Fixture struct {
+	Store     storage.Backend
+	AccountID int64
+	// Key - ключ API счета, APIKey - его запись
+	Key    string
+	APIKey models.APIKey
+	// TG и WA - запросы номера сервисов tg и wa у любого оператора rus
+	TG, WA storage.NumberQuery
+}
+
+// NewFixture создает хранилище через newBackend и заполняет его данными Fixture
+func NewFixture(t *testing.T, newBackend Factory, operators ...string) *Fixture {
+	t.Helper()
+
+	store := newBackend(t)
+	err := store.Seed(context.Background(), &storage.SeedData{
+		Countries: []models.Country{{Code: "rus", Name: "Russia", Prefix: 7}},
+		Services: []models.Service{
+			{Code: "tg", Name: "Telegram"},
+			{Code: "wa", Name: "WhatsApp"},
+		},
+		Accounts: []models.Account{{Name: "client", Balance: 100}},
+	})
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	country, err := store.GetCountry("rus")
+	if err != nil {
+		t.Fatalf("get country: %v", err)
+	}
+	for i, operator := range operators {
+		_, err := store.CreateNumber(models.PhoneNumber{
+			Number:    79000000001 + uint64(i),
+			CountryID: country.ID,
+			Operator:  operator,
+			Available: true,
+		})
+		if err != nil {
+			t.Fatalf("create number: %v", err)
+		}
+	}
+
+	accountID, err := store.EnsureAccount("client")
+	if err != nil {
+		t.Fatalf("ensure account: %v", err)
+	}
+	key, apiKey, err := store.CreateAPIKey(accountID, "test", nil)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	f := &Fixture{Store: store, AccountID: accountID, Key: key, APIKey: apiKey}
+	f.TG = f.query(t, "tg")
+	f.WA = f.query(t, "wa")
+	return f
+}
+
+// SetPrices задает цены и завершает тест при ошибке
+func (f *Fixture) SetPrices(t *testing.T, prices ...storage.Price) {
+	t.Helper()
+
+	for _, price := range prices {
+		if err := f.Store.SetPrice(price); err != nil {
+			t.Fatalf("set price %+v: %v", price, err)
+		}
+	}
+}
+
+func (f *Fixture) query(t *testing.T, service string) storage.NumberQuery {
+	t.Helper()
+
+	srv, err := f.Store.GetServiceByCode(service)
+	if err != nil {
+		t.Fatalf("get service %s: %v", service, err)
+	}
+	return storage.NumberQuery{Country: "rus", Operator: storage.AnyOperator, ServiceID: srv.ID}
+}
+
+// reserve резервирует номер и завершает тест при ошибке
+func (f *Fixture) reserve(t *testing.T, query storage.NumberQuery, sum float64) (models.PhoneNumber, uint64) {
+	t.Helper()
+
+	number, activationID, err := f.Store.ReserveNumber(query, f.AccountID, sum)
+	if err != nil {
+		t.Fatalf("reserve %+v: %v", query, err)
+	}
+	return number, activationID
+}
+
+func (f *Fixture) transition(t *testing.T, activationID uint64, statuses ...int) {
+	t.Helper()
+
+	for _, status := range statuses {
+		if err := f.Store.TransitionActivation(f.AccountID, activationID, status); err != nil {
+			t.Fatalf("transition %d to %d: %v", activationID, status, err)
+		}
+	}
+}
+
+func (f *Fixture) checkBalance(t *testing.T, balance, held float64) {
+	t.Helper()
+
+	account, err := f.Store.GetAccount(f.AccountID)
+	if err != nil {
+		t.Fatalf("get account: %v", err)
+	}
+	if account.Balance != balance || account.Held != held {
+		t.Errorf("balance %v, held %v; want %v and %v", account.Balance, account.Held, balance, held)
+	}
+}
+
+func (f *Fixture) checkStatus(t *testing.T, activationID uint64, status int) models.Activation {
+	t.Helper()
+
+	activation, err := f.Store.GetActivation(activationID)
+	if err != nil {
+		t.Fatalf("get activation %d: %v", activationID, err)
+	}
+	if activation.Status != status {
+		t.Errorf("activation %d: status %d, want %d", activationID, activation.Status, status)
+	}
+	return activation
+}
+
+func testReserve(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend, "mts", "beeline")
+
+	query := f.TG
+	query.Operator = "beeline"
+	number, activationID := f.reserve(t, query, 10)
+	if number.Number != 79000000002 || number.Operator != "beeline" {
+		t.Errorf("beeline: got %+v", number)
+	}
+
+	activation := f.checkStatus(t, activationID, models.ActivationWaiting)
+	if activation.AccountID == nil || *activation.AccountID != f.AccountID || activation.Sum != 10 {
+		t.Errorf("unexpected activation %+v", activation)
+	}
+
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 10); err != storage.ErrNotFound {
+		t.Errorf("beeline again: err = %v, want ErrNotFound", err)
+	}
+	query.Operator = "tele2"
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 10); err != storage.ErrNotFound {
+		t.Errorf("tele2: err = %v, want ErrNotFound", err)
+	}
+	query = f.TG
+	query.Country = "xxx"
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 10); err != storage.ErrNotFound {
+		t.Errorf("unknown country: err = %v, want ErrNotFound", err)
+	}
+
+	if number, _ := f.reserve(t, f.TG, 10); number.Number != 79000000001 {
+		t.Errorf("any: got %d, want the remaining mts number", number.Number)
+	}
+	if _, _, err := f.Store.ReserveNumber(f.TG, f.AccountID, 10); err != storage.ErrNotFound {
+		t.Errorf("tg pool exhausted: err = %v, want ErrNotFound", err)
+	}
+
+	// Резервирование действует в пределах сервиса
+	f.reserve(t, f.WA, 10)
+
+	if _, _, err := f.Store.ReserveNumber(f.WA, f.AccountID, 1000); err != storage.ErrNoBalance {
+		t.Errorf("sum over balance: err = %v, want ErrNoBalance", err)
+	}
+	f.checkBalance(t, 70, 30)
+}
+
+func testReserveExcludedPrefixes(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend, "mts", "mts")
+
+	query := f.TG
+	query.ExceptionPrefixes = []string{"79000000001"}
+	if number, _ := f.reserve(t, query, 0); number.Number != 79000000002 {
+		t.Errorf("got %d, want the number outside the excluded prefix", number.Number)
+	}
+
+	query.ExceptionPrefixes = []string{"7900"}
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 0); err != storage.ErrNumberExcluded {
+		t.Errorf("all excluded: err = %v, want ErrNumberExcluded", err)
+	}
+
+	f.reserve(t, f.TG, 0)
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 0); err != storage.ErrNotFound {
+		t.Errorf("empty pool with prefixes: err = %v, want ErrNotFound", err)
+	}
+}
+
+// testReserveManyPrefixes исключает из пула в 1000 номеров все, кроме одного,
+// отдельными префиксами: оставшийся номер должен найтись, а ErrNumberExcluded
+// появиться только после его выдачи
+func testReserveManyPrefixes(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend)
+
+	country, err := f.Store.GetCountry("rus")
+	if err != nil {
+		t.Fatalf("get country: %v", err)
+	}
+	const poolSize, kept = 1000, 777
+	numbers := make([]models.PhoneNumber, poolSize)
+	prefixes := []string{"", "  "}
+	for i := range numbers {
+		numbers[i] = models.PhoneNumber{Number: 79000000000 + uint64(i), CountryID: country.ID, Operator: "mts", Available: true}
+		if i != kept {
+			prefixes = append(prefixes, strconv.FormatUint(numbers[i].Number, 10))
+		}
+	}
+	if inserted, err := f.Store.ImportNumbers(numbers); err != nil || inserted != poolSize {
+		t.Fatalf("import: inserted %d, err %v", inserted, err)
+	}
+	// Повторы не должны менять результат
+	prefixes = append(prefixes, prefixes[2:12]...)
+
+	query := f.TG
+	query.ExceptionPrefixes = prefixes
+	if number, _ := f.reserve(t, query, 0); number.Number != 79000000000+kept {
+		t.Errorf("got %d, want the only number outside the excluded prefixes", number.Number)
+	}
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 0); err != storage.ErrNumberExcluded {
+		t.Errorf("all excluded: err = %v, want ErrNumberExcluded", err)
+	}
+
+	// Пустые префиксы ничего не исключают
+	query.ExceptionPrefixes = []string{"", " "}
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 0); err != nil {
+		t.Errorf("empty prefixes: %v", err)
+	}
+	query.ExceptionPrefixes = []string{"8", "7901"}
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 0); err != nil {
+		t.Errorf("prefixes outside the pool: %v", err)
+	}
+}
+
+func testReserveActiveLimit(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend, "mts", "mts", "mts")
+
+	query := f.TG
+	query.MaxActive = 2
+	_, first := f.reserve(t, query, 0)
+	f.reserve(t, query, 0)
+
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 0); err != storage.ErrTooManyActivations {
+		t.Errorf("over the limit: err = %v, want ErrTooManyActivations", err)
+	}
+	if count, err := f.Store.CountActiveActivations(f.AccountID); err != nil || count != 2 {
+		t.Errorf("active activations = %d, %v; want 2", count, err)
+	}
+
+	f.transition(t, first, models.ActivationCancelled)
+	f.reserve(t, query, 0)
+}
+
+func testTransition(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend, "mts")
+	_, activationID := f.reserve(t, f.TG, 10)
+
+	for _, status := range []int{models.ActivationWaiting, models.ActivationCompleted, 42} {
+		if err := f.Store.TransitionActivation(f.AccountID, activationID, status); err != storage.ErrBadTransition {
+			t.Errorf("waiting to %d: err = %v, want ErrBadTransition", status, err)
+		}
+	}
+	if err := f.Store.TransitionActivation(f.AccountID+1, activationID, models.ActivationCancelled); err != storage.ErrNotFound {
+		t.Errorf("other account: err = %v, want ErrNotFound", err)
+	}
+	if err := f.Store.TransitionActivation(f.AccountID, activationID+100, models.ActivationCancelled); err != storage.ErrNotFound {
+		t.Errorf("unknown activation: err = %v, want ErrNotFound", err)
+	}
+
+	f.transition(t, activationID, models.ActivationCodeReceived, models.ActivationRetryRequested,
+		models.ActivationCodeReceived, models.ActivationCompleted)
+	activation := f.checkStatus(t, activationID, models.ActivationCompleted)
+	if activation.FinishedAt == nil {
+		t.Error("finished_at is not set for a final status")
+	}
+
+	if err := f.Store.TransitionActivation(f.AccountID, activationID, models.ActivationCancelled); err != storage.ErrBadTransition {
+		t.Errorf("completed to cancelled: err = %v, want ErrBadTransition", err)
+	}
+
+	// Номер освобожден, но уже использован для tg; для wa он доступен
+	if _, _, err := f.Store.ReserveNumber(f.TG, f.AccountID, 0); err != storage.ErrNotFound {
+		t.Errorf("used number for tg: err = %v, want ErrNotFound", err)
+	}
+	f.reserve(t, f.WA, 0)
+}
+
+func testExpire(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend, "mts", "mts", "mts")
+
+	_, waiting := f.reserve(t, f.TG, 10)
+	_, received := f.reserve(t, f.TG, 10)
+	_, completed := f.reserve(t, f.TG, 10)
+	f.transition(t, received, models.ActivationCodeReceived)
+	f.transition(t, completed, models.ActivationCodeReceived, models.ActivationCompleted)
+
+	now := time.Now()
+	expired, err := f.Store.ExpireActivations(now.Add(-time.Hour), now, "ttl")
+	if err != nil || len(expired) != 0 {
+		t.Fatalf("fresh activations: expired %v, err %v; want nothing", expired, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	expired, err = f.Store.ExpireActivations(now.Add(-time.Hour), now, "ttl")
+	if err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	ids := make(map[uint64]bool)
+	for _, activation := range expired {
+		ids[activation.ID] = true
+		if activation.AccountID != f.AccountID {
+			t.Errorf("activation %d: account %d, want %d", activation.ID, activation.AccountID, f.AccountID)
+		}
+	}
+	if len(expired) != 2 || !ids[waiting] || !ids[received] {
+		t.Errorf("expired %v, want activations %d and %d", expired, waiting, received)
+	}
+
+	for _, activationID := range []uint64{waiting, received} {
+		activation := f.checkStatus(t, activationID, models.ActivationExpired)
+		if activation.FinishReason == nil || *activation.FinishReason != "ttl" || activation.FinishedAt == nil {
+			t.Errorf("activation %d: finish reason %v, finished at %v", activationID, activation.FinishReason, activation.FinishedAt)
+		}
+	}
+	f.checkStatus(t, completed, models.ActivationCompleted)
+
+	// Возвращена только блокировка активации без SMS: получивший код клиент
+	// оплачивает активацию и при истечении
+	f.checkBalance(t, 80, 0)
+
+	if expired, err := f.Store.ExpireActivations(now.Add(-time.Hour), now, "ttl"); err != nil || len(expired) != 0 {
+		t.Errorf("second run: expired %v, err %v; want nothing", expired, err)
+	}
+
+	// Истекшая активация без SMS освобождает номер и для того же сервиса
+	f.reserve(t, f.TG, 0)
+}
+
+func testReceiveSMS(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend, "mts")
+	number, activationID := f.reserve(t, f.TG, 10)
+
+	code, err := f.Store.ReceiveSMS(f.AccountID, activationID, "Telegram code: 52817")
+	if err != nil || code != "52817" {
+		t.Fatalf("receive: code %q, err %v", code, err)
+	}
+	if _, err := f.Store.ReceiveSMS(f.AccountID, activationID, "No code here"); err != nil {
+		t.Fatalf("second SMS: %v", err)
+	}
+	f.checkStatus(t, activationID, models.ActivationCodeReceived)
+
+	messages, err := f.Store.GetSMSByActivation(activationID)
+	if err != nil {
+		t.Fatalf("get sms: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Code != "52817" || messages[0].Text != "Telegram code: 52817" || messages[1].Code != "" {
+		t.Errorf("unexpected messages %+v", messages)
+	}
+
+	target, service, err := f.Store.GetActivationTarget(activationID)
+	if err != nil || target != number.Number || service != "tg" {
+		t.Errorf("target = %d, %q, %v; want %d, tg", target, service, err, number.Number)
+	}
+
+	if _, err := f.Store.ReceiveSMS(f.AccountID+1, activationID, "Code: 1111"); err != storage.ErrNotFound {
+		t.Errorf("other account: err = %v, want ErrNotFound", err)
+	}
+
+	f.transition(t, activationID, models.ActivationCancelled)
+	if _, err := f.Store.ReceiveSMS(f.AccountID, activationID, "Code: 2222"); err != storage.ErrBadTransition {
+		t.Errorf("cancelled activation: err = %v, want ErrBadTransition", err)
+	}
+
+	if messages, err := f.Store.GetSMSByActivation(activationID); err != nil || len(messages) != 2 {
+		t.Errorf("rejected SMS were stored: %d messages, err %v", len(messages), err)
+	}
+}
+
+func testBilling(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend, "mts", "mts", "mts")
+
+	_, completed := f.reserve(t, f.TG, 10)
+	_, cancelled := f.reserve(t, f.TG, 15)
+	f.checkBalance(t, 75, 25)
+
+	f.transition(t, completed, models.ActivationCodeReceived, models.ActivationCompleted)
+	f.checkBalance(t, 75, 15)
+
+	f.transition(t, cancelled, models.ActivationCancelled)
+	f.checkBalance(t, 90, 0)
+
+	_, free := f.reserve(t, f.TG, 0)
+	f.transition(t, free, models.ActivationCancelled)
+	f.checkBalance(t, 90, 0)
+
+	// После получения кода отмена списывает сумму, в том числе после запроса повтора
+	_, afterCode := f.reserve(t, f.WA, 5)
+	_, afterRetry := f.reserve(t, f.WA, 5)
+	f.checkBalance(t, 80, 10)
+	f.transition(t, afterCode, models.ActivationCodeReceived, models.ActivationCancelled)
+	f.transition(t, afterRetry, models.ActivationCodeReceived, models.ActivationRetryRequested, models.ActivationCancelled)
+	f.checkBalance(t, 80, 0)
+
+	// ReleaseNumber рассчитывает активации номера по тем же правилам
+	g := NewFixture(t, newBackend, "mts")
+	number, waiting := g.reserve(t, g.TG, 10)
+	_, received := g.reserve(t, g.WA, 20)
+	g.transition(t, received, models.ActivationCodeReceived)
+
+	released, err := g.Store.ReleaseNumber(number.ID, time.Now())
+	if err != nil || len(released) != 2 {
+		t.Fatalf("release: %v, err %v; want both activations", released, err)
+	}
+	g.checkStatus(t, waiting, models.ActivationCancelled)
+	g.checkStatus(t, received, models.ActivationCancelled)
+	g.checkBalance(t, 80, 0)
+}
+
+func testPrices(t *testing.T, newBackend Factory) {
+	f := NewFixture(t, newBackend, "mts", "beeline")
+
+	for _, price := range []storage.Price{
+		{Country: "rus", Operator: "any", Service: "tg", Price: 10},
+		{Country: "rus", Operator: "mts", Service: "tg", Price: 50},
+		{Country: "rus", Operator: "mts", Service: "wa", Price: 5},
+	} {
+		if err := f.Store.SetPrice(price); err != nil {
+			t.Fatalf("set price %+v: %v", price, err)
+		}
+	}
+	if err := f.Store.SetPrice(storage.Price{Country: "xxx", Operator: "any", Service: "tg", Price: 1}); err != storage.ErrNotFound {
+		t.Errorf("unknown country: err = %v, want ErrNotFound", err)
+	}
+	if err := f.Store.SetPrice(storage.Price{Country: "rus", Operator: "any", Service: "xx", Price: 1}); err != storage.ErrNotFound {
+		t.Errorf("unknown service: err = %v, want ErrNotFound", err)
+	}
+
+	prices, err := f.Store.ListPrices()
+	if err != nil {
+		t.Fatalf("list prices: %v", err)
+	}
+	want := []storage.Price{
+		{Country: "rus", Operator: "any", Service: "tg", Price: 10},
+		{Country: "rus", Operator: "mts", Service: "tg", Price: 50},
+		{Country: "rus", Operator: "mts", Service: "wa", Price: 5},
+	}
+	if len(prices) != len(want) {
+		t.Fatalf("prices = %v, want %v", prices, want)
+	}
+	for i := range want {
+		if prices[i] != want[i] {
+			t.Errorf("price %d = %+v, want %+v", i, prices[i], want[i])
+		}
+	}
+
+	priceMap, err := f.Store.GetPrices()
+	if err != nil {
+		t.Fatalf("get prices: %v", err)
+	}
+	if priceMap["rus"]["mts"]["tg"] != 50 || priceMap["rus"]["any"]["tg"] != 10 {
+		t.Errorf("unexpected price map %v", priceMap)
+	}
+
+	query := f.TG
+	query.Operator = "mts"
+	if _, _, err := f.Store.ReserveNumber(query, f.AccountID, 10); err != storage.ErrPriceTooLow {
+		t.Errorf("mts for 10: err = %v, want ErrPriceTooLow", err)
+	}
+	f.checkBalance(t, 100, 0)
+
+	// При any цена берется для оператора выбранного номера
+	if number, _ := f.reserve(t, f.TG, 10); number.Operator != "beeline" {
+		t.Errorf("any for 10: got %+v, want the beeline number", number)
+	}
+	if _, _, err := f.Store.ReserveNumber(f.TG, f.AccountID, 10); err != storage.ErrPriceTooLow {
+		t.Errorf("any for 10 with only mts left: err = %v, want ErrPriceTooLow", err)
+	}
+	if number, _ := f.reserve(t, f.TG, 50); number.Operator != "mts" {
+		t.Errorf("any for 50: got %+v, want the mts number", number)
+	}
+
+	// Для wa цены any нет: beeline без цены доступен при любой сумме
+	query = f.WA
+	query.Operator = "beeline"
+	f.reserve(t, query, 0)
+
+	if err := f.Store.DeletePrice("rus", "mts", "wa"); err != nil {
+		t.Fatalf("delete price: %v", err)
+	}
+	if err := f.Store.DeletePrice("rus", "mts", "wa"); err != storage.ErrNotFound {
+		t.Errorf("second delete: err = %v, want ErrNotFound", err)
+	}
+	f.reserve(t, f.WA, 0)
+}
+
+// testConcurrentReserve запускает по одному GET_NUMBER на каждый номер пула
+// одновременно для двух сервисов. Номер свободен для каждого сервиса отдельно,
+// поэтому все запросы должны получить номер: ErrNotFound здесь означает, что
+// резервирование для одного сервиса помешало другому. В пределах сервиса номер
+// выдается один раз, а идентификаторы активаций не повторяются.
+func testConcurrentReserve(t *testing.T, newBackend Factory) {
+	const poolSize = 20
+	operators := make([]string, poolSize)
+	for i := range operators {
+		operators[i] = []string{"mts", "beeline"}[i%2]
+	}
+	f := NewFixture(t, newBackend, operators...)
+
+	type reservation struct {
+		service      string
+		number       uint64
+		activationID uint64
+		err          error
+	}
+	results := make(chan reservation, 2*poolSize)
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, service := range []string{"tg", "wa"} {
+		query := f.query(t, service)
+		for i := 0; i < poolSize; i++ {
+			wg.Add(1)
+			go func(service string) {
+				defer wg.Done()
+				<-start
+				number, activationID, err := f.Store.ReserveNumber(query, f.AccountID, 1)
+				results <- reservation{service: service, number: number.Number, activationID: activationID, err: err}
+			}(service)
+		}
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	numbers := map[string]map[uint64]bool{"tg": {}, "wa": {}}
+	activations := make(map[uint64]bool)
+	for r := range results {
+		if r.err != nil {
+			t.Errorf("%s: reserve: %v", r.service, r.err)
+			continue
+		}
+		if numbers[r.service][r.number] {
+			t.Errorf("number %d reserved twice for %s", r.number, r.service)
+		}
+		numbers[r.service][r.number] = true
+		if activations[r.activationID] {
+			t.Errorf("activation id %d issued twice", r.activationID)
+		}
+		activations[r.activationID] = true
+	}
+	for service, reserved := range numbers {
+		if len(reserved) != poolSize {
+			t.Errorf("%s: %d numbers reserved, want %d", service, len(reserved), poolSize)
+		}
+		if _, _, err := f.Store.ReserveNumber(f.query(t, service), f.AccountID, 1); err != storage.ErrNotFound {
+			t.Errorf("%s: exhausted pool: err = %v, want ErrNotFound", service, err)
+		}
+	}
+	f.checkBalance(t, 100-2*poolSize, 2*poolSize)
+}
@@ -42,6 +42,17 @@ type PushSMSRequest struct {
 	SMS          string `json:"sms"`
 }
 
+type GetStatusRequest struct {
+	BaseRequest
+	ActivationId uint64 `json:"activationId"`
+}
+
+type WaitSMSRequest struct {
+	BaseRequest
+	ActivationId uint64 `json:"activationId"`
+	Timeout      int    `json:"timeout"`
+}
+
 type BaseResponse struct {
 	Status string `json:"status"`
 }
@@ -56,6 +67,16 @@ type GetServicesResponse struct {
 	CountryList []CountryList `json:"countryList"`
 }
 
+type PriceCountryList struct {
+	Country     string                        `json:"country"`
+	OperatorMap map[string]map[string]float64 `json:"operatorMap"`
+}
+
+type GetPricesResponse struct {
+	BaseResponse
+	CountryList []PriceCountryList `json:"countryList"`
+}
+
 type GetNumberResponse struct {
 	BaseResponse
 	Number       uint64 `json:"number,omitempty"`
@@ -64,6 +85,22 @@ type GetNumberResponse struct {
 	Voice        bool   `json:"voice,omitempty"`
 }
 
+type GetStatusResponse struct {
+	BaseResponse
+	ActivationId     uint64   `json:"activationId"`
+	ActivationStatus int      `json:"activationStatus"`
+	Number           uint64   `json:"number"`
+	Service          string   `json:"service"`
+	SMS              []string `json:"sms"`
+	Code             string   `json:"code,omitempty"`
+}
+
+type GetBalanceResponse struct {
+	BaseResponse
+	Balance float64 `json:"balance"`
+	Held    float64 `json:"held"`
+}
+
 type Country struct {
 	ID   int    `json:"id"`
 	Code string `json:"code"`